@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"golang.org/x/crypto/bcrypt"
@@ -12,10 +14,11 @@ import (
 
 // AdminStats represents database statistics
 type AdminStats struct {
-	Products   int     `json:"products"`
-	Users      int     `json:"users"`
-	Orders     int     `json:"orders"`
-	TotalValue float64 `json:"totalValue"`
+	Products   int    `json:"products"`
+	Users      int    `json:"users"`
+	Orders     int    `json:"orders"`
+	TotalValue Money  `json:"totalValue"`
+	Currency   string `json:"currency"`
 }
 
 // DatabaseConnection represents connection test request
@@ -29,13 +32,10 @@ type DatabaseConnection struct {
 
 // testConnection handles POST /api/admin/test-connection
 func testConnection(c *gin.Context) {
+	ctx := c.Request.Context()
 	var conn DatabaseConnection
 	if err := c.ShouldBindJSON(&conn); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   "Invalid request",
-			Message: err.Error(),
-		})
+		respondValidationError(c, err)
 		return
 	}
 
@@ -45,21 +45,15 @@ func testConnection(c *gin.Context) {
 
 	testDB, err := sql.Open("postgres", connStr)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Connection failed",
-			Message: err.Error(),
-		})
+		// The error describes the caller's own connection settings, so it is
+		// returned as-is to help diagnose them
+		respondError(c, ErrConnectionFailed, err.Error())
 		return
 	}
 	defer testDB.Close()
 
-	if err := testDB.Ping(); err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Connection test failed",
-			Message: err.Error(),
-		})
+	if err := testDB.PingContext(ctx); err != nil {
+		respondError(c, ErrConnectionFailed, err.Error())
 		return
 	}
 
@@ -71,43 +65,29 @@ func testConnection(c *gin.Context) {
 
 // getStats handles GET /api/admin/stats
 func getStats(c *gin.Context) {
+	ctx := c.Request.Context()
 	if db == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			Success: false,
-			Error:   "Database not connected",
-		})
+		respondError(c, ErrDatabaseUnavailable, "")
 		return
 	}
 
-	stats := AdminStats{}
+	stats := AdminStats{Currency: baseCurrency}
 
 	// Get products count
-	if err := db.QueryRow(`SELECT COUNT(*) FROM products`).Scan(&stats.Products); err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to get products count",
-			Message: err.Error(),
-		})
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM products WHERE deleted_at IS NULL`).Scan(&stats.Products); err != nil {
+		respondInternalError(c, "Failed to get products count", err)
 		return
 	}
 
 	// Get users count
-	if err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&stats.Users); err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to get users count",
-			Message: err.Error(),
-		})
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE deleted_at IS NULL`).Scan(&stats.Users); err != nil {
+		respondInternalError(c, "Failed to get users count", err)
 		return
 	}
 
-	// Get orders count and total value
-	if err := db.QueryRow(`SELECT COUNT(*), COALESCE(SUM(total), 0) FROM orders`).Scan(&stats.Orders, &stats.TotalValue); err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to get orders stats",
-			Message: err.Error(),
-		})
+	// Get orders count and total value net of refunds, converted back to the base currency
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(ROUND((total - refunded) / exchange_rate, 2)), 0) FROM orders WHERE deleted_at IS NULL`).Scan(&stats.Orders, &stats.TotalValue); err != nil {
+		respondInternalError(c, "Failed to get orders stats", err)
 		return
 	}
 
@@ -119,21 +99,15 @@ func getStats(c *gin.Context) {
 
 // getAllUsers handles GET /api/admin/users
 func getAllUsers(c *gin.Context) {
+	ctx := c.Request.Context()
 	if db == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			Success: false,
-			Error:   "Database not connected",
-		})
+		respondError(c, ErrDatabaseUnavailable, "")
 		return
 	}
 
-	rows, err := db.Query(`SELECT id, username, email, name, role FROM users ORDER BY id`)
+	rows, err := db.QueryContext(ctx, `SELECT id, username, email, name, role, deleted_at FROM users WHERE deleted_at IS NULL OR $1 ORDER BY id`, includeDeleted(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to fetch users",
-			Message: err.Error(),
-		})
+		respondInternalError(c, "Failed to fetch users", err)
 		return
 	}
 	defer rows.Close()
@@ -141,12 +115,8 @@ func getAllUsers(c *gin.Context) {
 	users := make([]User, 0)
 	for rows.Next() {
 		var user User
-		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Name, &user.Role); err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Success: false,
-				Error:   "Failed to scan user data",
-				Message: err.Error(),
-			})
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Name, &user.Role, &user.DeletedAt); err != nil {
+			respondInternalError(c, "Failed to scan user data", err)
 			return
 		}
 		users = append(users, user)
@@ -160,25 +130,29 @@ func getAllUsers(c *gin.Context) {
 
 // seedDatabase handles POST /api/admin/seed
 func seedDatabase(c *gin.Context) {
+	ctx := c.Request.Context()
 	if db == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			Success: false,
-			Error:   "Database not connected",
-		})
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+
+	if err := seedCategories(ctx); err != nil {
+		respondInternalError(c, "Failed to seed categories", err)
 		return
 	}
 
 	// Seed products
 	for _, p := range mockProducts {
-		_, err := db.Exec(`INSERT INTO products (name, description, price, category, image, stock) VALUES ($1,$2,$3,$4,$5,$6) ON CONFLICT DO NOTHING`,
-			p.Name, p.Description, p.Price, p.Category, p.Image, p.Stock,
-		)
+		var id int
+		err := db.QueryRowContext(ctx, `INSERT INTO products (name, description, price, currency, category, image, stock, reorder_threshold, weight_grams) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) RETURNING id`,
+			p.Name, p.Description, p.Price, p.Currency, p.Category, p.Image, p.Stock, p.ReorderThreshold, p.Weight,
+		).Scan(&id)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Success: false,
-				Error:   "Failed to seed products",
-				Message: err.Error(),
-			})
+			respondInternalError(c, "Failed to seed products", err)
+			return
+		}
+		if err := seedProductVariants(ctx, id, p.Variants); err != nil {
+			respondInternalError(c, "Failed to seed product variants", err)
 			return
 		}
 	}
@@ -199,26 +173,22 @@ func seedDatabase(c *gin.Context) {
 	for _, u := range seedUsers {
 		hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Success: false,
-				Error:   "Failed to hash password",
-				Message: err.Error(),
-			})
+			respondInternalError(c, "Failed to hash password", err)
 			return
 		}
 
-		_, err = db.Exec(`INSERT INTO users (username, email, name, role, password_hash) VALUES ($1,$2,$3,$4,$5) ON CONFLICT DO NOTHING`,
+		_, err = db.ExecContext(ctx, `INSERT INTO users (username, email, name, role, password_hash) VALUES ($1,$2,$3,$4,$5) ON CONFLICT DO NOTHING`,
 			u.Username, u.Email, u.Name, u.Role, string(hash),
 		)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Success: false,
-				Error:   "Failed to seed users",
-				Message: err.Error(),
-			})
+			respondInternalError(c, "Failed to seed users", err)
 			return
 		}
 	}
+	if err := recordAudit(c, db, "seed", "database", "", nil, gin.H{"products": len(mockProducts), "users": len(seedUsers)}); err != nil {
+		respondInternalError(c, "Failed to record audit entry", err)
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -226,156 +196,132 @@ func seedDatabase(c *gin.Context) {
 	})
 }
 
-// clearDatabase handles POST /api/admin/clear
+// clearTables are the tables clearDatabase empties, in reverse dependency order
+var clearTables = []string{"coupon_redemptions", "order_items", "orders", "products", "users"}
+
+// clearCascades are emptied along with clearTables by their foreign keys
+var clearCascades = []string{"order_discounts", "payments", "refunds", "refund_items", "product_variants", "product_images", "inventory_movements"}
+
+// clearDatabase handles POST /api/admin/clear. Without an X-Confirmation-Token
+// header it only previews the rows that would be deleted; ?preserveAdmins=true
+// keeps the admin accounts. Coupons are kept with their usage counts reset.
 func clearDatabase(c *gin.Context) {
+	ctx := c.Request.Context()
 	if db == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			Success: false,
-			Error:   "Database not connected",
-		})
+		respondError(c, ErrDatabaseUnavailable, "")
 		return
 	}
 
-	// Clear all data (in reverse dependency order)
-	tables := []string{"order_items", "orders", "products", "users"}
-	for _, table := range tables {
-		_, err := db.Exec(fmt.Sprintf(`DELETE FROM %s`, table))
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{
-				Success: false,
-				Error:   fmt.Sprintf("Failed to clear %s", table),
-				Message: err.Error(),
-			})
-			return
-		}
+	preserveAdmins, _ := strconv.ParseBool(c.Query("preserveAdmins"))
+	where := map[string]string{}
+	if preserveAdmins {
+		where["users"] = "role <> 'admin'"
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Database cleared successfully",
-	})
-}
-
-// exportData handles GET /api/admin/export
-func exportData(c *gin.Context) {
-	if db == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			Success: false,
-			Error:   "Database not connected",
-		})
+	preview := func(ctx context.Context) (map[string]int64, error) {
+		return countRows(ctx, append(append([]string{}, clearTables...), clearCascades...), where)
+	}
+	if !confirmDestructive(c, "clear", strconv.FormatBool(preserveAdmins), preview) {
 		return
 	}
 
-	export := make(map[string]interface{})
-
-	// Export products
-	productRows, err := db.Query(`SELECT id, name, description, price, category, image, stock FROM products`)
+	imageKeys, err := queryImageKeys(ctx, `SELECT storage_key, renditions FROM product_images`)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to export products",
-			Message: err.Error(),
-		})
+		respondInternalError(c, "Failed to clear product_images", err)
 		return
 	}
-	defer productRows.Close()
 
-	products := make([]Product, 0)
-	for productRows.Next() {
-		var p Product
-		if err := productRows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Category, &p.Image, &p.Stock); err != nil {
-			continue
-		}
-		products = append(products, p)
-	}
-	export["products"] = products
-
-	// Export users (without passwords)
-	userRows, err := db.Query(`SELECT id, username, email, name, role FROM users`)
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to export users",
-			Message: err.Error(),
-		})
+		respondInternalError(c, "Failed to clear database", err)
 		return
 	}
-	defer userRows.Close()
+	defer tx.Rollback()
 
-	users := make([]User, 0)
-	for userRows.Next() {
-		var u User
-		if err := userRows.Scan(&u.ID, &u.Username, &u.Email, &u.Name, &u.Role); err != nil {
-			continue
+	deleted := make(map[string]int64, len(clearTables))
+	for _, table := range clearTables {
+		query := fmt.Sprintf(`DELETE FROM %s`, table)
+		if cond := where[table]; cond != "" {
+			query += " WHERE " + cond
 		}
-		users = append(users, u)
+		res, err := tx.ExecContext(ctx, query)
+		if err != nil {
+			respondInternalError(c, fmt.Sprintf("Failed to clear %s", table), err)
+			return
+		}
+		deleted[table], _ = res.RowsAffected()
 	}
-	export["users"] = users
-
-	// Export orders
-	orderRows, err := db.Query(`SELECT id, order_number, customer_name, customer_email, customer_address, subtotal, shipping, tax, total, status, created_at, updated_at FROM orders`)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to export orders",
-			Message: err.Error(),
-		})
+	// The orders that used the coupons are gone
+	if _, err := tx.ExecContext(ctx, `UPDATE coupons SET times_used = 0 WHERE times_used <> 0`); err != nil {
+		respondInternalError(c, "Failed to reset coupon usage", err)
 		return
 	}
-	defer orderRows.Close()
-
-	orders := make([]Order, 0)
-	for orderRows.Next() {
-		var o Order
-		if err := orderRows.Scan(&o.ID, &o.OrderNumber, &o.Customer.Name, &o.Customer.Email, &o.Customer.Address, &o.Subtotal, &o.Shipping, &o.Tax, &o.Total, &o.Status, &o.CreatedAt, &o.UpdatedAt); err != nil {
-			continue
-		}
-		orders = append(orders, o)
+	if err := recordAudit(c, tx, "clear", "database", "", nil, gin.H{"deleted": deleted, "preserveAdmins": preserveAdmins}); err != nil {
+		respondInternalError(c, "Failed to clear database", err)
+		return
 	}
-	export["orders"] = orders
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, "Failed to clear database", err)
+		return
+	}
+	purgeImages(ctx, imageKeys)
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data":    export,
+		"message": "Database cleared successfully",
+		"data":    deleted,
 	})
 }
 
 // deleteProduct handles DELETE /api/admin/products/:id
 func deleteProduct(c *gin.Context) {
+	ctx := c.Request.Context()
 	if db == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			Success: false,
-			Error:   "Database not connected",
-		})
+		respondError(c, ErrDatabaseUnavailable, "")
 		return
 	}
 
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   "Invalid product ID",
-		})
+		respondError(c, ErrInvalidID, "Invalid product ID")
 		return
 	}
 
-	result, err := db.Exec(`DELETE FROM products WHERE id = $1`, id)
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to delete product",
-			Message: err.Error(),
-		})
+		respondInternalError(c, "Failed to delete product", err)
+		return
+	}
+	defer tx.Rollback()
+	before, err := snapshotRow(ctx, tx, "products", "id", id)
+	if err != nil {
+		respondInternalError(c, "Failed to delete product", err)
+		return
+	}
+
+	// Products stay referenced by past orders, so they are only marked deleted
+	result, err := tx.ExecContext(ctx, `UPDATE products SET deleted_at = $2 WHERE id = $1 AND deleted_at IS NULL`, id, time.Now())
+	if err != nil {
+		respondInternalError(c, "Failed to delete product", err)
 		return
 	}
 
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, ErrorResponse{
-			Success: false,
-			Error:   "Product not found",
-		})
+		respondError(c, ErrProductNotFound, "")
+		return
+	}
+	after, err := snapshotRow(ctx, tx, "products", "id", id)
+	if err != nil {
+		respondInternalError(c, "Failed to delete product", err)
+		return
+	}
+	if err := recordAudit(c, tx, "delete", "product", strconv.Itoa(id), before, after); err != nil {
+		respondInternalError(c, "Failed to delete product", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, "Failed to delete product", err)
 		return
 	}
 
@@ -387,43 +333,59 @@ func deleteProduct(c *gin.Context) {
 
 // deleteUser handles DELETE /api/admin/users/:id
 func deleteUser(c *gin.Context) {
+	ctx := c.Request.Context()
 	if db == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			Success: false,
-			Error:   "Database not connected",
-		})
+		respondError(c, ErrDatabaseUnavailable, "")
 		return
 	}
 
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   "Invalid user ID",
-		})
+		respondError(c, ErrInvalidID, "Invalid user ID")
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, "Failed to delete user", err)
+		return
+	}
+	defer tx.Rollback()
+	before, err := snapshotRow(ctx, tx, "users", "id", id)
+	if err != nil {
+		respondInternalError(c, "Failed to delete user", err)
 		return
 	}
 
-	result, err := db.Exec(`DELETE FROM users WHERE id = $1`, id)
+	result, err := tx.ExecContext(ctx, `UPDATE users SET deleted_at = $2 WHERE id = $1 AND deleted_at IS NULL`, id, time.Now())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to delete user",
-			Message: err.Error(),
-		})
+		respondInternalError(c, "Failed to delete user", err)
 		return
 	}
 
 	rowsAffected, _ := result.RowsAffected()
 	if rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, ErrorResponse{
-			Success: false,
-			Error:   "User not found",
-		})
+		respondError(c, ErrUserNotFound, "")
+		return
+	}
+	after, err := snapshotRow(ctx, tx, "users", "id", id)
+	if err != nil {
+		respondInternalError(c, "Failed to delete user", err)
+		return
+	}
+	if err := recordAudit(c, tx, "delete", "user", strconv.Itoa(id), before, after); err != nil {
+		respondInternalError(c, "Failed to delete user", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, "Failed to delete user", err)
 		return
 	}
 
+	// End the user's sessions
+	endUserSessions(id)
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "User deleted successfully",
@@ -432,33 +394,66 @@ func deleteUser(c *gin.Context) {
 
 // createProduct handles POST /api/admin/products
 func createProduct(c *gin.Context) {
+	ctx := c.Request.Context()
 	if db == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			Success: false,
-			Error:   "Database not connected",
-		})
+		respondError(c, ErrDatabaseUnavailable, "")
 		return
 	}
 
 	var product Product
 	if err := c.ShouldBindJSON(&product); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   "Invalid product data",
-			Message: err.Error(),
-		})
+		respondValidationError(c, err)
+		return
+	}
+	product.Currency = normalizeCurrency(product.Currency)
+	product.SKU = normalizeSKU(product.SKU)
+	details, err := validateProductCategory(ctx, product.Category)
+	if err != nil {
+		respondInternalError(c, "Failed to load categories", err)
+		return
+	}
+	if len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", details...)
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, "Failed to create product", err)
 		return
 	}
+	defer tx.Rollback()
 
 	var id int
-	err := db.QueryRow(`INSERT INTO products (name, description, price, category, image, stock) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id`,
-		product.Name, product.Description, product.Price, product.Category, product.Image, product.Stock).Scan(&id)
+	err = tx.QueryRowContext(ctx, `INSERT INTO products (sku, name, description, price, currency, category, image, stock, reorder_threshold, weight_grams) VALUES (NULLIF($1, ''),$2,$3,$4,$5,$6,$7,0,$8,$9) RETURNING id`,
+		product.SKU, product.Name, product.Description, product.Price, product.Currency, product.Category, product.Image, product.ReorderThreshold, product.Weight).Scan(&id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to create product",
-			Message: err.Error(),
-		})
+		if isUniqueViolation(err) {
+			respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "sku", Rule: "unique", Message: "is already in use"})
+			return
+		}
+		respondInternalError(c, "Failed to create product", err)
+		return
+	}
+	if product.Stock > 0 {
+		movement := InventoryMovement{ProductID: id, Type: movementRestock, Quantity: product.Stock, Reason: "Initial stock"}
+		movement.setActor(c)
+		if err := moveStock(ctx, tx, &movement); err != nil {
+			respondInternalError(c, "Failed to create product", err)
+			return
+		}
+	}
+	after, err := snapshotRow(ctx, tx, "products", "id", id)
+	if err != nil {
+		respondInternalError(c, "Failed to create product", err)
+		return
+	}
+	if err := recordAudit(c, tx, "create", "product", strconv.Itoa(id), nil, after); err != nil {
+		respondInternalError(c, "Failed to create product", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, "Failed to create product", err)
 		return
 	}
 
@@ -472,51 +467,77 @@ func createProduct(c *gin.Context) {
 
 // updateProduct handles PUT /api/admin/products/:id
 func updateProduct(c *gin.Context) {
+	ctx := c.Request.Context()
 	if db == nil {
-		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
-			Success: false,
-			Error:   "Database not connected",
-		})
+		respondError(c, ErrDatabaseUnavailable, "")
 		return
 	}
 
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   "Invalid product ID",
-		})
+		respondError(c, ErrInvalidID, "Invalid product ID")
 		return
 	}
 
 	var product Product
 	if err := c.ShouldBindJSON(&product); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   "Invalid product data",
-			Message: err.Error(),
-		})
+		respondValidationError(c, err)
+		return
+	}
+	product.Currency = normalizeCurrency(product.Currency)
+	product.SKU = normalizeSKU(product.SKU)
+	details, err := validateProductCategory(ctx, product.Category)
+	if err != nil {
+		respondInternalError(c, "Failed to load categories", err)
+		return
+	}
+	if len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", details...)
 		return
 	}
 
-	result, err := db.Exec(`UPDATE products SET name=$1, description=$2, price=$3, category=$4, image=$5, stock=$6 WHERE id=$7`,
-		product.Name, product.Description, product.Price, product.Category, product.Image, product.Stock, id)
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{
-			Success: false,
-			Error:   "Failed to update product",
-			Message: err.Error(),
-		})
+		respondInternalError(c, "Failed to update product", err)
+		return
+	}
+	defer tx.Rollback()
+	before, err := snapshotRow(ctx, tx, "products", "id", id)
+	if err != nil {
+		respondInternalError(c, "Failed to update product", err)
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, ErrorResponse{
-			Success: false,
-			Error:   "Product not found",
-		})
+	// Stock only changes through inventory movements, so an edit made from a
+	// stale form can't overwrite sales made since; the response has the
+	// current stock
+	err = tx.QueryRowContext(ctx, `UPDATE products SET name=$1, description=$2, price=$3, currency=$4, category=$5, image=$6, weight_grams=$7, sku=NULLIF($9, ''), reorder_threshold=$10
+		WHERE id=$8 AND deleted_at IS NULL RETURNING stock`,
+		product.Name, product.Description, product.Price, product.Currency, product.Category, product.Image, product.Weight, id, product.SKU, product.ReorderThreshold).Scan(&product.Stock)
+	if err == sql.ErrNoRows {
+		respondError(c, ErrProductNotFound, "")
+		return
+	}
+	if err != nil {
+		if isUniqueViolation(err) {
+			respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "sku", Rule: "unique", Message: "is already in use"})
+			return
+		}
+		respondInternalError(c, "Failed to update product", err)
+		return
+	}
+	after, err := snapshotRow(ctx, tx, "products", "id", id)
+	if err != nil {
+		respondInternalError(c, "Failed to update product", err)
+		return
+	}
+	if err := recordAudit(c, tx, "update", "product", strconv.Itoa(id), before, after); err != nil {
+		respondInternalError(c, "Failed to update product", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, "Failed to update product", err)
 		return
 	}
 
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// AuditEntry is one row of the append-only audit log. Before and After hold
+// only the fields the action changed; a create has no Before and a hard
+// delete no After.
+type AuditEntry struct {
+	ID        int64           `json:"id"`
+	ActorID   *int            `json:"actorId"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Entity    string          `json:"entity"`
+	EntityID  string          `json:"entityId"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	IP        string          `json:"ip"`
+	RequestID string          `json:"requestId"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// In-memory audit log used when running on mock data
+var (
+	mockAuditMu sync.Mutex
+	mockAudit   []AuditEntry
+)
+
+// auditSecretFields never make it into the audit log, whichever side they are on
+var auditSecretFields = []string{"password_hash", "passwordHash", "password", "client_secret", "clientSecret"}
+
+// auditFields decodes a snapshot into its top-level fields. Snapshots are
+// either JSON already, as from snapshotRow, or values to marshal.
+func auditFields(v interface{}) (map[string]json.RawMessage, error) {
+	var data []byte
+	switch s := v.(type) {
+	case nil:
+		return nil, nil
+	case json.RawMessage:
+		data = s
+	default:
+		var err error
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for _, name := range auditSecretFields {
+		delete(fields, name)
+	}
+	return fields, nil
+}
+
+// auditDiff drops the fields before and after have in common
+func auditDiff(before, after interface{}) (json.RawMessage, json.RawMessage, error) {
+	b, err := auditFields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	a, err := auditFields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+	if b != nil && a != nil {
+		for name, v := range b {
+			if w, ok := a[name]; ok && bytes.Equal(v, w) {
+				delete(b, name)
+				delete(a, name)
+			}
+		}
+	}
+	beforeJSON, err := encodeAuditFields(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterJSON, err := encodeAuditFields(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	return beforeJSON, afterJSON, nil
+}
+
+// encodeAuditFields is the inverse of auditFields, keeping nil as nil
+func encodeAuditFields(fields map[string]json.RawMessage) (json.RawMessage, error) {
+	if fields == nil {
+		return nil, nil
+	}
+	return json.Marshal(fields)
+}
+
+// recordAudit appends an entry for action on entity by the request's user.
+// With a database it is written through ex, so passing the handler's
+// transaction makes the entry commit or roll back with the change itself.
+func recordAudit(c *gin.Context, ex execer, action, entity, entityID string, before, after interface{}) error {
+	b, a, err := auditDiff(before, after)
+	if err != nil {
+		return fmt.Errorf("audit %s %s: %w", action, entity, err)
+	}
+	entry := AuditEntry{
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		Before:    b,
+		After:     a,
+		IP:        c.ClientIP(),
+		RequestID: requestID(c),
+		CreatedAt: time.Now(),
+	}
+	if user, ok := sessionUser(c); ok {
+		id := user.ID
+		entry.ActorID = &id
+		entry.Actor = user.Username
+	}
+
+	if db == nil {
+		mockAuditMu.Lock()
+		entry.ID = int64(len(mockAudit) + 1)
+		mockAudit = append(mockAudit, entry)
+		mockAuditMu.Unlock()
+		return nil
+	}
+	_, err = ex.ExecContext(c.Request.Context(), `INSERT INTO audit_log (actor_id, actor, action, entity, entity_id, before, after, ip, request_id, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
+		entry.ActorID, entry.Actor, entry.Action, entry.Entity, entry.EntityID, nullJSON(entry.Before), nullJSON(entry.After), entry.IP, entry.RequestID, entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("audit %s %s: %w", action, entity, err)
+	}
+	return nil
+}
+
+// nullJSON maps an empty snapshot to SQL NULL
+func nullJSON(data json.RawMessage) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+	return string(data)
+}
+
+// snapshotRow returns the row of table whose key column equals id as JSON,
+// locking it until tx ends. A missing row gives a nil snapshot.
+func snapshotRow(ctx context.Context, tx *sql.Tx, table, key string, id interface{}) (json.RawMessage, error) {
+	var data []byte
+	err := tx.QueryRowContext(ctx, fmt.Sprintf(`SELECT row_to_json(t) FROM %s t WHERE t.%s = $1 FOR UPDATE`, table, key), id).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// auditFilter holds the query parameters of GET /api/admin/audit
+type auditFilter struct {
+	actorID  *int
+	entity   string
+	entityID string
+	from, to *time.Time
+	limit    int
+	offset   int
+}
+
+// parseAuditFilter reads the filters, reporting invalid ones as field errors
+func parseAuditFilter(c *gin.Context) (auditFilter, []FieldError) {
+	f := auditFilter{entity: c.Query("entity"), entityID: c.Query("entityId"), limit: defaultAuditLimit}
+	var details []FieldError
+	if s := c.Query("actorId"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			details = append(details, FieldError{Field: "actorId", Rule: "numeric", Message: "must be a user ID"})
+		}
+		f.actorID = &id
+	}
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &f.from}, {"to", &f.to}} {
+		if s := c.Query(p.name); s != "" {
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				details = append(details, FieldError{Field: p.name, Rule: "datetime", Message: "must be an RFC 3339 timestamp"})
+			}
+			*p.dst = &t
+		}
+	}
+	if s := c.Query("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			details = append(details, FieldError{Field: "limit", Rule: "max", Message: fmt.Sprintf("must be between 1 and %d", maxAuditLimit)})
+		}
+		f.limit = n
+	}
+	if s := c.Query("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			details = append(details, FieldError{Field: "offset", Rule: "min", Message: "must not be negative"})
+		}
+		f.offset = n
+	}
+	return f, details
+}
+
+// matches applies the filter to an in-memory entry
+func (f auditFilter) matches(e AuditEntry) bool {
+	switch {
+	case f.actorID != nil && (e.ActorID == nil || *e.ActorID != *f.actorID):
+		return false
+	case f.entity != "" && e.Entity != f.entity:
+		return false
+	case f.entityID != "" && e.EntityID != f.entityID:
+		return false
+	case f.from != nil && e.CreatedAt.Before(*f.from):
+		return false
+	case f.to != nil && !e.CreatedAt.Before(*f.to):
+		return false
+	}
+	return true
+}
+
+// getAuditLog handles GET /api/admin/audit. Entries come newest first and
+// can be filtered by ?actorId, ?entity, ?entityId and a ?from/?to time
+// range (RFC 3339, to exclusive), and paged with ?limit and ?offset.
+func getAuditLog(c *gin.Context) {
+	ctx := c.Request.Context()
+	f, details := parseAuditFilter(c)
+	if len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more filters are invalid", details...)
+		return
+	}
+
+	entries := make([]AuditEntry, 0)
+	if db != nil {
+		var filters []string
+		var args []interface{}
+		add := func(cond string, arg interface{}) {
+			args = append(args, arg)
+			filters = append(filters, fmt.Sprintf(cond, len(args)))
+		}
+		if f.actorID != nil {
+			add("actor_id = $%d", *f.actorID)
+		}
+		if f.entity != "" {
+			add("entity = $%d", f.entity)
+		}
+		if f.entityID != "" {
+			add("entity_id = $%d", f.entityID)
+		}
+		if f.from != nil {
+			add("created_at >= $%d", *f.from)
+		}
+		if f.to != nil {
+			add("created_at < $%d", *f.to)
+		}
+		query := `SELECT id, actor_id, actor, action, entity, entity_id, before, after, ip, request_id, created_at FROM audit_log`
+		if len(filters) > 0 {
+			query += " WHERE " + strings.Join(filters, " AND ")
+		}
+		query += fmt.Sprintf(" ORDER BY id DESC LIMIT %d OFFSET %d", f.limit, f.offset)
+
+		rows, err := db.QueryContext(ctx, query, args...)
+		if err != nil {
+			respondInternalError(c, "Failed to fetch audit log", err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var e AuditEntry
+			var actorID sql.NullInt64
+			var before, after []byte
+			if err := rows.Scan(&e.ID, &actorID, &e.Actor, &e.Action, &e.Entity, &e.EntityID, &before, &after, &e.IP, &e.RequestID, &e.CreatedAt); err != nil {
+				respondInternalError(c, "Failed to fetch audit log", err)
+				return
+			}
+			if actorID.Valid {
+				id := int(actorID.Int64)
+				e.ActorID = &id
+			}
+			e.Before, e.After = before, after
+			entries = append(entries, e)
+		}
+		if err := rows.Err(); err != nil {
+			respondInternalError(c, "Failed to fetch audit log", err)
+			return
+		}
+	} else {
+		mockAuditMu.Lock()
+		for _, e := range mockAudit {
+			if f.matches(e) {
+				entries = append(entries, e)
+			}
+		}
+		mockAuditMu.Unlock()
+		sort.Slice(entries, func(i, j int) bool { return entries[i].ID > entries[j].ID })
+		if f.offset >= len(entries) {
+			entries = entries[:0]
+		} else {
+			entries = entries[f.offset:]
+		}
+		if len(entries) > f.limit {
+			entries = entries[:f.limit]
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    entries,
+	})
+}
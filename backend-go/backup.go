@@ -0,0 +1,496 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// backupFormat identifies backup files; backupVersion is bumped whenever
+// their layout changes
+const (
+	backupFormat  = "vueshop-backup"
+	backupVersion = 3
+)
+
+// maxBackupSize caps uploaded backup files
+const maxBackupSize = 256 << 20
+
+// Conditions picking the rows of a table changed since $1
+const (
+	updatedSince = `t.updated_at >= $1`
+	createdSince = `t.created_at >= $1`
+)
+
+// backupTable is a table included in backups, dumped in order of its
+// primary key. Serial tables get their ID sequence moved past the restored
+// rows. since selects the rows of an incremental export.
+type backupTable struct {
+	name   string
+	key    string
+	serial bool
+	since  string
+}
+
+// backupTables lists the tables in a backup in the order they are restored,
+// parents before the rows that reference them; they are cleared in reverse.
+// Product images are kept as rows only; their files stay in image storage.
+// Order lines and discounts count as changed with their order, refund lines
+// with their refund.
+var backupTables = []backupTable{
+	{"categories", "id", true, updatedSince},
+	{"products", "id", true, updatedSince},
+	{"product_variants", "id", true, updatedSince},
+	{"product_images", "id", true, createdSince},
+	{"users", "id", true, updatedSince},
+	{"orders", "id", false, updatedSince},
+	{"order_items", "id", true, `t.order_id IN (SELECT id FROM orders WHERE updated_at >= $1)`},
+	{"order_discounts", "id", true, `t.order_id IN (SELECT id FROM orders WHERE updated_at >= $1)`},
+	{"payments", "id", false, updatedSince},
+	{"refunds", "id", false, createdSince},
+	{"refund_items", "id", true, `t.refund_id IN (SELECT id FROM refunds WHERE created_at >= $1)`},
+	{"inventory_movements", "id", true, createdSince},
+	{"tax_rules", "id", true, updatedSince},
+	{"shipping_methods", "id", true, updatedSince},
+	{"coupons", "id", true, updatedSince},
+	{"coupon_redemptions", "id", true, createdSince},
+	{"exchange_rates", "currency", false, updatedSince},
+}
+
+// BackupHeader describes a backup. Since and Entities are set on
+// incremental and partial exports, which can't be restored.
+type BackupHeader struct {
+	Format        string     `json:"format"`
+	Version       int        `json:"version"`
+	SchemaVersion int        `json:"schemaVersion"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	Since         *time.Time `json:"since,omitempty"`
+	Entities      []string   `json:"entities,omitempty"`
+}
+
+// Backup is a copy of the backupTables. Rows are kept exactly as Postgres
+// encodes them with row_to_json, including password hashes, so a backup
+// must be stored as carefully as the database itself. Checksum covers
+// everything else and SchemaVersion must match the database it is restored
+// into.
+type Backup struct {
+	BackupHeader
+	Tables   map[string][]json.RawMessage `json:"tables"`
+	Checksum string                       `json:"checksum"`
+}
+
+// backupHash computes a backup checksum from the header and the rows of
+// each of the backupTables in turn. Rows are compacted first so reformatting
+// the file doesn't change it.
+type backupHash struct {
+	h   hash.Hash
+	row bytes.Buffer
+}
+
+func newBackupHash(header BackupHeader) *backupHash {
+	bh := &backupHash{h: sha256.New()}
+	since := ""
+	if header.Since != nil {
+		since = header.Since.UTC().Format(time.RFC3339Nano)
+	}
+	fmt.Fprintf(bh.h, "%s\n%d\n%d\n%s\n%s\n", header.Format, header.Version, header.SchemaVersion, since, strings.Join(header.Entities, ","))
+	return bh
+}
+
+func (bh *backupHash) table(name string) {
+	fmt.Fprintf(bh.h, "%s\n", name)
+}
+
+func (bh *backupHash) add(raw []byte) error {
+	bh.row.Reset()
+	if err := json.Compact(&bh.row, raw); err != nil {
+		return err
+	}
+	bh.row.WriteByte('\n')
+	bh.h.Write(bh.row.Bytes())
+	return nil
+}
+
+func (bh *backupHash) sum() string {
+	return "sha256:" + hex.EncodeToString(bh.h.Sum(nil))
+}
+
+// checksum recomputes the checksum of a decoded backup
+func (b *Backup) checksum() (string, error) {
+	bh := newBackupHash(b.BackupHeader)
+	for _, t := range backupTables {
+		bh.table(t.name)
+		for _, raw := range b.Tables[t.name] {
+			if err := bh.add(raw); err != nil {
+				return "", err
+			}
+		}
+	}
+	return bh.sum(), nil
+}
+
+// exportData handles GET /api/admin/export. It streams a Backup of every
+// row, soft-deleted ones included, that importData can restore, reading one
+// row at a time so the export never sits in memory:
+//
+//   - ?entities=orders,order_items limits it to those tables
+//   - ?since=<RFC 3339 time> limits it to rows changed since then
+//   - ?format=ndjson writes the header, then one {"table","row"} object per
+//     line, then the checksum, instead of a single JSON document
+//
+// The response is gzipped when the client accepts it.
+func exportData(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "ndjson" {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "format", Rule: "oneof", Message: "must be one of: json, ndjson"})
+		return
+	}
+	header := BackupHeader{Format: backupFormat, Version: backupVersion, CreatedAt: time.Now().UTC()}
+	selected := make(map[string]bool)
+	if entities := c.Query("entities"); entities != "" {
+		for _, name := range strings.Split(entities, ",") {
+			name = strings.TrimSpace(name)
+			if !isBackupTable(name) {
+				respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "entities", Rule: "oneof", Message: fmt.Sprintf("%q is not an exported table", name)})
+				return
+			}
+			selected[name] = true
+		}
+		if len(selected) < len(backupTables) {
+			for _, t := range backupTables {
+				if selected[t.name] {
+					header.Entities = append(header.Entities, t.name)
+				}
+			}
+		}
+	}
+	if s := c.Query("since"); s != "" {
+		since, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "since", Rule: "datetime", Message: "must be an RFC 3339 time such as 2024-01-02T15:04:05Z"})
+			return
+		}
+		header.Since = &since
+	}
+
+	// One read-only snapshot so the tables agree with each other
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		respondInternalError(c, "Failed to export data", err)
+		return
+	}
+	defer tx.Rollback()
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&header.SchemaVersion); err != nil {
+		respondInternalError(c, "Failed to export data", err)
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="vueshop-backup-%s.%s"`, header.CreatedAt.Format("2006-01-02"), format))
+	if format == "ndjson" {
+		c.Header("Content-Type", "application/x-ndjson")
+	} else {
+		c.Header("Content-Type", "application/json; charset=utf-8")
+	}
+	c.Header("Vary", "Accept-Encoding")
+	var w io.Writer = c.Writer
+	if strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") {
+		c.Header("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(c.Writer)
+		defer gz.Close()
+		w = gz
+	}
+	c.Status(http.StatusOK)
+
+	// Headers are gone once the first byte is written, so later failures
+	// can only cut the download short
+	if err := writeBackup(ctx, tx, w, format == "ndjson", header, selected); err != nil {
+		loggerFrom(c).Error("Export failed", "error", err, "format", format)
+		_ = c.Error(err)
+	}
+}
+
+// writeBackup streams the header, the selected tables (all when selected is
+// empty) and the checksum to w
+func writeBackup(ctx context.Context, tx *sql.Tx, w io.Writer, ndjson bool, header BackupHeader, selected map[string]bool) error {
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	bh := newBackupHash(header)
+	if ndjson {
+		if _, err := fmt.Fprintf(w, "%s\n", headerJSON); err != nil {
+			return err
+		}
+	} else if _, err := fmt.Fprintf(w, `%s,"tables":{`, bytes.TrimSuffix(headerJSON, []byte("}"))); err != nil {
+		return err
+	}
+
+	first := true
+	for _, t := range backupTables {
+		bh.table(t.name)
+		if len(selected) > 0 && !selected[t.name] {
+			continue
+		}
+		if !ndjson {
+			sep := ","
+			if first {
+				sep = ""
+			}
+			if _, err := fmt.Fprintf(w, `%s%q:[`, sep, t.name); err != nil {
+				return err
+			}
+		}
+		first = false
+		n := 0
+		err = streamTable(ctx, tx, t, header.Since, func(row []byte) error {
+			if err := bh.add(row); err != nil {
+				return err
+			}
+			var err error
+			switch {
+			case ndjson:
+				_, err = fmt.Fprintf(w, `{"table":%q,"row":%s}`+"\n", t.name, row)
+			case n > 0:
+				_, err = fmt.Fprintf(w, ",%s", row)
+			default:
+				_, err = w.Write(row)
+			}
+			n++
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("export %s: %w", t.name, err)
+		}
+		if !ndjson {
+			if _, err := io.WriteString(w, "]"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if ndjson {
+		_, err := fmt.Fprintf(w, `{"checksum":%q}`+"\n", bh.sum())
+		return err
+	}
+	_, err = fmt.Fprintf(w, `},"checksum":%q}`+"\n", bh.sum())
+	return err
+}
+
+// streamTable calls fn with each row of the table as JSON, limited to the
+// rows changed since the given time when there is one
+func streamTable(ctx context.Context, q queryer, table backupTable, since *time.Time, fn func(row []byte) error) error {
+	query := fmt.Sprintf(`SELECT row_to_json(t) FROM %s t`, table.name)
+	var args []interface{}
+	if since != nil {
+		query += " WHERE " + table.since
+		args = append(args, *since)
+	}
+	rows, err := q.QueryContext(ctx, query+" ORDER BY t."+table.key, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var row []byte
+		if err := rows.Scan(&row); err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// importData handles POST /api/admin/import. The body is a Backup from
+// exportData; it replaces the contents of every backed-up table in one
+// transaction, keeping row IDs, or changes nothing if anything fails. Like
+// clearDatabase it first answers with a preview and confirmation token.
+func importData(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+
+	var backup Backup
+	decoder := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxBackupSize))
+	if err := decoder.Decode(&backup); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	if backup.Format != backupFormat {
+		respondError(c, ErrBackupInvalid, fmt.Sprintf("Not a %s file", backupFormat))
+		return
+	}
+	for name := range backup.Tables {
+		if !isBackupTable(name) {
+			respondError(c, ErrBackupInvalid, fmt.Sprintf("Unknown table %q", name))
+			return
+		}
+	}
+	if backup.Since != nil || len(backup.Entities) > 0 {
+		respondError(c, ErrBackupInvalid, "Incremental and partial exports can't be restored")
+		return
+	}
+	if backup.Version != backupVersion {
+		respondError(c, ErrBackupVersionMismatch, fmt.Sprintf("Backup format version %d cannot be read; this server reads version %d", backup.Version, backupVersion))
+		return
+	}
+	sum, err := backup.checksum()
+	if err != nil {
+		respondError(c, ErrBackupInvalid, "Backup contains malformed rows")
+		return
+	}
+	if sum != backup.Checksum {
+		respondError(c, ErrBackupChecksumMismatch, "The file was changed or damaged after it was exported")
+		return
+	}
+	current, err := schemaVersion(ctx)
+	if err != nil {
+		respondInternalError(c, "Failed to read schema version", err)
+		return
+	}
+	if backup.SchemaVersion != current {
+		respondError(c, ErrBackupVersionMismatch, fmt.Sprintf("Backup is of schema version %d but the database is at version %d", backup.SchemaVersion, current))
+		return
+	}
+
+	// The token is bound to the checksum so only the previewed file restores
+	preview := func(ctx context.Context) (map[string]int64, error) {
+		names := make([]string, len(backupTables))
+		for i, t := range backupTables {
+			names[i] = t.name
+		}
+		return countRows(ctx, names, nil)
+	}
+	if !confirmDestructive(c, "restore", backup.Checksum, preview) {
+		return
+	}
+
+	// Image files only the replaced rows refer to go once the restore commits
+	imageKeys, err := queryImageKeys(ctx, `SELECT storage_key, renditions FROM product_images`)
+	if err != nil {
+		respondInternalError(c, "Failed to restore backup", err)
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, "Failed to restore backup", err)
+		return
+	}
+	defer tx.Rollback()
+
+	for i := len(backupTables) - 1; i >= 0; i-- {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %s`, backupTables[i].name)); err != nil {
+			respondInternalError(c, fmt.Sprintf("Failed to clear %s", backupTables[i].name), err)
+			return
+		}
+	}
+	restored := make(map[string]int, len(backupTables))
+	for _, t := range backupTables {
+		rows := backup.Tables[t.name]
+		restored[t.name] = len(rows)
+		if len(rows) > 0 {
+			data, err := json.Marshal(rows)
+			if err != nil {
+				respondInternalError(c, fmt.Sprintf("Failed to restore %s", t.name), err)
+				return
+			}
+			// Columns come back in table order since the schema versions match
+			_, err = tx.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %[1]s SELECT * FROM json_populate_recordset(NULL::%[1]s, $1)`, t.name), string(data))
+			if err != nil {
+				respondInternalError(c, fmt.Sprintf("Failed to restore %s", t.name), err)
+				return
+			}
+		}
+		if t.serial {
+			_, err := tx.ExecContext(ctx, fmt.Sprintf(`SELECT setval(pg_get_serial_sequence('%[1]s', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM %[1]s`, t.name))
+			if err != nil {
+				respondInternalError(c, fmt.Sprintf("Failed to reset the %s ID sequence", t.name), err)
+				return
+			}
+		}
+	}
+	// New order numbers must continue after the restored ones
+	var lastOrderNumber int
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(SUBSTRING(order_number FROM '^VUE-([0-9]+)$')::INT), 0) FROM orders`).Scan(&lastOrderNumber)
+	if err != nil {
+		respondInternalError(c, "Failed to restore backup", err)
+		return
+	}
+	if err := recordAudit(c, tx, "restore", "database", "", nil, gin.H{"restored": restored, "checksum": backup.Checksum}); err != nil {
+		respondInternalError(c, "Failed to restore backup", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, "Failed to restore backup", err)
+		return
+	}
+	if orphans, err := orphanedImageKeys(ctx, imageKeys); err != nil {
+		loggerFrom(c).Error("Failed to find images orphaned by restore", "error", err)
+	} else {
+		purgeImages(ctx, orphans)
+	}
+
+	ordersMu.Lock()
+	if lastOrderNumber > orderCounter {
+		orderCounter = lastOrderNumber
+	}
+	ordersMu.Unlock()
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Backup restored successfully",
+		"data":    restored,
+	})
+}
+
+// orphanedImageKeys returns the keys among previous that no product image
+// refers to anymore
+func orphanedImageKeys(ctx context.Context, previous []string) ([]string, error) {
+	current, err := queryImageKeys(ctx, `SELECT storage_key, renditions FROM product_images`)
+	if err != nil {
+		return nil, err
+	}
+	kept := make(map[string]bool, len(current))
+	for _, key := range current {
+		kept[key] = true
+	}
+	var orphans []string
+	for _, key := range previous {
+		if !kept[key] {
+			orphans = append(orphans, key)
+		}
+	}
+	return orphans, nil
+}
+
+// isBackupTable reports whether name is one of the backupTables
+func isBackupTable(name string) bool {
+	for _, t := range backupTables {
+		if t.name == name {
+			return true
+		}
+	}
+	return false
+}
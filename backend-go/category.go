@@ -0,0 +1,516 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Category groups products, which reference it by Slug. Categories nest
+// through ParentID; in the tree returned by getCategories ProductCount
+// includes the products of every subcategory.
+type Category struct {
+	ID           int        `json:"id"`
+	Slug         string     `json:"slug" binding:"required,max=64"`
+	Name         string     `json:"name" binding:"required,max=100"`
+	Description  string     `json:"description" binding:"max=1000"`
+	ParentID     *int       `json:"parentId"`
+	SortOrder    int        `json:"sortOrder"`
+	Image        string     `json:"image"`
+	ProductCount int        `json:"productCount"`
+	Children     []Category `json:"children,omitempty"`
+}
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// defaultCategories seeds Postgres and the in-memory store. ParentID refers
+// to the position in this list, counting from one.
+var defaultCategories = []Category{
+	{Slug: "clothing", Name: "Clothing", SortOrder: 1},
+	{Slug: "books", Name: "Books", SortOrder: 2},
+	{Slug: "electronics", Name: "Electronics", SortOrder: 3},
+	{Slug: "computer-accessories", Name: "Computer Accessories", ParentID: categoryRef(3), SortOrder: 1},
+	{Slug: "home", Name: "Home & Office", SortOrder: 4},
+}
+
+func categoryRef(id int) *int {
+	return &id
+}
+
+// In-memory categories used when running on mock data
+var (
+	mockCategoriesMu sync.RWMutex
+	mockCategories   = seedMockCategories()
+	mockCategoryID   = len(defaultCategories)
+)
+
+func seedMockCategories() []Category {
+	categories := make([]Category, len(defaultCategories))
+	for i, cat := range defaultCategories {
+		cat.ID = i + 1
+		categories[i] = cat
+	}
+	return categories
+}
+
+// loadCategories returns all categories as a flat list in display order.
+// ProductCount only counts the products filed directly under each category.
+func loadCategories(ctx context.Context) ([]Category, error) {
+	if db == nil {
+		mockCategoriesMu.RLock()
+		categories := append([]Category(nil), mockCategories...)
+		mockCategoriesMu.RUnlock()
+		sortCategories(categories)
+		for i := range categories {
+			for _, p := range mockProducts {
+				if p.Category == categories[i].Slug {
+					categories[i].ProductCount++
+				}
+			}
+		}
+		return categories, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT c.id, c.slug, c.name, c.description, c.parent_id, c.sort_order, c.image,
+		(SELECT COUNT(*) FROM products p WHERE p.category = c.slug AND p.deleted_at IS NULL)
+		FROM categories c ORDER BY c.sort_order, c.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	categories := make([]Category, 0)
+	for rows.Next() {
+		var cat Category
+		var parentID sql.NullInt64
+		if err := rows.Scan(&cat.ID, &cat.Slug, &cat.Name, &cat.Description, &parentID, &cat.SortOrder, &cat.Image, &cat.ProductCount); err != nil {
+			return nil, err
+		}
+		if parentID.Valid {
+			cat.ParentID = categoryRef(int(parentID.Int64))
+		}
+		categories = append(categories, cat)
+	}
+	return categories, rows.Err()
+}
+
+// sortCategories orders categories the way Postgres returns them
+func sortCategories(categories []Category) {
+	sort.SliceStable(categories, func(i, j int) bool {
+		if categories[i].SortOrder != categories[j].SortOrder {
+			return categories[i].SortOrder < categories[j].SortOrder
+		}
+		return categories[i].Name < categories[j].Name
+	})
+}
+
+// categoryTree nests a flat category list under its parents and rolls the
+// product counts of subcategories up into their ancestors
+func categoryTree(categories []Category) []Category {
+	roots := make([]Category, 0)
+	children := make(map[int][]Category)
+	for _, cat := range categories {
+		if cat.ParentID == nil {
+			roots = append(roots, cat)
+		} else {
+			children[*cat.ParentID] = append(children[*cat.ParentID], cat)
+		}
+	}
+	var build func(level []Category) []Category
+	build = func(level []Category) []Category {
+		for i := range level {
+			level[i].Children = build(children[level[i].ID])
+			for _, child := range level[i].Children {
+				level[i].ProductCount += child.ProductCount
+			}
+		}
+		return level
+	}
+	return build(roots)
+}
+
+// subtree returns the category with the given ID followed by all of its
+// descendants
+func subtree(categories []Category, id int) []Category {
+	var result []Category
+	for _, cat := range categories {
+		if cat.ID == id {
+			result = append(result, cat)
+		}
+	}
+	for i := 0; i < len(result); i++ {
+		for _, cat := range categories {
+			if cat.ParentID != nil && *cat.ParentID == result[i].ID {
+				result = append(result, cat)
+			}
+		}
+	}
+	return result
+}
+
+// categorySlugs returns slug and the slugs of its subcategories, so that
+// filtering by a category also finds the products filed under its children
+func categorySlugs(ctx context.Context, slug string) ([]string, error) {
+	categories, err := loadCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, cat := range categories {
+		if cat.Slug == slug {
+			var slugs []string
+			for _, sub := range subtree(categories, cat.ID) {
+				slugs = append(slugs, sub.Slug)
+			}
+			return slugs, nil
+		}
+	}
+	return []string{slug}, nil
+}
+
+// validateProductCategory reports a product category that doesn't exist
+func validateProductCategory(ctx context.Context, slug string) ([]FieldError, error) {
+	categories, err := loadCategories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, cat := range categories {
+		if cat.Slug == slug {
+			return nil, nil
+		}
+	}
+	return []FieldError{{Field: "category", Rule: "exists", Message: "must be the slug of an existing category"}}, nil
+}
+
+// validateCategory normalizes cat and reports invalid fields against the
+// existing categories. cat.ID is zero for a new category.
+func validateCategory(cat *Category, categories []Category) []FieldError {
+	cat.Slug = strings.ToLower(strings.TrimSpace(cat.Slug))
+	cat.Name = strings.TrimSpace(cat.Name)
+	cat.ProductCount = 0
+	cat.Children = nil
+	var details []FieldError
+	if !slugPattern.MatchString(cat.Slug) {
+		details = append(details, FieldError{Field: "slug", Rule: "slug", Message: "must be lowercase letters and digits separated by single hyphens"})
+	}
+	for _, existing := range categories {
+		if existing.Slug == cat.Slug && existing.ID != cat.ID {
+			details = append(details, FieldError{Field: "slug", Rule: "unique", Message: "is already in use"})
+		}
+	}
+	if cat.ParentID != nil {
+		if len(subtree(categories, *cat.ParentID)) == 0 {
+			details = append(details, FieldError{Field: "parentId", Rule: "exists", Message: "must be an existing category"})
+		}
+		for _, sub := range subtree(categories, cat.ID) {
+			if sub.ID == *cat.ParentID {
+				details = append(details, FieldError{Field: "parentId", Rule: "cycle", Message: "cannot be the category itself or one of its subcategories"})
+			}
+		}
+	}
+	return details
+}
+
+// getCategories handles GET /api/products/categories
+func getCategories(c *gin.Context) {
+	categories, err := loadCategories(c.Request.Context())
+	if err != nil {
+		respondInternalError(c, "DB error", err)
+		return
+	}
+	c.JSON(http.StatusOK, CategoriesResponse{Success: true, Data: categoryTree(categories)})
+}
+
+// getAdminCategories handles GET /api/admin/categories
+func getAdminCategories(c *gin.Context) {
+	categories, err := loadCategories(c.Request.Context())
+	if err != nil {
+		respondInternalError(c, "Failed to fetch categories", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    categories,
+		"total":   len(categories),
+	})
+}
+
+// createCategory handles POST /api/admin/categories
+func createCategory(c *gin.Context) {
+	ctx := c.Request.Context()
+	var cat Category
+	if err := c.ShouldBindJSON(&cat); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	categories, err := loadCategories(ctx)
+	if err != nil {
+		respondInternalError(c, "Failed to create category", err)
+		return
+	}
+	cat.ID = 0
+	if details := validateCategory(&cat, categories); len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", details...)
+		return
+	}
+
+	if db != nil {
+		err := db.QueryRowContext(ctx, `INSERT INTO categories (slug, name, description, parent_id, sort_order, image) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id`,
+			cat.Slug, cat.Name, cat.Description, cat.ParentID, cat.SortOrder, cat.Image).Scan(&cat.ID)
+		if err != nil {
+			if isUniqueViolation(err) {
+				respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "slug", Rule: "unique", Message: "is already in use"})
+				return
+			}
+			respondInternalError(c, "Failed to create category", err)
+			return
+		}
+	} else {
+		mockCategoriesMu.Lock()
+		mockCategoryID++
+		cat.ID = mockCategoryID
+		mockCategories = append(mockCategories, cat)
+		mockCategoriesMu.Unlock()
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    cat,
+		"message": "Category created successfully",
+	})
+}
+
+// updateCategory handles PUT /api/admin/categories/:id. Renaming the slug
+// carries over to the products, tax rules and coupons that use it.
+func updateCategory(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid category ID")
+		return
+	}
+	var cat Category
+	if err := c.ShouldBindJSON(&cat); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	categories, err := loadCategories(ctx)
+	if err != nil {
+		respondInternalError(c, "Failed to update category", err)
+		return
+	}
+	existing := subtree(categories, id)
+	if len(existing) == 0 {
+		respondError(c, ErrCategoryNotFound, "")
+		return
+	}
+	cat.ID = id
+	if details := validateCategory(&cat, categories); len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", details...)
+		return
+	}
+	oldSlug := existing[0].Slug
+
+	if db != nil {
+		err = updatePostgresCategory(ctx, cat, oldSlug)
+	} else {
+		err = updateMockCategory(cat, oldSlug)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			respondError(c, ErrCategoryNotFound, "")
+		case isUniqueViolation(err):
+			respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "slug", Rule: "unique", Message: "is already in use"})
+		case errors.Is(err, errCategorySlugInUse):
+			respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "slug", Rule: "in_use", Message: "cannot change while products use it on mock data"})
+		default:
+			respondInternalError(c, "Failed to update category", err)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    cat,
+		"message": "Category updated successfully",
+	})
+}
+
+var errCategorySlugInUse = errors.New("category slug in use")
+
+// updatePostgresCategory saves cat. The products foreign key cascades a slug
+// change; tax rules and coupons are updated in the same transaction.
+func updatePostgresCategory(ctx context.Context, cat Category, oldSlug string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `UPDATE categories SET slug=$1, name=$2, description=$3, parent_id=$4, sort_order=$5, image=$6 WHERE id=$7`,
+		cat.Slug, cat.Name, cat.Description, cat.ParentID, cat.SortOrder, cat.Image, cat.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	if cat.Slug != oldSlug {
+		if _, err := tx.ExecContext(ctx, `UPDATE tax_rules SET category = $1 WHERE category = $2`, cat.Slug, oldSlug); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE coupons SET categories = array_replace(categories, $2, $1) WHERE $2 = ANY(categories)`, cat.Slug, oldSlug); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// updateMockCategory saves cat in memory. The mock products are fixed, so
+// a slug they use cannot be renamed.
+func updateMockCategory(cat Category, oldSlug string) error {
+	if cat.Slug != oldSlug {
+		for _, p := range mockProducts {
+			if p.Category == oldSlug {
+				return errCategorySlugInUse
+			}
+		}
+	}
+
+	mockCategoriesMu.Lock()
+	found := false
+	for i := range mockCategories {
+		if mockCategories[i].ID == cat.ID {
+			mockCategories[i] = cat
+			found = true
+		}
+	}
+	mockCategoriesMu.Unlock()
+	if !found {
+		return sql.ErrNoRows
+	}
+	if cat.Slug == oldSlug {
+		return nil
+	}
+
+	mockTaxRulesMu.Lock()
+	for i := range mockTaxRules {
+		if mockTaxRules[i].Category == oldSlug {
+			mockTaxRules[i].Category = cat.Slug
+		}
+	}
+	mockTaxRulesMu.Unlock()
+	mockCouponsMu.Lock()
+	for i := range mockCoupons {
+		for j := range mockCoupons[i].Categories {
+			if mockCoupons[i].Categories[j] == oldSlug {
+				mockCoupons[i].Categories[j] = cat.Slug
+			}
+		}
+	}
+	mockCouponsMu.Unlock()
+	return nil
+}
+
+// deleteCategory handles DELETE /api/admin/categories/:id. Only a category
+// without subcategories or products, deleted ones included, can be removed.
+func deleteCategory(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid category ID")
+		return
+	}
+
+	if db != nil {
+		var inUse bool
+		err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM categories WHERE parent_id = c.id)
+			OR EXISTS (SELECT 1 FROM products WHERE category = c.slug)
+			FROM categories c WHERE c.id = $1`, id).Scan(&inUse)
+		if errors.Is(err, sql.ErrNoRows) {
+			respondError(c, ErrCategoryNotFound, "")
+			return
+		}
+		if err != nil {
+			respondInternalError(c, "Failed to delete category", err)
+			return
+		}
+		if inUse {
+			respondError(c, ErrCategoryInUse, "")
+			return
+		}
+		if _, err := db.ExecContext(ctx, `DELETE FROM categories WHERE id = $1`, id); err != nil {
+			respondInternalError(c, "Failed to delete category", err)
+			return
+		}
+	} else {
+		mockCategoriesMu.Lock()
+		index := -1
+		for i, cat := range mockCategories {
+			if cat.ID == id {
+				index = i
+			}
+		}
+		if index < 0 {
+			mockCategoriesMu.Unlock()
+			respondError(c, ErrCategoryNotFound, "")
+			return
+		}
+		inUse := len(subtree(mockCategories, id)) > 1
+		for _, p := range mockProducts {
+			if p.Category == mockCategories[index].Slug {
+				inUse = true
+			}
+		}
+		if inUse {
+			mockCategoriesMu.Unlock()
+			respondError(c, ErrCategoryInUse, "")
+			return
+		}
+		mockCategories = append(mockCategories[:index], mockCategories[index+1:]...)
+		mockCategoriesMu.Unlock()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Category deleted successfully",
+	})
+}
+
+// seedCategories inserts defaultCategories that don't exist yet, parents
+// first
+func seedCategories(ctx context.Context) error {
+	for _, cat := range defaultCategories {
+		parent := ""
+		if cat.ParentID != nil {
+			parent = defaultCategories[*cat.ParentID-1].Slug
+		}
+		_, err := db.ExecContext(ctx, `INSERT INTO categories (slug, name, description, parent_id, sort_order, image)
+			VALUES ($1, $2, $3, (SELECT id FROM categories WHERE slug = $4), $5, $6) ON CONFLICT (slug) DO NOTHING`,
+			cat.Slug, cat.Name, cat.Description, parent, cat.SortOrder, cat.Image)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seedCategoriesIfEmpty inserts defaultCategories into an empty categories
+// table
+func seedCategoriesIfEmpty() error {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM categories`).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	return seedCategories(context.Background())
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const confirmationHeader = "X-Confirmation-Token"
+
+// productionMode disables destructive admin operations entirely
+var productionMode = strings.EqualFold(getenv("APP_ENV", "development"), "production")
+
+// confirmationTTL is how long a preview's confirmation token stays valid
+var confirmationTTL = getenvDuration("CONFIRMATION_TTL", 5*time.Minute)
+
+// pendingConfirmation is an issued, not yet used confirmation token
+type pendingConfirmation struct {
+	action    string
+	scope     string
+	expiresAt time.Time
+}
+
+var (
+	confirmationsMu sync.Mutex
+	confirmations   = map[string]pendingConfirmation{}
+)
+
+// DestructivePreview is returned instead of running a destructive operation
+// when the request carries no confirmation token
+type DestructivePreview struct {
+	Action            string           `json:"action"`
+	Affected          map[string]int64 `json:"affected"`
+	ConfirmationToken string           `json:"confirmationToken"`
+	ExpiresAt         time.Time        `json:"expiresAt"`
+}
+
+// DestructiveMiddleware rejects the route when running in production mode
+func DestructiveMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if productionMode {
+			respondError(c, ErrOperationDisabled, "")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// issueConfirmation stores a single-use token for action on scope
+func issueConfirmation(action, scope string) (string, time.Time) {
+	now := time.Now()
+	token := generateToken()
+	expiresAt := now.Add(confirmationTTL)
+
+	confirmationsMu.Lock()
+	defer confirmationsMu.Unlock()
+	for t, p := range confirmations {
+		if now.After(p.expiresAt) {
+			delete(confirmations, t)
+		}
+	}
+	confirmations[token] = pendingConfirmation{action: action, scope: scope, expiresAt: expiresAt}
+	return token, expiresAt
+}
+
+// redeemConfirmation consumes token, reporting whether it was issued for the
+// same action and scope and has not expired
+func redeemConfirmation(token, action, scope string) bool {
+	confirmationsMu.Lock()
+	defer confirmationsMu.Unlock()
+	p, ok := confirmations[token]
+	if !ok {
+		return false
+	}
+	delete(confirmations, token)
+	return p.action == action && p.scope == scope && time.Now().Before(p.expiresAt)
+}
+
+// confirmDestructive runs the two-step confirmation for a destructive
+// request. Without a token it responds with a preview of the affected row
+// counts and a fresh token; with one it checks the token was issued for the
+// same action and scope. It returns true only when the operation may run.
+func confirmDestructive(c *gin.Context, action, scope string, preview func(context.Context) (map[string]int64, error)) bool {
+	token := c.GetHeader(confirmationHeader)
+	if token != "" {
+		if !redeemConfirmation(token, action, scope) {
+			respondError(c, ErrConfirmationInvalid, "")
+			return false
+		}
+		return true
+	}
+
+	affected, err := preview(c.Request.Context())
+	if err != nil {
+		respondInternalError(c, fmt.Sprintf("Failed to preview %s", action), err)
+		return false
+	}
+	token, expiresAt := issueConfirmation(action, scope)
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"dryRun":  true,
+		"message": fmt.Sprintf("Repeat the request with the %s header to confirm", confirmationHeader),
+		"data": DestructivePreview{
+			Action:            action,
+			Affected:          affected,
+			ConfirmationToken: token,
+			ExpiresAt:         expiresAt,
+		},
+	})
+	return false
+}
+
+// countRows counts the rows of each table matching its condition
+func countRows(ctx context.Context, tables []string, where map[string]string) (map[string]int64, error) {
+	counts := make(map[string]int64, len(tables))
+	for _, table := range tables {
+		query := fmt.Sprintf(`SELECT COUNT(*) FROM %s`, table)
+		if cond := where[table]; cond != "" {
+			query += " WHERE " + cond
+		}
+		var n int64
+		if err := db.QueryRowContext(ctx, query).Scan(&n); err != nil {
+			return nil, err
+		}
+		counts[table] = n
+	}
+	return counts, nil
+}
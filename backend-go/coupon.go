@@ -0,0 +1,610 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Coupon types
+const (
+	couponPercent = "percent"
+	couponFixed   = "fixed"
+)
+
+// couponError explains why a coupon can't be applied to an order
+type couponError struct {
+	reason string
+}
+
+func (e *couponError) Error() string { return e.reason }
+
+// Coupon is an admin-managed discount code. Value is a percentage (e.g. "15")
+// for percent coupons and an amount in baseCurrency for fixed ones. When
+// Categories or ProductIDs are set the discount only applies to matching
+// lines. Zero limits and a nil StartsAt or EndsAt mean unlimited.
+type Coupon struct {
+	ID               int         `json:"id"`
+	Code             string      `json:"code" binding:"required,max=50"`
+	Description      string      `json:"description"`
+	Type             string      `json:"type" binding:"required,oneof=percent fixed"`
+	Value            json.Number `json:"value" binding:"required"`
+	Categories       []string    `json:"categories"`
+	ProductIDs       []int       `json:"productIds"`
+	MinSubtotal      Money       `json:"minSubtotal" binding:"min=0"`
+	UsageLimit       int         `json:"usageLimit" binding:"min=0"`
+	PerCustomerLimit int         `json:"perCustomerLimit" binding:"min=0"`
+	TimesUsed        int         `json:"timesUsed"`
+	StartsAt         *time.Time  `json:"startsAt"`
+	EndsAt           *time.Time  `json:"endsAt"`
+	Active           bool        `json:"active"`
+}
+
+// DiscountLine is a discount applied to an order
+type DiscountLine struct {
+	CouponID    int    `json:"-"`
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Amount      Money  `json:"amount"`
+}
+
+// CouponPreviewRequest represents POST /api/cart/validate-coupon
+type CouponPreviewRequest struct {
+	Code     string      `json:"code" binding:"required"`
+	Email    string      `json:"email" binding:"omitempty,email"`
+	Currency string      `json:"currency" binding:"omitempty,len=3,alpha"`
+	Items    []OrderItem `json:"items" binding:"required,min=1,dive"`
+}
+
+// In-memory coupons used when running on mock data
+var (
+	mockCouponsMu   sync.Mutex
+	mockCoupons     = make([]Coupon, 0)
+	mockCouponID    = 0
+	mockRedemptions = make(map[int]map[string]int)
+)
+
+// couponValue parses Value, returning the fraction off for percent coupons
+// and the amount for fixed ones. Values are stored as NUMERIC(10,2), so
+// percentages can't have more than two decimal places.
+func (cp Coupon) couponValue() (*big.Rat, Money, error) {
+	if cp.Type == couponPercent {
+		r, err := parseDecimal(cp.Value.String(), 10, 2)
+		if err != nil || r.Sign() <= 0 || r.Cmp(big.NewRat(100, 1)) > 0 {
+			return nil, 0, fmt.Errorf("percent %q must be above 0 and at most 100, with at most 2 decimal places", cp.Value)
+		}
+		return r.Quo(r, big.NewRat(100, 1)), 0, nil
+	}
+	amount, err := parseMoney(cp.Value.String())
+	if err != nil || amount <= 0 {
+		return nil, 0, fmt.Errorf("amount %q must be a positive decimal", cp.Value)
+	}
+	return nil, amount, nil
+}
+
+// appliesTo reports whether the coupon's scope covers a line
+func (cp Coupon) appliesTo(productID int, category string) bool {
+	if len(cp.Categories) == 0 && len(cp.ProductIDs) == 0 {
+		return true
+	}
+	for _, id := range cp.ProductIDs {
+		if id == productID {
+			return true
+		}
+	}
+	for _, cat := range cp.Categories {
+		if strings.EqualFold(cat, category) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCoupon normalizes coupon and reports invalid fields
+func validateCoupon(coupon *Coupon) []FieldError {
+	coupon.Code = strings.ToUpper(strings.TrimSpace(coupon.Code))
+	if coupon.Categories == nil {
+		coupon.Categories = []string{}
+	}
+	if coupon.ProductIDs == nil {
+		coupon.ProductIDs = []int{}
+	}
+
+	var details []FieldError
+	if _, _, err := coupon.couponValue(); err != nil {
+		details = append(details, FieldError{Field: "value", Rule: "range", Message: err.Error()})
+	}
+	if coupon.StartsAt != nil && coupon.EndsAt != nil && coupon.EndsAt.Before(*coupon.StartsAt) {
+		details = append(details, FieldError{Field: "endsAt", Rule: "gtfield", Message: "must be after startsAt"})
+	}
+	return details
+}
+
+const couponColumns = `id, code, description, type, value, categories, product_ids, min_subtotal, usage_limit, per_customer_limit, times_used, starts_at, ends_at, active`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCoupon(row rowScanner) (Coupon, error) {
+	var cp Coupon
+	var value string
+	var productIDs pq.Int64Array
+	err := row.Scan(&cp.ID, &cp.Code, &cp.Description, &cp.Type, &value, pq.Array(&cp.Categories), &productIDs,
+		&cp.MinSubtotal, &cp.UsageLimit, &cp.PerCustomerLimit, &cp.TimesUsed, &cp.StartsAt, &cp.EndsAt, &cp.Active)
+	if err != nil {
+		return cp, err
+	}
+	cp.Value = json.Number(value)
+	cp.ProductIDs = make([]int, len(productIDs))
+	for i, id := range productIDs {
+		cp.ProductIDs[i] = int(id)
+	}
+	if cp.Categories == nil {
+		cp.Categories = []string{}
+	}
+	return cp, nil
+}
+
+// findCoupon looks a coupon up by code, returning nil when there is none
+func findCoupon(ctx context.Context, code string) (*Coupon, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if db == nil {
+		mockCouponsMu.Lock()
+		defer mockCouponsMu.Unlock()
+		for _, cp := range mockCoupons {
+			if cp.Code == code {
+				return &cp, nil
+			}
+		}
+		return nil, nil
+	}
+
+	cp, err := scanCoupon(db.QueryRowContext(ctx, `SELECT `+couponColumns+` FROM coupons WHERE code = $1`, code))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// customerRedemptions counts how often email has used the coupon
+func customerRedemptions(ctx context.Context, couponID int, email string) (int, error) {
+	email = strings.ToLower(email)
+	if db == nil {
+		mockCouponsMu.Lock()
+		defer mockCouponsMu.Unlock()
+		return mockRedemptions[couponID][email], nil
+	}
+	var n int
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM coupon_redemptions WHERE coupon_id = $1 AND customer_email = $2`, couponID, email).Scan(&n)
+	return n, err
+}
+
+// applyCoupon checks order.CouponCode and spreads its discount across the
+// eligible lines in proportion to their value. Item prices must already be
+// in the order currency. Usage is only counted by redeemCoupon.
+func applyCoupon(ctx context.Context, order *Order, catalog map[int]Product) error {
+	order.Discounts = nil
+	for i := range order.Items {
+		order.Items[i].Discount = 0
+	}
+	if order.CouponCode == "" {
+		return nil
+	}
+
+	cp, err := findCoupon(ctx, order.CouponCode)
+	if err != nil {
+		return err
+	}
+	if cp == nil || !cp.Active {
+		return &couponError{"Coupon not found"}
+	}
+	order.CouponCode = cp.Code
+	now := time.Now()
+	if cp.StartsAt != nil && now.Before(*cp.StartsAt) {
+		return &couponError{"Coupon is not active yet"}
+	}
+	if cp.EndsAt != nil && now.After(*cp.EndsAt) {
+		return &couponError{"Coupon has expired"}
+	}
+	if cp.UsageLimit > 0 && cp.TimesUsed >= cp.UsageLimit {
+		return &couponError{"Coupon usage limit reached"}
+	}
+	if cp.PerCustomerLimit > 0 && order.Customer.Email != "" {
+		used, err := customerRedemptions(ctx, cp.ID, order.Customer.Email)
+		if err != nil {
+			return err
+		}
+		if used >= cp.PerCustomerLimit {
+			return &couponError{"Coupon usage limit reached for this customer"}
+		}
+	}
+
+	rate, err := conversionRate(ctx, baseCurrency, order.Currency)
+	if err != nil {
+		return err
+	}
+	var subtotal, eligible Money
+	for _, item := range order.Items {
+		line := item.Price.Mul(item.Quantity)
+		subtotal += line
+		if cp.appliesTo(item.ID, catalog[item.ID].Category) {
+			eligible += line
+		}
+	}
+	if minimum := cp.MinSubtotal.MulRate(rate); subtotal < minimum {
+		return &couponError{fmt.Sprintf("Coupon requires a subtotal of at least %s %s", minimum, order.Currency)}
+	}
+	if eligible == 0 {
+		return &couponError{"Coupon does not apply to any items in the cart"}
+	}
+
+	fraction, amount, err := cp.couponValue()
+	if err != nil {
+		return err
+	}
+	discount := eligible.MulRate(fraction)
+	if cp.Type == couponFixed {
+		discount = amount.MulRate(rate)
+	}
+	if discount > eligible {
+		discount = eligible
+	}
+
+	// Spread the discount so each line's tax is computed on what was paid;
+	// the last eligible line absorbs the rounding remainder
+	remaining := discount
+	last := -1
+	for i, item := range order.Items {
+		if !cp.appliesTo(item.ID, catalog[item.ID].Category) {
+			continue
+		}
+		share := discount.MulRate(big.NewRat(int64(item.Price.Mul(item.Quantity)), int64(eligible)))
+		order.Items[i].Discount = share
+		remaining -= share
+		last = i
+	}
+	order.Items[last].Discount += remaining
+
+	description := cp.Description
+	if description == "" {
+		description = "Coupon " + cp.Code
+	}
+	order.Discounts = []DiscountLine{{CouponID: cp.ID, Code: cp.Code, Description: description, Amount: discount}}
+	return nil
+}
+
+// redeemCoupon counts a use of the order's coupon, failing with a
+// couponError if a concurrent checkout used up the last redemption. With a
+// nil tx the in-memory store is used.
+func redeemCoupon(ctx context.Context, tx *sql.Tx, order *Order) error {
+	if len(order.Discounts) == 0 {
+		return nil
+	}
+	d := order.Discounts[0]
+	email := strings.ToLower(order.Customer.Email)
+
+	if tx == nil {
+		mockCouponsMu.Lock()
+		defer mockCouponsMu.Unlock()
+		for i := range mockCoupons {
+			cp := &mockCoupons[i]
+			if cp.ID != d.CouponID {
+				continue
+			}
+			if cp.UsageLimit > 0 && cp.TimesUsed >= cp.UsageLimit {
+				return &couponError{"Coupon usage limit reached"}
+			}
+			if cp.PerCustomerLimit > 0 && mockRedemptions[cp.ID][email] >= cp.PerCustomerLimit {
+				return &couponError{"Coupon usage limit reached for this customer"}
+			}
+			cp.TimesUsed++
+			if mockRedemptions[cp.ID] == nil {
+				mockRedemptions[cp.ID] = make(map[string]int)
+			}
+			mockRedemptions[cp.ID][email]++
+			return nil
+		}
+		return &couponError{"Coupon not found"}
+	}
+
+	// The conditional update locks the coupon row, so concurrent checkouts
+	// serialize here and the per-customer count below can't race either
+	var perCustomerLimit int
+	err := tx.QueryRowContext(ctx, `UPDATE coupons SET times_used = times_used + 1
+		WHERE id = $1 AND active AND (usage_limit = 0 OR times_used < usage_limit)
+		RETURNING per_customer_limit`, d.CouponID).Scan(&perCustomerLimit)
+	if err == sql.ErrNoRows {
+		return &couponError{"Coupon usage limit reached"}
+	}
+	if err != nil {
+		return err
+	}
+	if perCustomerLimit > 0 {
+		var used int
+		if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM coupon_redemptions WHERE coupon_id = $1 AND customer_email = $2`, d.CouponID, email).Scan(&used); err != nil {
+			return err
+		}
+		if used >= perCustomerLimit {
+			return &couponError{"Coupon usage limit reached for this customer"}
+		}
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO coupon_redemptions (coupon_id, order_id, customer_email, created_at) VALUES ($1,$2,$3,$4)`,
+		d.CouponID, order.ID, email, time.Now())
+	return err
+}
+
+// validateCouponPreview handles POST /api/cart/validate-coupon. It prices the
+// cart with the coupon applied without counting a use.
+func validateCouponPreview(c *gin.Context) {
+	ctx := c.Request.Context()
+	var req CouponPreviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	ids := make([]int, len(req.Items))
+	for i, item := range req.Items {
+		ids[i] = item.ID
+	}
+	catalog, err := loadCatalog(ctx, ids)
+	if err != nil {
+		respondInternalError(c, "Failed to load products", err)
+		return
+	}
+	for _, item := range req.Items {
+		if _, ok := catalog[item.ID]; !ok {
+			respondError(c, ErrProductNotFound, fmt.Sprintf("Product with ID %d does not exist", item.ID))
+			return
+		}
+	}
+
+	order := Order{
+		Items:      req.Items,
+		Currency:   req.Currency,
+		CouponCode: req.Code,
+		Customer:   Customer{Email: req.Email},
+	}
+	if err := applyVariants(&order, catalog); err != nil {
+		respondVariantError(c, err.(*variantError))
+		return
+	}
+	if err := priceOrder(ctx, &order, catalog); err != nil {
+		respondCurrencyError(c, order.Currency, err)
+		return
+	}
+	if err := applyCoupon(ctx, &order, catalog); err != nil {
+		respondCouponError(c, &order, err)
+		return
+	}
+	computeOrderTotals(&order)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"code":      order.CouponCode,
+			"subtotal":  order.Subtotal,
+			"discount":  order.Discount,
+			"discounts": order.Discounts,
+			"items":     order.Items,
+			"currency":  order.Currency,
+		},
+	})
+}
+
+// respondCouponError reports a failure from applyCoupon or redeemCoupon
+func respondCouponError(c *gin.Context, order *Order, err error) {
+	var cerr *couponError
+	if errors.As(err, &cerr) {
+		respondError(c, ErrCouponInvalid, cerr.reason)
+		return
+	}
+	respondCurrencyError(c, order.Currency, err)
+}
+
+// getCoupons handles GET /api/admin/coupons
+func getCoupons(c *gin.Context) {
+	ctx := c.Request.Context()
+	coupons := make([]Coupon, 0)
+	if db != nil {
+		rows, err := db.QueryContext(ctx, `SELECT `+couponColumns+` FROM coupons ORDER BY id`)
+		if err != nil {
+			respondInternalError(c, "Failed to fetch coupons", err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			cp, err := scanCoupon(rows)
+			if err != nil {
+				respondInternalError(c, "Failed to fetch coupons", err)
+				return
+			}
+			coupons = append(coupons, cp)
+		}
+		if err := rows.Err(); err != nil {
+			respondInternalError(c, "Failed to fetch coupons", err)
+			return
+		}
+	} else {
+		mockCouponsMu.Lock()
+		coupons = append(coupons, mockCoupons...)
+		mockCouponsMu.Unlock()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    coupons,
+		"total":   len(coupons),
+	})
+}
+
+// createCoupon handles POST /api/admin/coupons
+func createCoupon(c *gin.Context) {
+	ctx := c.Request.Context()
+	var coupon Coupon
+	if err := c.ShouldBindJSON(&coupon); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	if details := validateCoupon(&coupon); len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", details...)
+		return
+	}
+	coupon.TimesUsed = 0
+	duplicate := FieldError{Field: "code", Rule: "unique", Message: "is already in use"}
+
+	if db != nil {
+		err := db.QueryRowContext(ctx, `INSERT INTO coupons (code, description, type, value, categories, product_ids, min_subtotal, usage_limit, per_customer_limit, starts_at, ends_at, active) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) RETURNING id`,
+			coupon.Code, coupon.Description, coupon.Type, coupon.Value.String(), pq.Array(coupon.Categories), pq.Array(coupon.ProductIDs),
+			coupon.MinSubtotal, coupon.UsageLimit, coupon.PerCustomerLimit, coupon.StartsAt, coupon.EndsAt, coupon.Active).Scan(&coupon.ID)
+		if err != nil {
+			if isUniqueViolation(err) {
+				respondError(c, ErrValidationFailed, "One or more fields are invalid", duplicate)
+				return
+			}
+			respondInternalError(c, "Failed to create coupon", err)
+			return
+		}
+	} else {
+		mockCouponsMu.Lock()
+		for _, cp := range mockCoupons {
+			if cp.Code == coupon.Code {
+				mockCouponsMu.Unlock()
+				respondError(c, ErrValidationFailed, "One or more fields are invalid", duplicate)
+				return
+			}
+		}
+		mockCouponID++
+		coupon.ID = mockCouponID
+		mockCoupons = append(mockCoupons, coupon)
+		mockCouponsMu.Unlock()
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    coupon,
+		"message": "Coupon created successfully",
+	})
+}
+
+// updateCoupon handles PUT /api/admin/coupons/:id. The usage count is kept.
+func updateCoupon(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid coupon ID")
+		return
+	}
+	var coupon Coupon
+	if err := c.ShouldBindJSON(&coupon); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	if details := validateCoupon(&coupon); len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", details...)
+		return
+	}
+	coupon.ID = id
+	duplicate := FieldError{Field: "code", Rule: "unique", Message: "is already in use"}
+
+	found := false
+	if db != nil {
+		err := db.QueryRowContext(ctx, `UPDATE coupons SET code=$1, description=$2, type=$3, value=$4, categories=$5, product_ids=$6, min_subtotal=$7, usage_limit=$8, per_customer_limit=$9, starts_at=$10, ends_at=$11, active=$12 WHERE id=$13 RETURNING times_used`,
+			coupon.Code, coupon.Description, coupon.Type, coupon.Value.String(), pq.Array(coupon.Categories), pq.Array(coupon.ProductIDs),
+			coupon.MinSubtotal, coupon.UsageLimit, coupon.PerCustomerLimit, coupon.StartsAt, coupon.EndsAt, coupon.Active, id).Scan(&coupon.TimesUsed)
+		switch {
+		case err == sql.ErrNoRows:
+		case isUniqueViolation(err):
+			respondError(c, ErrValidationFailed, "One or more fields are invalid", duplicate)
+			return
+		case err != nil:
+			respondInternalError(c, "Failed to update coupon", err)
+			return
+		default:
+			found = true
+		}
+	} else {
+		mockCouponsMu.Lock()
+		for i := range mockCoupons {
+			if mockCoupons[i].ID != id && mockCoupons[i].Code == coupon.Code {
+				mockCouponsMu.Unlock()
+				respondError(c, ErrValidationFailed, "One or more fields are invalid", duplicate)
+				return
+			}
+		}
+		for i := range mockCoupons {
+			if mockCoupons[i].ID == id {
+				coupon.TimesUsed = mockCoupons[i].TimesUsed
+				mockCoupons[i] = coupon
+				found = true
+			}
+		}
+		mockCouponsMu.Unlock()
+	}
+	if !found {
+		respondError(c, ErrCouponNotFound, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    coupon,
+		"message": "Coupon updated successfully",
+	})
+}
+
+// deleteCoupon handles DELETE /api/admin/coupons/:id
+func deleteCoupon(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid coupon ID")
+		return
+	}
+
+	found := false
+	if db != nil {
+		result, err := db.ExecContext(ctx, `DELETE FROM coupons WHERE id = $1`, id)
+		if err != nil {
+			respondInternalError(c, "Failed to delete coupon", err)
+			return
+		}
+		n, _ := result.RowsAffected()
+		found = n > 0
+	} else {
+		mockCouponsMu.Lock()
+		for i := range mockCoupons {
+			if mockCoupons[i].ID == id {
+				mockCoupons = append(mockCoupons[:i], mockCoupons[i+1:]...)
+				delete(mockRedemptions, id)
+				found = true
+				break
+			}
+		}
+		mockCouponsMu.Unlock()
+	}
+	if !found {
+		respondError(c, ErrCouponNotFound, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Coupon deleted successfully",
+	})
+}
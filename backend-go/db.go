@@ -0,0 +1,503 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var db *sql.DB
+
+// Storage modes reported by the readiness probe.
+const (
+	storagePostgres = "postgres"
+	storageMock     = "mock"
+)
+
+// storageMode returns which backend the handlers are currently serving from.
+func storageMode() string {
+	if db != nil {
+		return storagePostgres
+	}
+	return storageMock
+}
+
+func getPostgresConnString() string {
+	// Check for DATABASE_URL environment variable first
+	if dbURL := os.Getenv("DATABASE_URL"); dbURL != "" {
+		return dbURL
+	}
+	// Build from individual environment variables
+	host := getenv("DB_HOST", "localhost")
+	port := getenv("DB_PORT", "5432")
+	user := getenv("DB_USER", "postgres")
+	password := getenv("DB_PASSWORD", "")
+	dbname := getenv("DB_NAME", "postgres")
+	sslmode := getenv("DB_SSLMODE", "disable")
+	
+	return fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		host, port, user, password, dbname, sslmode)
+}
+
+func getenv(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func getenvInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func getenvDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
+}
+
+func connectPostgres() error {
+	connStr := getPostgresConnString()
+	var err error
+	db, err = openTracedDB("postgres", connStr)
+	if err != nil {
+		return err
+	}
+	db.SetConnMaxLifetime(2 * time.Hour)
+	db.SetMaxOpenConns(10)
+	db.SetMaxIdleConns(5)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		// Fall back to mock data instead of serving DB errors from every handler
+		db.Close()
+		db = nil
+		return err
+	}
+	return nil
+}
+
+// isUniqueViolation reports whether err is a Postgres unique constraint error
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// migrations holds the schema changes in the order they must be applied.
+// The schema version is the number of migrations applied, so new entries
+// must only ever be appended.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id SERIAL PRIMARY KEY,
+		username TEXT UNIQUE NOT NULL,
+		email TEXT NOT NULL,
+		name TEXT NOT NULL,
+		role TEXT NOT NULL,
+		password_hash TEXT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS products (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL,
+		price NUMERIC(10,2) NOT NULL,
+		category TEXT NOT NULL,
+		image TEXT NOT NULL,
+		stock INT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS orders (
+		id UUID PRIMARY KEY,
+		order_number TEXT UNIQUE NOT NULL,
+		customer_name TEXT NOT NULL,
+		customer_email TEXT NOT NULL,
+		customer_address TEXT NOT NULL,
+		subtotal NUMERIC(10,2) NOT NULL,
+		shipping NUMERIC(10,2) NOT NULL,
+		tax NUMERIC(10,2) NOT NULL,
+		total NUMERIC(10,2) NOT NULL,
+		status TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS order_items (
+		id SERIAL PRIMARY KEY,
+		order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+		product_id INT NOT NULL REFERENCES products(id),
+		name TEXT NOT NULL,
+		price NUMERIC(10,2) NOT NULL,
+		quantity INT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key TEXT PRIMARY KEY,
+		request_hash TEXT NOT NULL,
+		status_code INT,
+		response BYTEA,
+		created_at TIMESTAMP NOT NULL,
+		expires_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys (expires_at)`,
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD'`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'USD'`,
+	`CREATE TABLE IF NOT EXISTS exchange_rates (
+		currency CHAR(3) PRIMARY KEY,
+		rate NUMERIC(18,8) NOT NULL CHECK (rate > 0),
+		updated_at TIMESTAMP NOT NULL
+	)`,
+	`ALTER TABLE orders
+		ADD COLUMN IF NOT EXISTS base_currency CHAR(3) NOT NULL DEFAULT 'USD',
+		ADD COLUMN IF NOT EXISTS exchange_rate NUMERIC(18,8) NOT NULL DEFAULT 1`,
+	`CREATE TABLE IF NOT EXISTS tax_rules (
+		id SERIAL PRIMARY KEY,
+		name TEXT NOT NULL,
+		country CHAR(2) NOT NULL DEFAULT '',
+		region TEXT NOT NULL DEFAULT '',
+		category TEXT NOT NULL DEFAULT '',
+		rate NUMERIC(7,6) NOT NULL CHECK (rate >= 0 AND rate < 1),
+		inclusive BOOLEAN NOT NULL DEFAULT FALSE
+	)`,
+	`ALTER TABLE orders
+		ADD COLUMN IF NOT EXISTS customer_country CHAR(2) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS customer_region TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE order_items
+		ADD COLUMN IF NOT EXISTS tax_name TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS tax_rate NUMERIC(7,6) NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS tax_amount NUMERIC(10,2) NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS tax_inclusive BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS weight_grams INT NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS shipping_methods (
+		id SERIAL PRIMARY KEY,
+		code TEXT UNIQUE NOT NULL,
+		name TEXT NOT NULL,
+		country CHAR(2) NOT NULL DEFAULT '',
+		region TEXT NOT NULL DEFAULT '',
+		base_rate NUMERIC(10,2) NOT NULL DEFAULT 0,
+		per_kg_rate NUMERIC(10,2) NOT NULL DEFAULT 0,
+		min_weight_grams INT NOT NULL DEFAULT 0,
+		max_weight_grams INT NOT NULL DEFAULT 0,
+		free_shipping_threshold NUMERIC(10,2) NOT NULL DEFAULT 0,
+		active BOOLEAN NOT NULL DEFAULT TRUE
+	)`,
+	`ALTER TABLE orders
+		ADD COLUMN IF NOT EXISTS shipping_method TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS shipping_line1 TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS shipping_line2 TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS shipping_city TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS shipping_postal_code TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS coupons (
+		id SERIAL PRIMARY KEY,
+		code TEXT UNIQUE NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		type TEXT NOT NULL CHECK (type IN ('percent', 'fixed')),
+		value NUMERIC(10,2) NOT NULL,
+		categories TEXT[] NOT NULL DEFAULT '{}',
+		product_ids INT[] NOT NULL DEFAULT '{}',
+		min_subtotal NUMERIC(10,2) NOT NULL DEFAULT 0,
+		usage_limit INT NOT NULL DEFAULT 0,
+		per_customer_limit INT NOT NULL DEFAULT 0,
+		times_used INT NOT NULL DEFAULT 0,
+		starts_at TIMESTAMP,
+		ends_at TIMESTAMP,
+		active BOOLEAN NOT NULL DEFAULT TRUE
+	)`,
+	`CREATE TABLE IF NOT EXISTS coupon_redemptions (
+		id SERIAL PRIMARY KEY,
+		coupon_id INT NOT NULL REFERENCES coupons(id) ON DELETE CASCADE,
+		order_id UUID REFERENCES orders(id) ON DELETE SET NULL,
+		customer_email TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_coupon_redemptions_customer ON coupon_redemptions (coupon_id, customer_email)`,
+	`CREATE TABLE IF NOT EXISTS order_discounts (
+		id SERIAL PRIMARY KEY,
+		order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+		coupon_code TEXT NOT NULL,
+		description TEXT NOT NULL,
+		amount NUMERIC(10,2) NOT NULL
+	)`,
+	`ALTER TABLE orders
+		ADD COLUMN IF NOT EXISTS discount NUMERIC(10,2) NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS coupon_code TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS discount NUMERIC(10,2) NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS payments (
+		id UUID PRIMARY KEY,
+		order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+		provider TEXT NOT NULL,
+		provider_ref TEXT NOT NULL,
+		amount NUMERIC(10,2) NOT NULL,
+		currency CHAR(3) NOT NULL,
+		status TEXT NOT NULL,
+		client_secret TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL,
+		updated_at TIMESTAMP NOT NULL,
+		UNIQUE (provider, provider_ref)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_payments_order_id ON payments (order_id)`,
+	`CREATE TABLE IF NOT EXISTS webhook_events (
+		provider TEXT NOT NULL,
+		event_id TEXT NOT NULL,
+		received_at TIMESTAMP NOT NULL,
+		PRIMARY KEY (provider, event_id)
+	)`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS refunded NUMERIC(10,2) NOT NULL DEFAULT 0`,
+	`ALTER TABLE order_items ADD COLUMN IF NOT EXISTS refunded_quantity INT NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS refunds (
+		id UUID PRIMARY KEY,
+		order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+		amount NUMERIC(10,2) NOT NULL CHECK (amount > 0),
+		currency CHAR(3) NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		shipping NUMERIC(10,2) NOT NULL DEFAULT 0,
+		restock BOOLEAN NOT NULL DEFAULT FALSE,
+		provider TEXT NOT NULL DEFAULT '',
+		provider_refund_id TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_refunds_order_id ON refunds (order_id)`,
+	`CREATE TABLE IF NOT EXISTS refund_items (
+		id SERIAL PRIMARY KEY,
+		refund_id UUID NOT NULL REFERENCES refunds(id) ON DELETE CASCADE,
+		product_id INT NOT NULL,
+		quantity INT NOT NULL CHECK (quantity > 0),
+		amount NUMERIC(10,2) NOT NULL
+	)`,
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+	`CREATE INDEX IF NOT EXISTS idx_products_deleted_at ON products (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`CREATE INDEX IF NOT EXISTS idx_orders_deleted_at ON orders (deleted_at) WHERE deleted_at IS NOT NULL`,
+	`CREATE TABLE IF NOT EXISTS product_variants (
+		id SERIAL PRIMARY KEY,
+		product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		sku TEXT UNIQUE NOT NULL,
+		options JSONB NOT NULL DEFAULT '{}',
+		price NUMERIC(10,2),
+		stock INT NOT NULL DEFAULT 0 CHECK (stock >= 0)
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_product_variants_product_id ON product_variants (product_id)`,
+	`ALTER TABLE order_items
+		ADD COLUMN IF NOT EXISTS variant_id INT REFERENCES product_variants(id) ON DELETE SET NULL,
+		ADD COLUMN IF NOT EXISTS sku TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS options JSONB NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE refund_items ADD COLUMN IF NOT EXISTS variant_id INT NOT NULL DEFAULT 0`,
+	`CREATE TABLE IF NOT EXISTS categories (
+		id SERIAL PRIMARY KEY,
+		slug TEXT UNIQUE NOT NULL,
+		name TEXT NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		parent_id INT REFERENCES categories(id),
+		sort_order INT NOT NULL DEFAULT 0,
+		image TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_categories_parent_id ON categories (parent_id)`,
+	`INSERT INTO categories (slug, name)
+		SELECT DISTINCT category, INITCAP(category) FROM products WHERE category <> ''
+		ON CONFLICT (slug) DO NOTHING`,
+	`ALTER TABLE products ADD CONSTRAINT products_category_fkey
+		FOREIGN KEY (category) REFERENCES categories(slug) ON UPDATE CASCADE NOT VALID`,
+	`CREATE INDEX IF NOT EXISTS idx_products_category ON products (category)`,
+	`CREATE TABLE IF NOT EXISTS product_images (
+		id SERIAL PRIMARY KEY,
+		product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		storage_key TEXT UNIQUE NOT NULL,
+		content_type TEXT NOT NULL,
+		size_bytes INT NOT NULL,
+		position INT NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_product_images_product_id ON product_images (product_id, position)`,
+	`ALTER TABLE product_images
+		ADD COLUMN IF NOT EXISTS width INT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS height INT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS renditions JSONB NOT NULL DEFAULT '{}'`,
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS sku TEXT`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS idx_products_sku ON products (sku)`,
+	// updated_at drives incremental exports. The trigger bumps it on every
+	// update that doesn't set it itself, so no UPDATE statement can miss it.
+	`CREATE OR REPLACE FUNCTION touch_updated_at() RETURNS trigger AS $$
+	BEGIN
+		IF NEW.updated_at IS NOT DISTINCT FROM OLD.updated_at THEN
+			NEW.updated_at := LOCALTIMESTAMP;
+		END IF;
+		RETURN NEW;
+	END $$ LANGUAGE plpgsql`,
+	`DO $$
+	DECLARE t TEXT;
+	BEGIN
+		FOREACH t IN ARRAY ARRAY['categories', 'products', 'product_variants', 'users', 'tax_rules', 'shipping_methods', 'coupons'] LOOP
+			EXECUTE format('ALTER TABLE %I ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP', t);
+		END LOOP;
+		FOREACH t IN ARRAY ARRAY['categories', 'products', 'product_variants', 'users', 'orders', 'payments', 'tax_rules', 'shipping_methods', 'coupons', 'exchange_rates'] LOOP
+			EXECUTE format('CREATE TRIGGER %I BEFORE UPDATE ON %I FOR EACH ROW EXECUTE PROCEDURE touch_updated_at()', t || '_touch_updated_at', t);
+		END LOOP;
+	END $$`,
+	`CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGSERIAL PRIMARY KEY,
+		actor_id INT,
+		actor TEXT NOT NULL DEFAULT '',
+		action TEXT NOT NULL,
+		entity TEXT NOT NULL,
+		entity_id TEXT NOT NULL DEFAULT '',
+		before JSONB,
+		after JSONB,
+		ip TEXT NOT NULL DEFAULT '',
+		request_id TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log (entity, entity_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_audit_log_actor_id ON audit_log (actor_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log (created_at)`,
+	// The audit log is append-only, even for the application's own role
+	`CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+	BEGIN
+		RAISE EXCEPTION 'audit_log is append-only';
+	END $$ LANGUAGE plpgsql`,
+	`CREATE TRIGGER audit_log_no_update BEFORE UPDATE OR DELETE ON audit_log FOR EACH ROW EXECUTE PROCEDURE audit_log_append_only()`,
+	`CREATE TRIGGER audit_log_no_truncate BEFORE TRUNCATE ON audit_log FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only()`,
+	`CREATE TABLE IF NOT EXISTS inventory_movements (
+		id BIGSERIAL PRIMARY KEY,
+		product_id INT NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		variant_id INT REFERENCES product_variants(id) ON DELETE SET NULL,
+		type TEXT NOT NULL CHECK (type IN ('sale', 'restock', 'adjustment', 'return')),
+		quantity INT NOT NULL CHECK (quantity <> 0),
+		stock_after INT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		order_id UUID REFERENCES orders(id) ON DELETE SET NULL,
+		actor_id INT,
+		actor TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_inventory_movements_product_id ON inventory_movements (product_id, id)`,
+	`ALTER TABLE products ADD COLUMN IF NOT EXISTS reorder_threshold INT CHECK (reorder_threshold >= 0)`,
+	// A row per product the low-stock checker has alerted on; it is removed
+	// once the product is back above its threshold
+	`CREATE TABLE IF NOT EXISTS low_stock_alerts (
+		product_id INT PRIMARY KEY REFERENCES products(id) ON DELETE CASCADE,
+		stock INT NOT NULL,
+		threshold INT NOT NULL,
+		alerted_at TIMESTAMP NOT NULL
+	)`,
+	// Refunds are reserved as pending before the payment provider is called;
+	// order_status is the order's status before the refund, so a rejected
+	// first refund can restore it
+	`ALTER TABLE refunds ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'succeeded' CHECK (status IN ('pending', 'succeeded', 'failed'))`,
+	`ALTER TABLE refunds ADD COLUMN IF NOT EXISTS order_status TEXT NOT NULL DEFAULT ''`,
+	// reservation identifies the request holding an idempotency key
+	`ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS reservation TEXT NOT NULL DEFAULT ''`,
+}
+
+func migratePostgres() error {
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		applied_at TIMESTAMP NOT NULL
+	)`); err != nil {
+		return err
+	}
+	current, err := schemaVersion(context.Background())
+	if err != nil {
+		return err
+	}
+	for i := current; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`, i+1, time.Now()); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// schemaVersion returns the highest applied migration version.
+func schemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+func seedPostgresIfEmpty() error {
+	// Seed categories before the products that reference them
+	if err := seedCategoriesIfEmpty(); err != nil {
+		return err
+	}
+
+	// Seed products
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM products`).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		for _, p := range mockProducts {
+			var id int
+			err := db.QueryRow(`INSERT INTO products (name, description, price, currency, category, image, stock, weight_grams) VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING id`,
+				p.Name, p.Description, p.Price, p.Currency, p.Category, p.Image, p.Stock, p.Weight,
+			).Scan(&id)
+			if err != nil {
+				return err
+			}
+			if err := seedProductVariants(context.Background(), id, p.Variants); err != nil {
+				return err
+			}
+		}
+		slog.Info("Seeded products into Postgres", "count", len(mockProducts))
+	}
+
+	// Seed users
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count); err != nil {
+		return err
+	}
+	if count == 0 {
+		seedUsers := []struct {
+			Username string
+			Email    string
+			Name     string
+			Role     string
+			Password string
+		}{
+			{"admin", "admin@vueshop.com", "Admin User", "admin", "admin123"},
+			{"john", "john@example.com", "John Doe", "customer", "password123"},
+			{"jane", "jane@example.com", "Jane Smith", "customer", "password456"},
+		}
+		for _, u := range seedUsers {
+			hash, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+			if err != nil {
+				return err
+			}
+			_, err = db.Exec(`INSERT INTO users (username, email, name, role, password_hash) VALUES ($1,$2,$3,$4,$5)`,
+				u.Username, u.Email, u.Name, u.Role, string(hash),
+			)
+			if err != nil {
+				return err
+			}
+		}
+		slog.Info("Seeded users into Postgres", "count", len(seedUsers))
+	}
+	if err := seedTaxRulesIfEmpty(); err != nil {
+		return err
+	}
+	return seedShippingMethodsIfEmpty()
+}
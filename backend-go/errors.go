@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrorCode is a stable, machine-readable error identifier. Clients should
+// switch on the code rather than the human-readable error text.
+type ErrorCode string
+
+// Error codes returned in ErrorResponse.Code
+const (
+	ErrValidationFailed       ErrorCode = "VALIDATION_FAILED"
+	ErrInvalidID              ErrorCode = "INVALID_ID"
+	ErrNotFound               ErrorCode = "NOT_FOUND"
+	ErrProductNotFound        ErrorCode = "PRODUCT_NOT_FOUND"
+	ErrVariantNotFound        ErrorCode = "VARIANT_NOT_FOUND"
+	ErrCategoryNotFound       ErrorCode = "CATEGORY_NOT_FOUND"
+	ErrCategoryInUse          ErrorCode = "CATEGORY_IN_USE"
+	ErrImageNotFound          ErrorCode = "IMAGE_NOT_FOUND"
+	ErrImageTooLarge          ErrorCode = "IMAGE_TOO_LARGE"
+	ErrUnsupportedImageType   ErrorCode = "UNSUPPORTED_IMAGE_TYPE"
+	ErrOrderNotFound          ErrorCode = "ORDER_NOT_FOUND"
+	ErrUserNotFound           ErrorCode = "USER_NOT_FOUND"
+	ErrTaxRuleNotFound        ErrorCode = "TAX_RULE_NOT_FOUND"
+	ErrShippingMethodNotFound ErrorCode = "SHIPPING_METHOD_NOT_FOUND"
+	ErrCouponNotFound         ErrorCode = "COUPON_NOT_FOUND"
+	ErrInsufficientStock      ErrorCode = "INSUFFICIENT_STOCK"
+	ErrInvalidCredentials     ErrorCode = "INVALID_CREDENTIALS"
+	ErrUnauthenticated        ErrorCode = "UNAUTHENTICATED"
+	ErrInvalidToken           ErrorCode = "INVALID_TOKEN"
+	ErrDatabaseUnavailable    ErrorCode = "DATABASE_UNAVAILABLE"
+	ErrConnectionFailed       ErrorCode = "CONNECTION_FAILED"
+	ErrUnsupportedCurrency    ErrorCode = "UNSUPPORTED_CURRENCY"
+	ErrShippingUnavailable    ErrorCode = "SHIPPING_UNAVAILABLE"
+	ErrCouponInvalid          ErrorCode = "COUPON_INVALID"
+	ErrOrderNotPayable        ErrorCode = "ORDER_NOT_PAYABLE"
+	ErrPaymentFailed          ErrorCode = "PAYMENT_FAILED"
+	ErrOrderNotRefundable     ErrorCode = "ORDER_NOT_REFUNDABLE"
+	ErrRefundFailed           ErrorCode = "REFUND_FAILED"
+	ErrInvalidSignature       ErrorCode = "WEBHOOK_SIGNATURE_INVALID"
+	ErrBackupInvalid          ErrorCode = "BACKUP_INVALID"
+	ErrBackupChecksumMismatch ErrorCode = "BACKUP_CHECKSUM_MISMATCH"
+	ErrBackupVersionMismatch  ErrorCode = "BACKUP_VERSION_MISMATCH"
+	ErrConfirmationInvalid    ErrorCode = "CONFIRMATION_INVALID"
+	ErrOperationDisabled      ErrorCode = "OPERATION_DISABLED"
+	ErrIdempotencyKeyReused   ErrorCode = "IDEMPOTENCY_KEY_REUSED"
+	ErrIdempotencyInProgress  ErrorCode = "IDEMPOTENCY_REQUEST_IN_PROGRESS"
+	ErrRequestTooLarge        ErrorCode = "REQUEST_TOO_LARGE"
+	ErrInternal               ErrorCode = "INTERNAL_ERROR"
+)
+
+// errorDef is the catalog entry for an ErrorCode
+type errorDef struct {
+	Status int
+	Title  string
+}
+
+// errorCatalog maps every ErrorCode to its HTTP status and default title
+var errorCatalog = map[ErrorCode]errorDef{
+	ErrValidationFailed:       {http.StatusBadRequest, "Validation failed"},
+	ErrInvalidID:              {http.StatusBadRequest, "Invalid ID"},
+	ErrNotFound:               {http.StatusNotFound, "Not found"},
+	ErrProductNotFound:        {http.StatusNotFound, "Product not found"},
+	ErrVariantNotFound:        {http.StatusNotFound, "Product variant not found"},
+	ErrCategoryNotFound:       {http.StatusNotFound, "Category not found"},
+	ErrCategoryInUse:          {http.StatusConflict, "Category still has products or subcategories"},
+	ErrImageNotFound:          {http.StatusNotFound, "Image not found"},
+	ErrImageTooLarge:          {http.StatusRequestEntityTooLarge, "Image is too large"},
+	ErrUnsupportedImageType:   {http.StatusUnsupportedMediaType, "Unsupported image type"},
+	ErrOrderNotFound:          {http.StatusNotFound, "Order not found"},
+	ErrUserNotFound:           {http.StatusNotFound, "User not found"},
+	ErrTaxRuleNotFound:        {http.StatusNotFound, "Tax rule not found"},
+	ErrShippingMethodNotFound: {http.StatusNotFound, "Shipping method not found"},
+	ErrCouponNotFound:         {http.StatusNotFound, "Coupon not found"},
+	ErrInsufficientStock:      {http.StatusBadRequest, "Insufficient stock"},
+	ErrInvalidCredentials:     {http.StatusUnauthorized, "Invalid credentials"},
+	ErrUnauthenticated:        {http.StatusUnauthorized, "No token provided"},
+	ErrInvalidToken:           {http.StatusUnauthorized, "Invalid token"},
+	ErrDatabaseUnavailable:    {http.StatusServiceUnavailable, "Database not connected"},
+	ErrConnectionFailed:       {http.StatusInternalServerError, "Connection failed"},
+	ErrUnsupportedCurrency:    {http.StatusBadRequest, "Unsupported currency"},
+	ErrShippingUnavailable:    {http.StatusBadRequest, "Shipping method not available"},
+	ErrCouponInvalid:          {http.StatusBadRequest, "Coupon cannot be applied"},
+	ErrOrderNotPayable:        {http.StatusConflict, "Order cannot be paid"},
+	ErrPaymentFailed:          {http.StatusBadGateway, "Payment failed"},
+	ErrOrderNotRefundable:     {http.StatusConflict, "Order cannot be refunded"},
+	ErrRefundFailed:           {http.StatusBadGateway, "Refund failed"},
+	ErrInvalidSignature:       {http.StatusBadRequest, "Invalid webhook signature"},
+	ErrBackupInvalid:          {http.StatusBadRequest, "Not a valid backup"},
+	ErrBackupChecksumMismatch: {http.StatusBadRequest, "Backup checksum does not match its contents"},
+	ErrBackupVersionMismatch:  {http.StatusConflict, "Backup does not match this server's version"},
+	ErrConfirmationInvalid:    {http.StatusConflict, "Confirmation token is invalid, expired or for a different request"},
+	ErrOperationDisabled:      {http.StatusForbidden, "Destructive operations are disabled in production"},
+	ErrIdempotencyKeyReused:   {http.StatusUnprocessableEntity, "Idempotency key was already used with a different request"},
+	ErrIdempotencyInProgress:  {http.StatusConflict, "A request with this idempotency key is still in progress"},
+	ErrRequestTooLarge:        {http.StatusRequestEntityTooLarge, "Request body is too large"},
+	ErrInternal:               {http.StatusInternalServerError, "Internal server error"},
+}
+
+// FieldError describes a single invalid field of a request body
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ProblemDetails is the RFC 7807 application/problem+json representation of
+// an ErrorResponse, returned when the client asks for it via Accept.
+type ProblemDetails struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      ErrorCode    `json:"code"`
+	RequestID string       `json:"requestId,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+const problemContentType = "application/problem+json"
+
+func init() {
+	// Report validation errors using JSON field names rather than Go ones
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+			if name == "-" {
+				return ""
+			}
+			return name
+		})
+	}
+}
+
+// respondError writes the error identified by code. message adds detail to
+// the catalog title and may be empty. Every handler error goes through here.
+func respondError(c *gin.Context, code ErrorCode, message string, details ...FieldError) {
+	def, ok := errorCatalog[code]
+	if !ok {
+		def = errorCatalog[ErrInternal]
+	}
+
+	if wantsProblemJSON(c) {
+		body, _ := json.Marshal(ProblemDetails{
+			Type:      "urn:vueshop:problem:" + strings.ToLower(string(code)),
+			Title:     def.Title,
+			Status:    def.Status,
+			Detail:    message,
+			Instance:  c.Request.URL.Path,
+			Code:      code,
+			RequestID: requestID(c),
+			Errors:    details,
+		})
+		c.Data(def.Status, problemContentType, body)
+		return
+	}
+
+	c.JSON(def.Status, ErrorResponse{
+		Success:   false,
+		Code:      code,
+		Error:     def.Title,
+		Message:   message,
+		Details:   details,
+		RequestID: requestID(c),
+	})
+}
+
+// wantsProblemJSON reports whether the client accepts RFC 7807 responses,
+// either explicitly or because ERROR_FORMAT=problem is set.
+func wantsProblemJSON(c *gin.Context) bool {
+	if strings.Contains(c.GetHeader("Accept"), problemContentType) {
+		return true
+	}
+	return getenv("ERROR_FORMAT", "") == "problem"
+}
+
+// respondValidationError reports a request binding failure with per-field details
+func respondValidationError(c *gin.Context, err error) {
+	var verrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+
+	switch {
+	case errors.As(err, &verrs):
+		details := make([]FieldError, 0, len(verrs))
+		for _, fe := range verrs {
+			details = append(details, FieldError{
+				Field:   fieldPath(fe),
+				Rule:    fe.Tag(),
+				Message: validationMessage(fe),
+			})
+		}
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", details...)
+	case errors.As(err, &typeErr):
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{
+			Field:   typeErr.Field,
+			Rule:    "type",
+			Message: fmt.Sprintf("must be a %s", typeErr.Type),
+		})
+	case errors.As(err, &syntaxErr):
+		respondError(c, ErrValidationFailed, "Malformed JSON body")
+	default:
+		respondError(c, ErrValidationFailed, err.Error())
+	}
+}
+
+// fieldPath strips the top level struct name from the validator namespace,
+// e.g. "Order.customer.email" becomes "customer.email"
+func fieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return ns
+}
+
+func validationMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required", "required_without":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		switch fe.Kind() {
+		case reflect.Slice, reflect.Map:
+			return fmt.Sprintf("must contain at least %s items", fe.Param())
+		case reflect.String:
+			return fmt.Sprintf("must be at least %s characters", fe.Param())
+		}
+		return fmt.Sprintf("must be at least %s", fe.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s", fe.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+	default:
+		return fmt.Sprintf("failed %s validation", fe.Tag())
+	}
+}
+
+// respondInternalError logs err server-side and returns a 500 that doesn't
+// expose the underlying database or driver error to the client.
+func respondInternalError(c *gin.Context, message string, err error) {
+	loggerFrom(c).Error(message, "error", err, "route", c.FullPath())
+	span := trace.SpanFromContext(c.Request.Context())
+	span.RecordError(err)
+	span.SetStatus(codes.Error, message)
+	_ = c.Error(err)
+	respondError(c, ErrInternal, message)
+}
+
+// notFound handles unmatched routes
+func notFound(c *gin.Context) {
+	respondError(c, ErrNotFound, fmt.Sprintf("No route for %s %s", c.Request.Method, c.Request.URL.Path))
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRatesImportSize caps uploaded exchange-rate CSV files
+const maxRatesImportSize = 1 << 20
+
+var errUnsupportedCurrency = errors.New("unsupported currency")
+
+// ExchangeRate is how many units of Currency one unit of baseCurrency buys
+type ExchangeRate struct {
+	Currency  string      `json:"currency" binding:"required,len=3,alpha"`
+	Rate      json.Number `json:"rate" binding:"required"`
+	UpdatedAt time.Time   `json:"updatedAt"`
+}
+
+// ExchangeRatesRequest represents PUT /api/admin/exchange-rates
+type ExchangeRatesRequest struct {
+	Rates []ExchangeRate `json:"rates" binding:"required,min=1,dive"`
+}
+
+// In-memory exchange rates used when running on mock data
+var (
+	mockRatesMu sync.RWMutex
+	mockRates   = map[string]ExchangeRate{}
+)
+
+// parseRate validates a rate and returns it as an exact fraction. Rates are
+// stored as NUMERIC(18,8).
+func parseRate(rate json.Number) (*big.Rat, error) {
+	r, err := parseDecimal(rate.String(), 18, 8)
+	if err != nil || r.Sign() <= 0 {
+		return nil, fmt.Errorf("rate %q must be a positive decimal with at most 10 digits before and 8 after the decimal point", rate)
+	}
+	return r, nil
+}
+
+// exchangeRate returns the rate from baseCurrency to currency
+func exchangeRate(ctx context.Context, currency string) (*big.Rat, error) {
+	currency = strings.ToUpper(currency)
+	if currency == baseCurrency {
+		return big.NewRat(1, 1), nil
+	}
+
+	var rate string
+	if db != nil {
+		err := db.QueryRowContext(ctx, `SELECT rate FROM exchange_rates WHERE currency = $1`, currency).Scan(&rate)
+		if err == sql.ErrNoRows {
+			return nil, errUnsupportedCurrency
+		}
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		mockRatesMu.RLock()
+		r, ok := mockRates[currency]
+		mockRatesMu.RUnlock()
+		if !ok {
+			return nil, errUnsupportedCurrency
+		}
+		rate = r.Rate.String()
+	}
+	return parseRate(json.Number(rate))
+}
+
+// conversionRate returns the factor converting amounts in from into to
+func conversionRate(ctx context.Context, from, to string) (*big.Rat, error) {
+	fromRate, err := exchangeRate(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	toRate, err := exchangeRate(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Rat).Quo(toRate, fromRate), nil
+}
+
+// convertProducts reprices products into currency in place
+func convertProducts(ctx context.Context, products []Product, currency string) error {
+	currency = strings.ToUpper(currency)
+	rates := make(map[string]*big.Rat)
+	for i := range products {
+		from := normalizeCurrency(products[i].Currency)
+		if from == currency {
+			continue
+		}
+		rate, ok := rates[from]
+		if !ok {
+			var err error
+			if rate, err = conversionRate(ctx, from, currency); err != nil {
+				return err
+			}
+			rates[from] = rate
+		}
+		products[i].Price = products[i].Price.MulRate(rate)
+		products[i].Currency = currency
+		// Copy the variants so converting never touches the catalog's
+		variants := make([]ProductVariant, len(products[i].Variants))
+		for j, v := range products[i].Variants {
+			if v.Price != nil {
+				price := v.Price.MulRate(rate)
+				v.Price = &price
+			}
+			variants[j] = v
+		}
+		if len(variants) > 0 {
+			products[i].Variants = variants
+		}
+	}
+	return nil
+}
+
+// priceOrder prices the order items from catalog in the order currency and
+// records the base-to-order exchange rate snapshot. Products missing from
+// catalog keep the client supplied price.
+func priceOrder(ctx context.Context, order *Order, catalog map[int]Product) error {
+	order.Currency = normalizeCurrency(order.Currency)
+	order.BaseCurrency = baseCurrency
+	rate, err := conversionRate(ctx, baseCurrency, order.Currency)
+	if err != nil {
+		return err
+	}
+	order.ExchangeRate = json.Number(rate.FloatString(8))
+
+	for i, item := range order.Items {
+		p, ok := catalog[item.ID]
+		if !ok {
+			continue
+		}
+		if v, ok := p.variant(item.VariantID); ok && v.Price != nil {
+			p.Price = *v.Price
+		}
+		p.Variants = nil
+		priced := []Product{p}
+		if err := convertProducts(ctx, priced, order.Currency); err != nil {
+			return err
+		}
+		order.Items[i].Price = priced[0].Price
+	}
+	return nil
+}
+
+// respondCurrencyError maps conversion failures to an error response
+func respondCurrencyError(c *gin.Context, currency string, err error) {
+	if errors.Is(err, errUnsupportedCurrency) {
+		respondError(c, ErrUnsupportedCurrency, fmt.Sprintf("No exchange rate for %s", strings.ToUpper(currency)))
+		return
+	}
+	respondInternalError(c, "Failed to load exchange rate", err)
+}
+
+// getExchangeRates handles GET /api/admin/exchange-rates
+func getExchangeRates(c *gin.Context) {
+	ctx := c.Request.Context()
+	rates := make([]ExchangeRate, 0)
+	if db != nil {
+		rows, err := db.QueryContext(ctx, `SELECT currency, rate, updated_at FROM exchange_rates ORDER BY currency`)
+		if err != nil {
+			respondInternalError(c, "Failed to fetch exchange rates", err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var r ExchangeRate
+			var rate string
+			if err := rows.Scan(&r.Currency, &rate, &r.UpdatedAt); err != nil {
+				respondInternalError(c, "Failed to fetch exchange rates", err)
+				return
+			}
+			r.Rate = json.Number(rate)
+			rates = append(rates, r)
+		}
+		if err := rows.Err(); err != nil {
+			respondInternalError(c, "Failed to fetch exchange rates", err)
+			return
+		}
+	} else {
+		mockRatesMu.RLock()
+		for _, r := range mockRates {
+			rates = append(rates, r)
+		}
+		mockRatesMu.RUnlock()
+		sort.Slice(rates, func(i, j int) bool { return rates[i].Currency < rates[j].Currency })
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":      true,
+		"baseCurrency": baseCurrency,
+		"data":         rates,
+	})
+}
+
+// updateExchangeRates handles PUT /api/admin/exchange-rates
+func updateExchangeRates(c *gin.Context) {
+	var req ExchangeRatesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	saveExchangeRates(c, req.Rates)
+}
+
+// importExchangeRates handles POST /api/admin/exchange-rates/import. The body
+// is a CSV file, sent either raw (text/csv) or as the multipart field "file",
+// with "currency,rate" rows and an optional header row.
+func importExchangeRates(c *gin.Context) {
+	var src io.Reader = http.MaxBytesReader(c.Writer, c.Request.Body, maxRatesImportSize)
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			respondError(c, ErrValidationFailed, "Missing CSV upload in field \"file\"")
+			return
+		}
+		if fh.Size > maxRatesImportSize {
+			respondError(c, ErrValidationFailed, "CSV file is too large")
+			return
+		}
+		f, err := fh.Open()
+		if err != nil {
+			respondInternalError(c, "Failed to read upload", err)
+			return
+		}
+		defer f.Close()
+		src = f
+	}
+
+	rates, details, err := parseRatesCSV(src)
+	if err != nil {
+		respondError(c, ErrValidationFailed, err.Error())
+		return
+	}
+	if len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more rows are invalid", details...)
+		return
+	}
+	saveExchangeRates(c, rates)
+}
+
+// parseRatesCSV reads "currency,rate" rows, reporting invalid rows as field errors
+func parseRatesCSV(r io.Reader) ([]ExchangeRate, []FieldError, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	var rates []ExchangeRate
+	var details []FieldError
+	for i, rec := range records {
+		if i == 0 && strings.EqualFold(rec[0], "currency") {
+			continue
+		}
+		row := fmt.Sprintf("row[%d]", i+1)
+		currency := strings.ToUpper(strings.TrimSpace(rec[0]))
+		if len(currency) != 3 {
+			details = append(details, FieldError{Field: row + ".currency", Rule: "len", Message: "must be a 3-letter currency code"})
+			continue
+		}
+		rate := json.Number(strings.TrimSpace(rec[1]))
+		if _, err := parseRate(rate); err != nil {
+			details = append(details, FieldError{Field: row + ".rate", Rule: "decimal", Message: "must be a positive decimal with at most 10 digits before and 8 after the decimal point"})
+			continue
+		}
+		rates = append(rates, ExchangeRate{Currency: currency, Rate: rate})
+	}
+	if len(rates) == 0 && len(details) == 0 {
+		return nil, nil, errors.New("CSV contains no rates")
+	}
+	return rates, details, nil
+}
+
+// saveExchangeRates validates and upserts rates in one transaction
+func saveExchangeRates(c *gin.Context, rates []ExchangeRate) {
+	ctx := c.Request.Context()
+	now := time.Now()
+	var details []FieldError
+	for i := range rates {
+		rates[i].Currency = strings.ToUpper(rates[i].Currency)
+		rates[i].UpdatedAt = now
+		if rates[i].Currency == baseCurrency {
+			details = append(details, FieldError{Field: fmt.Sprintf("rates[%d].currency", i), Rule: "ne", Message: "cannot set a rate for the base currency"})
+		}
+		if _, err := parseRate(rates[i].Rate); err != nil {
+			details = append(details, FieldError{Field: fmt.Sprintf("rates[%d].rate", i), Rule: "decimal", Message: "must be a positive decimal with at most 10 digits before and 8 after the decimal point"})
+		}
+	}
+	if len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more rates are invalid", details...)
+		return
+	}
+
+	if db != nil {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			respondInternalError(c, "Failed to save exchange rates", err)
+			return
+		}
+		defer tx.Rollback()
+		for _, r := range rates {
+			_, err := tx.ExecContext(ctx, `INSERT INTO exchange_rates (currency, rate, updated_at) VALUES ($1,$2,$3)
+				ON CONFLICT (currency) DO UPDATE SET rate = EXCLUDED.rate, updated_at = EXCLUDED.updated_at`,
+				r.Currency, r.Rate.String(), r.UpdatedAt)
+			if err != nil {
+				respondInternalError(c, "Failed to save exchange rates", err)
+				return
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			respondInternalError(c, "Failed to save exchange rates", err)
+			return
+		}
+	} else {
+		mockRatesMu.Lock()
+		for _, r := range rates {
+			mockRates[r.Currency] = r
+		}
+		mockRatesMu.Unlock()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": fmt.Sprintf("%d exchange rates updated", len(rates)),
+		"data":    rates,
+	})
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"database/sql"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
@@ -13,24 +16,36 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/crypto/bcrypt"
 )
 
 // getProducts handles GET /api/products
 func getProducts(c *gin.Context) {
+	ctx := c.Request.Context()
 	// Prefer Postgres when available
 	if db != nil {
 		category := c.Query("category")
 		search := c.Query("search")
 		sortBy := c.Query("sort")
 
-		query := `SELECT id, name, description, price, category, image, stock FROM products`
+		query := `SELECT id, COALESCE(sku, ''), name, description, price, currency, category, image, stock, reorder_threshold, weight_grams, deleted_at FROM products`
 		var filters []string
 		var args []interface{}
 		arg := 1
+		if !includeDeleted(c) {
+			filters = append(filters, "deleted_at IS NULL")
+		}
 		if category != "" && category != "all" {
-			filters = append(filters, fmt.Sprintf("category = $%d", arg))
-			args = append(args, category)
+			slugs, err := categorySlugs(ctx, category)
+			if err != nil {
+				respondInternalError(c, "DB error", err)
+				return
+			}
+			filters = append(filters, fmt.Sprintf("category = ANY($%d)", arg))
+			args = append(args, pq.Array(slugs))
 			arg++
 		}
 		if search != "" {
@@ -53,9 +68,9 @@ func getProducts(c *gin.Context) {
 			query += " ORDER BY name DESC"
 		}
 
-		rows, err := db.Query(query, args...)
+		rows, err := db.QueryContext(ctx, query, args...)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: "DB error", Message: err.Error()})
+			respondInternalError(c, "DB error", err)
 			return
 		}
 		defer rows.Close()
@@ -63,17 +78,32 @@ func getProducts(c *gin.Context) {
 		products := make([]Product, 0)
 		for rows.Next() {
 			var p Product
-			if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Category, &p.Image, &p.Stock); err != nil {
-				c.JSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: "DB error", Message: err.Error()})
+			if err := rows.Scan(&p.ID, &p.SKU, &p.Name, &p.Description, &p.Price, &p.Currency, &p.Category, &p.Image, &p.Stock, &p.ReorderThreshold, &p.Weight, &p.DeletedAt); err != nil {
+				respondInternalError(c, "DB error", err)
 				return
 			}
 			products = append(products, p)
 		}
 		if err := rows.Err(); err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: "DB error", Message: err.Error()})
+			respondInternalError(c, "DB error", err)
+			return
+		}
+		if err := attachVariants(ctx, products); err != nil {
+			respondInternalError(c, "DB error", err)
+			return
+		}
+		if err := attachImages(ctx, products); err != nil {
+			respondInternalError(c, "DB error", err)
 			return
 		}
 
+		if currency := c.Query("currency"); currency != "" {
+			if err := convertProducts(ctx, products, currency); err != nil {
+				respondCurrencyError(c, currency, err)
+				return
+			}
+		}
+
 		c.JSON(http.StatusOK, ProductsResponse{Success: true, Data: products, Total: len(products)})
 		return
 	}
@@ -83,10 +113,23 @@ func getProducts(c *gin.Context) {
 	search := c.Query("search")
 	sortBy := c.Query("sort")
 
+	// A category also matches the products of its subcategories
+	inCategory := make(map[string]bool)
+	if category != "" && category != "all" {
+		slugs, err := categorySlugs(ctx, category)
+		if err != nil {
+			respondInternalError(c, "Failed to load categories", err)
+			return
+		}
+		for _, slug := range slugs {
+			inCategory[slug] = true
+		}
+	}
+
 	filteredProducts := make([]Product, 0)
 	for _, product := range mockProducts {
 		// Filter by category
-		if category != "" && category != "all" && product.Category != category {
+		if len(inCategory) > 0 && !inCategory[product.Category] {
 			continue
 		}
 
@@ -123,6 +166,13 @@ func getProducts(c *gin.Context) {
 		})
 	}
 
+	if currency := c.Query("currency"); currency != "" {
+		if err := convertProducts(ctx, filteredProducts, currency); err != nil {
+			respondCurrencyError(c, currency, err)
+			return
+		}
+	}
+
 	response := ProductsResponse{
 		Success: true,
 		Data:    filteredProducts,
@@ -134,27 +184,43 @@ func getProducts(c *gin.Context) {
 
 // getProduct handles GET /api/products/:id
 func getProduct(c *gin.Context) {
+	ctx := c.Request.Context()
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   "Invalid product ID",
-		})
+		respondError(c, ErrInvalidID, "Invalid product ID")
 		return
 	}
 
 	if db != nil {
 		var p Product
-		row := db.QueryRow(`SELECT id, name, description, price, category, image, stock FROM products WHERE id = $1`, id)
-		if err := row.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Category, &p.Image, &p.Stock); err != nil {
+		row := db.QueryRowContext(ctx, `SELECT id, COALESCE(sku, ''), name, description, price, currency, category, image, stock, reorder_threshold, weight_grams, deleted_at FROM products WHERE id = $1 AND (deleted_at IS NULL OR $2)`, id, includeDeleted(c))
+		if err := row.Scan(&p.ID, &p.SKU, &p.Name, &p.Description, &p.Price, &p.Currency, &p.Category, &p.Image, &p.Stock, &p.ReorderThreshold, &p.Weight, &p.DeletedAt); err != nil {
 			if err == sql.ErrNoRows {
-				c.JSON(http.StatusNotFound, ErrorResponse{Success: false, Error: "Product not found"})
+				respondError(c, ErrProductNotFound, "")
 				return
 			}
-			c.JSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: "DB error", Message: err.Error()})
+			respondInternalError(c, "DB error", err)
+			return
+		}
+		withVariants := []Product{p}
+		if err := attachVariants(ctx, withVariants); err != nil {
+			respondInternalError(c, "DB error", err)
+			return
+		}
+		if err := attachImages(ctx, withVariants); err != nil {
+			respondInternalError(c, "DB error", err)
 			return
 		}
+		p = withVariants[0]
+		if currency := c.Query("currency"); currency != "" {
+			converted := []Product{p}
+			if err := convertProducts(ctx, converted, currency); err != nil {
+				respondCurrencyError(c, currency, err)
+				return
+			}
+			p = converted[0]
+		}
 		c.JSON(http.StatusOK, ProductResponse{Success: true, Data: p})
 		return
 	}
@@ -162,6 +228,14 @@ func getProduct(c *gin.Context) {
 	// Fallback mock
 	for _, product := range mockProducts {
 		if product.ID == id {
+			if currency := c.Query("currency"); currency != "" {
+				converted := []Product{product}
+				if err := convertProducts(ctx, converted, currency); err != nil {
+					respondCurrencyError(c, currency, err)
+					return
+				}
+				product = converted[0]
+			}
 			response := ProductResponse{
 				Success: true,
 				Data:    product,
@@ -171,135 +245,178 @@ func getProduct(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusNotFound, ErrorResponse{
-		Success: false,
-		Error:   "Product not found",
-	})
-}
-
-// getCategories handles GET /api/products/categories
-func getCategories(c *gin.Context) {
-	if db != nil {
-		rows, err := db.Query(`SELECT DISTINCT category FROM products ORDER BY category ASC`)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: "DB error", Message: err.Error()})
-			return
-		}
-		defer rows.Close()
-		cats := make([]string, 0)
-		for rows.Next() {
-			var cat string
-			if err := rows.Scan(&cat); err != nil {
-				c.JSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: "DB error", Message: err.Error()})
-				return
-			}
-			cats = append(cats, cat)
-		}
-		c.JSON(http.StatusOK, CategoriesResponse{Success: true, Data: cats})
-		return
-	}
-
-	// Fallback mock
-	categoryMap := make(map[string]bool)
-	for _, product := range mockProducts {
-		categoryMap[product.Category] = true
-	}
-
-	categories := make([]string, 0, len(categoryMap))
-	for category := range categoryMap {
-		categories = append(categories, category)
-	}
-
-	sort.Strings(categories)
-
-	response := CategoriesResponse{
-		Success: true,
-		Data:    categories,
-	}
-
-	c.JSON(http.StatusOK, response)
+	respondError(c, ErrProductNotFound, "")
 }
 
 // createOrder handles POST /api/orders
 func createOrder(c *gin.Context) {
+	ctx := c.Request.Context()
 	var order Order
 	if err := c.ShouldBindJSON(&order); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   "Validation failed",
-			Message: err.Error(),
-		})
+		recordCheckoutFailure(checkoutValidation)
+		respondValidationError(c, err)
 		return
 	}
 
+	categories := make(map[int]string)
+	catalog := make(map[int]Product)
 	if db != nil {
 		// Validate products exist and stock
+		stockCtx, stockSpan := tracer.Start(ctx, "checkout.validate_stock", trace.WithAttributes(attribute.Int("order.items", len(order.Items))))
 		for _, item := range order.Items {
-			var stock int
-			row := db.QueryRow(`SELECT stock FROM products WHERE id = $1`, item.ID)
-			if err := row.Scan(&stock); err != nil {
+			var p Product
+			row := db.QueryRowContext(stockCtx, `SELECT stock, category, price, currency, weight_grams FROM products WHERE id = $1 AND deleted_at IS NULL`, item.ID)
+			if err := row.Scan(&p.Stock, &p.Category, &p.Price, &p.Currency, &p.Weight); err != nil {
+				stockSpan.End()
 				if err == sql.ErrNoRows {
-					c.JSON(http.StatusBadRequest, ErrorResponse{Success: false, Error: "Product not found", Message: fmt.Sprintf("Product with ID %d does not exist", item.ID)})
+					recordCheckoutFailure(checkoutProductNotFound)
+					respondError(c, ErrProductNotFound, fmt.Sprintf("Product with ID %d does not exist", item.ID))
 					return
 				}
-				c.JSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: "DB error", Message: err.Error()})
+				recordCheckoutFailure(checkoutDBError)
+				respondInternalError(c, "DB error", err)
 				return
 			}
-			if stock < item.Quantity {
-				c.JSON(http.StatusBadRequest, ErrorResponse{Success: false, Error: "Insufficient stock", Message: fmt.Sprintf("Product %d only has %d items in stock", item.ID, stock)})
+			if p.Stock < item.Quantity {
+				stockSpan.End()
+				recordCheckoutFailure(checkoutInsufficientStock)
+				respondError(c, ErrInsufficientStock, fmt.Sprintf("Product %d only has %d items in stock", item.ID, p.Stock))
 				return
 			}
+			p.ID = item.ID
+			categories[item.ID] = p.Category
+			catalog[item.ID] = p
+		}
+		products := make([]Product, 0, len(catalog))
+		for _, p := range catalog {
+			products = append(products, p)
+		}
+		if err := attachVariants(stockCtx, products); err != nil {
+			stockSpan.End()
+			recordCheckoutFailure(checkoutDBError)
+			respondInternalError(c, "DB error", err)
+			return
+		}
+		for _, p := range products {
+			catalog[p.ID] = p
+		}
+		stockSpan.End()
+		if err := prepareOrder(ctx, &order, catalog); err != nil {
+			respondCheckoutError(c, &order, err)
+			return
 		}
 
 		// Create order
 		order.ID = uuid.New().String()
+		ordersMu.Lock()
 		orderCounter++
 		order.OrderNumber = fmt.Sprintf("VUE-%d", orderCounter)
+		ordersMu.Unlock()
 		order.Status = "pending"
 		order.CreatedAt = time.Now()
 		order.UpdatedAt = time.Now()
 
-		tx, err := db.Begin()
+		ctx, txSpan := tracer.Start(ctx, "checkout.transaction")
+		defer txSpan.End()
+
+		tx, err := db.BeginTx(ctx, nil)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: "DB error", Message: err.Error()})
+			recordCheckoutFailure(checkoutDBError)
+			respondInternalError(c, "DB error", err)
 			return
 		}
 		defer tx.Rollback()
 
-		_, err = tx.Exec(`INSERT INTO orders (id, order_number, customer_name, customer_email, customer_address, subtotal, shipping, tax, total, status, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)`,
-			order.ID, order.OrderNumber, order.Customer.Name, order.Customer.Email, order.Customer.Address, order.Subtotal, order.Shipping, order.Tax, order.Total, order.Status, order.CreatedAt, order.UpdatedAt,
+		var addr Address
+		if order.Customer.ShippingAddress != nil {
+			addr = *order.Customer.ShippingAddress
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO orders (id, order_number, customer_name, customer_email, customer_address, customer_country, customer_region, shipping_line1, shipping_line2, shipping_city, shipping_postal_code, subtotal, discount, coupon_code, shipping, shipping_method, tax, total, currency, base_currency, exchange_rate, status, created_at, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19,$20,$21,$22,$23,$24)`,
+			order.ID, order.OrderNumber, order.Customer.Name, order.Customer.Email, order.Customer.Address, order.Customer.Country, order.Customer.Region,
+			addr.Line1, addr.Line2, addr.City, addr.PostalCode, order.Subtotal, order.Discount, order.CouponCode, order.Shipping, order.ShippingMethod, order.Tax, order.Total, order.Currency, order.BaseCurrency, order.ExchangeRate.String(), order.Status, order.CreatedAt, order.UpdatedAt,
 		)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: "DB error", Message: err.Error()})
+			recordCheckoutFailure(checkoutDBError)
+			respondInternalError(c, "DB error", err)
 			return
 		}
 		for _, item := range order.Items {
-			_, err := tx.Exec(`INSERT INTO order_items (order_id, product_id, name, price, quantity) VALUES ($1,$2,$3,$4,$5)`, order.ID, item.ID, item.Name, item.Price, item.Quantity)
+			_, err := tx.ExecContext(ctx, `INSERT INTO order_items (order_id, product_id, variant_id, sku, options, name, price, quantity, discount, tax_name, tax_rate, tax_amount, tax_inclusive) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13)`,
+				order.ID, item.ID, variantIDArg(item.VariantID), item.SKU, optionsJSON(item.Options), item.Name, item.Price, item.Quantity, item.Discount, item.TaxName, item.TaxRate.String(), item.TaxAmount, item.TaxInclusive)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: "DB error", Message: err.Error()})
+				recordCheckoutFailure(checkoutDBError)
+				respondInternalError(c, "DB error", err)
+				return
+			}
+			// Reserve stock with a relative, checked update so concurrent
+			// checkouts can't oversell
+			movement := InventoryMovement{ProductID: item.ID, VariantID: item.VariantID, Type: movementSale, Quantity: -item.Quantity, OrderID: order.ID}
+			movement.setActor(c)
+			if err := moveStock(ctx, tx, &movement); err != nil {
+				var serr *stockError
+				switch {
+				case errors.As(err, &serr) && item.VariantID != 0:
+					recordCheckoutFailure(checkoutInsufficientStock)
+					respondError(c, ErrInsufficientStock, fmt.Sprintf("Variant %s of product %d does not have %d items in stock", item.SKU, item.ID, item.Quantity))
+				case errors.As(err, &serr):
+					recordCheckoutFailure(checkoutInsufficientStock)
+					respondError(c, ErrInsufficientStock, fmt.Sprintf("Product %d only has %d items in stock", item.ID, serr.available))
+				default:
+					recordCheckoutFailure(checkoutDBError)
+					respondInternalError(c, "DB error", err)
+				}
 				return
 			}
-			_, err = tx.Exec(`UPDATE products SET stock = stock - $1 WHERE id = $2`, item.Quantity, item.ID)
+		}
+		if err := redeemCoupon(ctx, tx, &order); err != nil {
+			respondCheckoutError(c, &order, err)
+			return
+		}
+		for _, d := range order.Discounts {
+			_, err := tx.ExecContext(ctx, `INSERT INTO order_discounts (order_id, coupon_code, description, amount) VALUES ($1,$2,$3,$4)`, order.ID, d.Code, d.Description, d.Amount)
 			if err != nil {
-				c.JSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: "DB error", Message: err.Error()})
+				recordCheckoutFailure(checkoutDBError)
+				respondInternalError(c, "DB error", err)
 				return
 			}
 		}
+		if err := recordAudit(c, tx, "create", "order", order.ID, nil, order); err != nil {
+			recordCheckoutFailure(checkoutDBError)
+			respondInternalError(c, "DB error", err)
+			return
+		}
 		if err := tx.Commit(); err != nil {
-			c.JSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: "DB error", Message: err.Error()})
+			recordCheckoutFailure(checkoutDBError)
+			respondInternalError(c, "DB error", err)
 			return
 		}
+		recordOrderCreated(order, categories)
 
 		response := OrderResponse{Success: true, Message: "Order created successfully"}
 		response.Data.OrderID = order.ID
 		response.Data.OrderNumber = order.OrderNumber
 		response.Data.Total = order.Total
+		response.Data.Currency = order.Currency
 		response.Data.Status = order.Status
 		c.JSON(http.StatusCreated, response)
 		return
 	}
 
 	// Fallback mock logic
+	for _, product := range mockProducts {
+		catalog[product.ID] = product
+	}
+	if err := prepareOrder(ctx, &order, catalog); err != nil {
+		respondCheckoutError(c, &order, err)
+		return
+	}
+
+	if err := redeemCoupon(ctx, nil, &order); err != nil {
+		respondCheckoutError(c, &order, err)
+		return
+	}
+
+	ordersMu.Lock()
 	orderCounter++
 	order.ID = uuid.New().String()
 	order.OrderNumber = fmt.Sprintf("VUE-%d", orderCounter)
@@ -308,21 +425,238 @@ func createOrder(c *gin.Context) {
 	order.UpdatedAt = time.Now()
 
 	orders[order.ID] = order
+	ordersMu.Unlock()
+	if err := recordAudit(c, nil, "create", "order", order.ID, nil, order); err != nil {
+		respondInternalError(c, "Failed to record audit entry", err)
+		return
+	}
+
+	for _, product := range mockProducts {
+		categories[product.ID] = product.Category
+	}
+	recordOrderCreated(order, categories)
 
 	response := OrderResponse{Success: true, Message: "Order created successfully"}
 	response.Data.OrderID = order.ID
 	response.Data.OrderNumber = order.OrderNumber
 	response.Data.Total = order.Total
+	response.Data.Currency = order.Currency
 	response.Data.Status = order.Status
 	c.JSON(http.StatusCreated, response)
 }
 
+// prepareOrder prices, discounts, ships, taxes and totals an order from the catalog so that
+// nothing the client computed ends up on it.
+func prepareOrder(ctx context.Context, order *Order, catalog map[int]Product) error {
+	normalizeAddress(&order.Customer)
+	if err := applyVariants(order, catalog); err != nil {
+		return err
+	}
+	order.Refunded, order.Refunds = 0, nil
+	for i := range order.Items {
+		order.Items[i].RefundedQuantity = 0
+	}
+	if err := priceOrder(ctx, order, catalog); err != nil {
+		return err
+	}
+	if err := applyCoupon(ctx, order, catalog); err != nil {
+		return err
+	}
+	if err := applyShipping(ctx, order, catalog); err != nil {
+		return err
+	}
+	if err := applyTax(ctx, order, catalog); err != nil {
+		return err
+	}
+	computeOrderTotals(order)
+	return nil
+}
+
+// respondCheckoutError reports a prepareOrder failure
+func respondCheckoutError(c *gin.Context, order *Order, err error) {
+	var cerr *couponError
+	var verr *variantError
+	switch {
+	case errors.As(err, &verr):
+		recordCheckoutFailure(checkoutValidation)
+		respondVariantError(c, verr)
+	case errors.As(err, &cerr):
+		recordCheckoutFailure(checkoutInvalidCoupon)
+		respondError(c, ErrCouponInvalid, cerr.reason)
+	case errors.Is(err, errShippingUnavailable):
+		recordCheckoutFailure(checkoutValidation)
+		if order.ShippingMethod == "" {
+			respondError(c, ErrShippingUnavailable, "No shipping method ships this order")
+		} else {
+			respondError(c, ErrShippingUnavailable, fmt.Sprintf("Shipping method %q does not ship this order", order.ShippingMethod))
+		}
+	case errors.Is(err, errUnsupportedCurrency):
+		recordCheckoutFailure(checkoutValidation)
+		respondCurrencyError(c, order.Currency, err)
+	default:
+		recordCheckoutFailure(checkoutDBError)
+		respondCurrencyError(c, order.Currency, err)
+	}
+}
+
+// computeOrderTotals derives the subtotal, discount and tax from the line
+// items and the total from subtotal less discount plus shipping and
+// exclusive tax, so client-side rounding can't leak in. Inclusive tax is
+// already part of the subtotal.
+func computeOrderTotals(order *Order) {
+	var subtotal, discount, tax, exclusiveTax Money
+	for _, item := range order.Items {
+		subtotal += item.Price.Mul(item.Quantity)
+		discount += item.Discount
+		tax += item.TaxAmount
+		if !item.TaxInclusive {
+			exclusiveTax += item.TaxAmount
+		}
+	}
+	order.Subtotal = subtotal
+	order.Discount = discount
+	order.Tax = tax
+	order.Total = subtotal - discount + order.Shipping + exclusiveTax
+}
+
+// orderColumns are the orders columns read by scanOrder
+const orderColumns = `id, order_number, customer_name, customer_email, customer_address, customer_country, customer_region,
+	shipping_line1, shipping_line2, shipping_city, shipping_postal_code, subtotal, discount, coupon_code, shipping, shipping_method,
+	tax, total, currency, base_currency, exchange_rate, refunded, status, created_at, updated_at, deleted_at`
+
+func scanOrder(row rowScanner) (Order, error) {
+	var o Order
+	var addr Address
+	var rate string
+	var deletedAt sql.NullTime
+	err := row.Scan(&o.ID, &o.OrderNumber, &o.Customer.Name, &o.Customer.Email, &o.Customer.Address, &o.Customer.Country, &o.Customer.Region,
+		&addr.Line1, &addr.Line2, &addr.City, &addr.PostalCode, &o.Subtotal, &o.Discount, &o.CouponCode, &o.Shipping, &o.ShippingMethod,
+		&o.Tax, &o.Total, &o.Currency, &o.BaseCurrency, &rate, &o.Refunded, &o.Status, &o.CreatedAt, &o.UpdatedAt, &deletedAt)
+	if err != nil {
+		return o, err
+	}
+	// CHAR columns come back padded
+	o.Customer.Country = strings.TrimSpace(o.Customer.Country)
+	if addr.Line1 != "" {
+		addr.Region, addr.Country = o.Customer.Region, o.Customer.Country
+		o.Customer.ShippingAddress = &addr
+	}
+	o.ExchangeRate = json.Number(rate)
+	if deletedAt.Valid {
+		o.DeletedAt = &deletedAt.Time
+	}
+	return o, nil
+}
+
+// queryOrders loads the orders matching where, with their lines and discounts
+func queryOrders(ctx context.Context, where string, args ...interface{}) ([]Order, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+orderColumns+` FROM orders WHERE `+where+` ORDER BY created_at DESC`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]Order, 0)
+	for rows.Next() {
+		o, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, o)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := attachOrderLines(ctx, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// attachOrderLines loads the items and discount lines of orders
+func attachOrderLines(ctx context.Context, list []Order) error {
+	if len(list) == 0 {
+		return nil
+	}
+	ids := make([]string, len(list))
+	index := make(map[string]int, len(list))
+	for i, o := range list {
+		ids[i] = o.ID
+		index[o.ID] = i
+		list[i].Items = []OrderItem{}
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT order_id, product_id, COALESCE(variant_id, 0), sku, options, name, price, quantity, discount, tax_name, tax_rate, tax_amount, tax_inclusive, refunded_quantity
+		FROM order_items WHERE order_id = ANY($1::uuid[]) ORDER BY id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var orderID, taxRate string
+		var options []byte
+		var item OrderItem
+		if err := rows.Scan(&orderID, &item.ID, &item.VariantID, &item.SKU, &options, &item.Name, &item.Price, &item.Quantity, &item.Discount, &item.TaxName, &taxRate, &item.TaxAmount, &item.TaxInclusive, &item.RefundedQuantity); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(options, &item.Options); err != nil {
+			return err
+		}
+		if len(item.Options) == 0 {
+			item.Options = nil
+		}
+		item.TaxRate = json.Number(taxRate)
+		if i, ok := index[orderID]; ok {
+			list[i].Items = append(list[i].Items, item)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	discountRows, err := db.QueryContext(ctx, `SELECT order_id, coupon_code, description, amount FROM order_discounts WHERE order_id = ANY($1::uuid[]) ORDER BY id`, pq.Array(ids))
+	if err != nil {
+		return err
+	}
+	defer discountRows.Close()
+	for discountRows.Next() {
+		var orderID string
+		var d DiscountLine
+		if err := discountRows.Scan(&orderID, &d.Code, &d.Description, &d.Amount); err != nil {
+			return err
+		}
+		if i, ok := index[orderID]; ok {
+			list[i].Discounts = append(list[i].Discounts, d)
+		}
+	}
+	return discountRows.Err()
+}
+
 // getAllOrders handles GET /api/orders
 func getAllOrders(c *gin.Context) {
+	withDeleted := includeDeleted(c)
+	if db != nil {
+		where := "deleted_at IS NULL"
+		if withDeleted {
+			where = "TRUE"
+		}
+		orderList, err := queryOrders(c.Request.Context(), where)
+		if err != nil {
+			respondInternalError(c, "Failed to fetch orders", err)
+			return
+		}
+		c.JSON(http.StatusOK, OrdersResponse{Success: true, Data: orderList, Total: len(orderList)})
+		return
+	}
+
+	ordersMu.Lock()
 	orderList := make([]Order, 0, len(orders))
 	for _, order := range orders {
+		if order.DeletedAt != nil && !withDeleted {
+			continue
+		}
 		orderList = append(orderList, order)
 	}
+	ordersMu.Unlock()
 
 	// Sort orders by creation date (newest first)
 	sort.Slice(orderList, func(i, j int) bool {
@@ -341,10 +675,32 @@ func getAllOrders(c *gin.Context) {
 // getOrderByNumber handles GET /api/orders/:orderNumber
 func getOrderByNumber(c *gin.Context) {
 	orderNumber := c.Param("orderNumber")
+	withDeleted := includeDeleted(c)
+
+	if db != nil {
+		where := "order_number = $1 AND deleted_at IS NULL"
+		if withDeleted {
+			where = "order_number = $1"
+		}
+		list, err := queryOrders(c.Request.Context(), where, orderNumber)
+		if err != nil {
+			respondInternalError(c, "Failed to fetch order", err)
+			return
+		}
+		if len(list) == 0 {
+			respondError(c, ErrOrderNotFound, "")
+			return
+		}
+		c.JSON(http.StatusOK, OrderDetailResponse{Success: true, Data: list[0]})
+		return
+	}
+
+	ordersMu.Lock()
+	defer ordersMu.Unlock()
 
 	// Find order by order number
 	for _, order := range orders {
-		if order.OrderNumber == orderNumber {
+		if order.OrderNumber == orderNumber && (order.DeletedAt == nil || withDeleted) {
 			response := OrderDetailResponse{
 				Success: true,
 				Data:    order,
@@ -354,30 +710,79 @@ func getOrderByNumber(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusNotFound, ErrorResponse{
-		Success: false,
-		Error:   "Order not found",
-	})
+	respondError(c, ErrOrderNotFound, "")
 }
 
 // updateOrderStatus handles PUT /api/orders/:orderId/status
 func updateOrderStatus(c *gin.Context) {
+	ctx := c.Request.Context()
 	orderID := c.Param("orderId")
 	var statusUpdate OrderStatus
 
 	if err := c.ShouldBindJSON(&statusUpdate); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   "Invalid status",
-			Message: err.Error(),
-		})
+		respondValidationError(c, err)
+		return
+	}
+
+	if db != nil {
+		if _, err := uuid.Parse(orderID); err != nil {
+			respondError(c, ErrOrderNotFound, "")
+			return
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			respondInternalError(c, "Failed to update order", err)
+			return
+		}
+		defer tx.Rollback()
+		before, err := snapshotRow(ctx, tx, "orders", "id", orderID)
+		if err != nil {
+			respondInternalError(c, "Failed to update order", err)
+			return
+		}
+		order, err := scanOrder(tx.QueryRowContext(ctx, `UPDATE orders SET status = $2, updated_at = $3 WHERE id = $1 AND deleted_at IS NULL RETURNING `+orderColumns,
+			orderID, statusUpdate.Status, time.Now()))
+		if err == sql.ErrNoRows {
+			respondError(c, ErrOrderNotFound, "")
+			return
+		}
+		if err != nil {
+			respondInternalError(c, "Failed to update order", err)
+			return
+		}
+		after, err := snapshotRow(ctx, tx, "orders", "id", orderID)
+		if err != nil {
+			respondInternalError(c, "Failed to update order", err)
+			return
+		}
+		if err := recordAudit(c, tx, "update_status", "order", orderID, before, after); err != nil {
+			respondInternalError(c, "Failed to update order", err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondInternalError(c, "Failed to update order", err)
+			return
+		}
+		list := []Order{order}
+		if err := attachOrderLines(ctx, list); err != nil {
+			respondInternalError(c, "Failed to fetch order", err)
+			return
+		}
+		c.JSON(http.StatusOK, OrderDetailResponse{Success: true, Data: list[0]})
 		return
 	}
 
 	// Find and update order
-	if order, exists := orders[orderID]; exists {
+	ordersMu.Lock()
+	defer ordersMu.Unlock()
+	if order, exists := orders[orderID]; exists && order.DeletedAt == nil {
+		before := order
 		order.Status = statusUpdate.Status
 		order.UpdatedAt = time.Now()
+		if err := recordAudit(c, nil, "update_status", "order", orderID, before, order); err != nil {
+			respondInternalError(c, "Failed to record audit entry", err)
+			return
+		}
 		orders[orderID] = order
 
 		response := OrderDetailResponse{
@@ -388,18 +793,54 @@ func updateOrderStatus(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusNotFound, ErrorResponse{
-		Success: false,
-		Error:   "Order not found",
-	})
+	respondError(c, ErrOrderNotFound, "")
 }
 
-// deleteOrder handles DELETE /api/orders/:orderId
+// deleteOrder handles DELETE /api/orders/:orderId. The order is only marked
+// deleted so it stays in the books until the retention job purges it.
 func deleteOrder(c *gin.Context) {
+	ctx := c.Request.Context()
 	orderID := c.Param("orderId")
+	now := time.Now()
 
-	if _, exists := orders[orderID]; exists {
-		delete(orders, orderID)
+	if db != nil {
+		if _, err := uuid.Parse(orderID); err != nil {
+			respondError(c, ErrOrderNotFound, "")
+			return
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			respondInternalError(c, "Failed to delete order", err)
+			return
+		}
+		defer tx.Rollback()
+		before, err := snapshotRow(ctx, tx, "orders", "id", orderID)
+		if err != nil {
+			respondInternalError(c, "Failed to delete order", err)
+			return
+		}
+		result, err := tx.ExecContext(ctx, `UPDATE orders SET deleted_at = $2 WHERE id = $1 AND deleted_at IS NULL`, orderID, now)
+		if err != nil {
+			respondInternalError(c, "Failed to delete order", err)
+			return
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			respondError(c, ErrOrderNotFound, "")
+			return
+		}
+		after, err := snapshotRow(ctx, tx, "orders", "id", orderID)
+		if err != nil {
+			respondInternalError(c, "Failed to delete order", err)
+			return
+		}
+		if err := recordAudit(c, tx, "delete", "order", orderID, before, after); err != nil {
+			respondInternalError(c, "Failed to delete order", err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondInternalError(c, "Failed to delete order", err)
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{
 			"success": true,
 			"message": "Order deleted successfully",
@@ -407,10 +848,25 @@ func deleteOrder(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusNotFound, ErrorResponse{
-		Success: false,
-		Error:   "Order not found",
-	})
+	ordersMu.Lock()
+	defer ordersMu.Unlock()
+
+	if order, exists := orders[orderID]; exists && order.DeletedAt == nil {
+		before := order
+		order.DeletedAt = &now
+		if err := recordAudit(c, nil, "delete", "order", orderID, before, order); err != nil {
+			respondInternalError(c, "Failed to record audit entry", err)
+			return
+		}
+		orders[orderID] = order
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "Order deleted successfully",
+		})
+		return
+	}
+
+	respondError(c, ErrOrderNotFound, "")
 }
 
 // generateToken generates a random token
@@ -422,13 +878,10 @@ func generateToken() string {
 
 // login handles POST /api/auth/login
 func login(c *gin.Context) {
+	ctx := c.Request.Context()
 	var loginReq LoginRequest
 	if err := c.ShouldBindJSON(&loginReq); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{
-			Success: false,
-			Error:   "Invalid request",
-			Message: err.Error(),
-		})
+		respondValidationError(c, err)
 		return
 	}
 
@@ -437,22 +890,21 @@ func login(c *gin.Context) {
 			id                                        int
 			username, email, name, role, passwordHash string
 		)
-		row := db.QueryRow(`SELECT id, username, email, name, role, password_hash FROM users WHERE username = $1`, loginReq.Username)
+		row := db.QueryRowContext(ctx, `SELECT id, username, email, name, role, password_hash FROM users WHERE username = $1 AND deleted_at IS NULL`, loginReq.Username)
 		if err := row.Scan(&id, &username, &email, &name, &role, &passwordHash); err != nil {
 			if err == sql.ErrNoRows {
-				c.JSON(http.StatusUnauthorized, ErrorResponse{Success: false, Error: "Invalid credentials"})
+				respondError(c, ErrInvalidCredentials, "")
 				return
 			}
-			c.JSON(http.StatusInternalServerError, ErrorResponse{Success: false, Error: "DB error", Message: err.Error()})
+			respondInternalError(c, "DB error", err)
 			return
 		}
 		if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(loginReq.Password)) != nil {
-			c.JSON(http.StatusUnauthorized, ErrorResponse{Success: false, Error: "Invalid credentials"})
+			respondError(c, ErrInvalidCredentials, "")
 			return
 		}
 		user := User{ID: id, Username: username, Email: email, Name: name, Role: role}
-		token := generateToken()
-		activeSessions[token] = user
+		token := startSession(user)
 		resp := AuthResponse{Success: true, Message: "Login successful"}
 		resp.Data.User = user
 		resp.Data.Token = token
@@ -463,11 +915,7 @@ func login(c *gin.Context) {
 	// Fallback to mock credentials
 	expectedPassword, exists := userCredentials[loginReq.Username]
 	if !exists || expectedPassword != loginReq.Password {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{
-			Success: false,
-			Error:   "Invalid credentials",
-			Message: "Username or password is incorrect",
-		})
+		respondError(c, ErrInvalidCredentials, "Username or password is incorrect")
 		return
 	}
 	var user User
@@ -477,8 +925,7 @@ func login(c *gin.Context) {
 			break
 		}
 	}
-	token := generateToken()
-	activeSessions[token] = user
+	token := startSession(user)
 	response := AuthResponse{Success: true, Message: "Login successful"}
 	response.Data.User = user
 	response.Data.Token = token
@@ -493,7 +940,7 @@ func logout(c *gin.Context) {
 		if strings.HasPrefix(token, "Bearer ") {
 			token = token[7:]
 		}
-		delete(activeSessions, token)
+		endSession(token)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -502,14 +949,55 @@ func logout(c *gin.Context) {
 	})
 }
 
+// startSession logs user in under a new token
+func startSession(user User) string {
+	token := generateToken()
+	sessionsMu.Lock()
+	activeSessions[token] = user
+	sessionsMu.Unlock()
+	return token
+}
+
+// endSession logs out the session with token
+func endSession(token string) {
+	sessionsMu.Lock()
+	delete(activeSessions, token)
+	sessionsMu.Unlock()
+}
+
+// endUserSessions logs out every session of the user with id
+func endUserSessions(id int) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	for token, u := range activeSessions {
+		if u.ID == id {
+			delete(activeSessions, token)
+		}
+	}
+}
+
+// sessionUser returns the user logged in with the request's bearer token
+func sessionUser(c *gin.Context) (User, bool) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if token == "" {
+		return User{}, false
+	}
+	return lookupSession(token)
+}
+
+// lookupSession returns the user logged in with token
+func lookupSession(token string) (User, bool) {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	user, ok := activeSessions[token]
+	return user, ok
+}
+
 // getCurrentUser handles GET /api/auth/me
 func getCurrentUser(c *gin.Context) {
 	token := c.GetHeader("Authorization")
 	if token == "" {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{
-			Success: false,
-			Error:   "No token provided",
-		})
+		respondError(c, ErrUnauthenticated, "")
 		return
 	}
 
@@ -518,12 +1006,9 @@ func getCurrentUser(c *gin.Context) {
 		token = token[7:]
 	}
 
-	user, exists := activeSessions[token]
+	user, exists := lookupSession(token)
 	if !exists {
-		c.JSON(http.StatusUnauthorized, ErrorResponse{
-			Success: false,
-			Error:   "Invalid token",
-		})
+		respondError(c, ErrInvalidToken, "")
 		return
 	}
 
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Build information, overridden at link time:
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = "unknown"
+)
+
+// readinessTimeout bounds how long a single readiness check may take
+const readinessTimeout = 2 * time.Second
+
+// BuildInfo describes the running binary
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+}
+
+// HealthCheck represents the result of a single dependency check
+type HealthCheck struct {
+	Status    string `json:"status"`
+	LatencyMs int64  `json:"latencyMs"`
+	Message   string `json:"message,omitempty"`
+}
+
+// ReadinessResponse represents the readiness probe response
+type ReadinessResponse struct {
+	Success          bool                   `json:"success"`
+	Status           string                 `json:"status"`
+	Storage          string                 `json:"storage"`
+	MigrationVersion int                    `json:"migrationVersion"`
+	Build            BuildInfo              `json:"build"`
+	Checks           map[string]HealthCheck `json:"checks"`
+}
+
+func buildInfo() BuildInfo {
+	return BuildInfo{
+		Version:   version,
+		Commit:    commit,
+		BuildTime: buildTime,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// livez handles GET /livez and GET /health. It only reports that the
+// process is serving requests; dependencies are checked by readyz.
+func livez(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "OK",
+		"message":   "Vue Shop Backend is running",
+		"language":  "Go",
+		"framework": "Gin",
+		"version":   version,
+		"build":     buildInfo(),
+	})
+}
+
+// readyz handles GET /readyz
+func readyz(c *gin.Context) {
+	resp := ReadinessResponse{
+		Success: true,
+		Status:  "OK",
+		Storage: storageMode(),
+		Build:   buildInfo(),
+		Checks:  make(map[string]HealthCheck),
+	}
+
+	if db == nil {
+		check := HealthCheck{Status: "OK", Message: "Serving mock data"}
+		// DB_REQUIRED makes the mock fallback a readiness failure
+		if os.Getenv("DB_REQUIRED") == "true" {
+			check.Status = "FAIL"
+			check.Message = "Database required but not connected"
+		}
+		resp.Checks["database"] = check
+	} else {
+		resp.Checks["database"] = checkDatabase(c.Request.Context())
+		resp.Checks["migrations"] = checkMigrations(c.Request.Context(), &resp.MigrationVersion)
+	}
+
+	for _, check := range resp.Checks {
+		if check.Status != "OK" {
+			resp.Success = false
+			resp.Status = "FAIL"
+		}
+	}
+
+	if !resp.Success {
+		c.JSON(http.StatusServiceUnavailable, resp)
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// checkDatabase pings Postgres within readinessTimeout
+func checkDatabase(ctx context.Context) HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := db.PingContext(ctx)
+	check := HealthCheck{Status: "OK", LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		slog.ErrorContext(ctx, "Readiness database check failed", "error", err)
+		check.Status = "FAIL"
+		check.Message = "Database unreachable"
+	}
+	return check
+}
+
+// checkMigrations verifies every known migration has been applied
+func checkMigrations(ctx context.Context, applied *int) HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	start := time.Now()
+	v, err := schemaVersion(ctx)
+	check := HealthCheck{Status: "OK", LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		slog.ErrorContext(ctx, "Readiness migration check failed", "error", err)
+		check.Status = "FAIL"
+		check.Message = "Schema version unavailable"
+		return check
+	}
+	*applied = v
+	if v < len(migrations) {
+		check.Status = "FAIL"
+		check.Message = "Pending migrations"
+	}
+	return check
+}
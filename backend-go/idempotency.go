@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	// maxIdempotentBodySize caps the request body buffered for hashing
+	maxIdempotentBodySize = 1 << 20
+)
+
+// idempotencyTTL is how long a stored response can be replayed
+var idempotencyTTL = getenvDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour)
+
+// idempotencyLease is how long a key stays reserved while its first request
+// runs. A request that died without releasing it frees the key once the
+// lease is over.
+var idempotencyLease = getenvDuration("IDEMPOTENCY_LEASE", time.Minute)
+
+var (
+	errIdempotencyInProgress = errors.New("request with this idempotency key is in progress")
+	errIdempotencyMismatch   = errors.New("idempotency key reused with a different request body")
+)
+
+// idempotencyRecord is a stored response for an idempotency key
+type idempotencyRecord struct {
+	// Reservation identifies the request holding the key, so one whose
+	// lease ran out can't complete or release a retry's reservation
+	Reservation string
+	RequestHash string
+	StatusCode  int
+	Response    []byte
+	ExpiresAt   time.Time
+}
+
+// idempotencyStore persists idempotency keys and the responses they produced
+type idempotencyStore interface {
+	// Reserve claims key for a new request, returning the reservation. It
+	// returns the completed record instead when the key was already used
+	// with the same hash, errIdempotencyMismatch for a different hash and
+	// errIdempotencyInProgress while the first request is still running.
+	Reserve(ctx context.Context, key, hash string) (*idempotencyRecord, string, error)
+	// Complete stores the response for a key still held by reservation,
+	// keeping it for idempotencyTTL
+	Complete(ctx context.Context, key, reservation string, status int, body []byte) error
+	// Release drops a reservation so the request can be retried
+	Release(ctx context.Context, key, reservation string) error
+	// Purge deletes expired keys
+	Purge(ctx context.Context) (int64, error)
+}
+
+// currentIdempotencyStore picks the store matching the storage mode
+func currentIdempotencyStore() idempotencyStore {
+	if db != nil {
+		return pgIdempotencyStore{}
+	}
+	return memoryIdempotency
+}
+
+// IdempotencyMiddleware makes a POST handler safe to retry. Requests carrying
+// an Idempotency-Key header run once; retries with the same key and body
+// replay the stored response, and a different body under the same key is
+// rejected with 422. Only successful responses are stored; a failed or
+// panicking request releases its key.
+func IdempotencyMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			respondError(c, ErrValidationFailed, "Idempotency-Key must be at most 255 characters")
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodySize))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				respondError(c, ErrRequestTooLarge, "")
+			} else {
+				respondValidationError(c, err)
+			}
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		ctx := c.Request.Context()
+		store := currentIdempotencyStore()
+		rec, reservation, err := store.Reserve(ctx, key, requestHash(body))
+		switch {
+		case errors.Is(err, errIdempotencyMismatch):
+			respondError(c, ErrIdempotencyKeyReused, "")
+			c.Abort()
+			return
+		case errors.Is(err, errIdempotencyInProgress):
+			respondError(c, ErrIdempotencyInProgress, "")
+			c.Abort()
+			return
+		case err != nil:
+			respondInternalError(c, "Failed to reserve idempotency key", err)
+			c.Abort()
+			return
+		case rec != nil:
+			c.Header(idempotencyReplayedHeader, "true")
+			c.Data(rec.StatusCode, "application/json; charset=utf-8", rec.Response)
+			c.Abort()
+			return
+		}
+
+		recorder := &bodyRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		completed := false
+		defer func() {
+			if !completed {
+				releaseIdempotencyKey(c, store, key, reservation)
+			}
+		}()
+		c.Next()
+		completed = true
+
+		status := recorder.Status()
+		if status < 200 || status >= 300 {
+			releaseIdempotencyKey(c, store, key, reservation)
+			return
+		}
+		// Use a fresh context so a cancelled request still records its outcome
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		if err := store.Complete(ctx, key, reservation, status, recorder.body.Bytes()); err != nil {
+			loggerFrom(c).Error("Failed to update idempotency key", "error", err)
+		}
+	}
+}
+
+// releaseIdempotencyKey frees key after a failed request, including one that
+// panicked, so the client can retry it right away
+func releaseIdempotencyKey(c *gin.Context, store idempotencyStore, key, reservation string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(c.Request.Context()), 5*time.Second)
+	defer cancel()
+	if err := store.Release(ctx, key, reservation); err != nil {
+		loggerFrom(c).Error("Failed to release idempotency key", "error", err)
+	}
+}
+
+// requestHash fingerprints a JSON body. The body is re-encoded first so
+// whitespace and key order don't count as a different request.
+func requestHash(body []byte) string {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err == nil {
+		if normalized, err := json.Marshal(v); err == nil {
+			body = normalized
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// bodyRecorder copies everything written to the response
+type bodyRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bodyRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// pgIdempotencyStore keeps keys in the idempotency_keys table
+type pgIdempotencyStore struct{}
+
+func (pgIdempotencyStore) Reserve(ctx context.Context, key, hash string) (*idempotencyRecord, string, error) {
+	now := time.Now()
+	// An expired key is free to be reused
+	if _, err := db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND expires_at < $2`, key, now); err != nil {
+		return nil, "", err
+	}
+	reservation := generateToken()
+	res, err := db.ExecContext(ctx, `INSERT INTO idempotency_keys (key, reservation, request_hash, created_at, expires_at) VALUES ($1,$2,$3,$4,$5) ON CONFLICT (key) DO NOTHING`,
+		key, reservation, hash, now, now.Add(idempotencyLease))
+	if err != nil {
+		return nil, "", err
+	}
+	if n, _ := res.RowsAffected(); n == 1 {
+		return nil, reservation, nil
+	}
+
+	var (
+		rec      idempotencyRecord
+		status   sql.NullInt64
+		response []byte
+	)
+	err = db.QueryRowContext(ctx, `SELECT request_hash, status_code, response, expires_at FROM idempotency_keys WHERE key = $1`, key).
+		Scan(&rec.RequestHash, &status, &response, &rec.ExpiresAt)
+	if err != nil {
+		return nil, "", err
+	}
+	done, err := checkIdempotencyRecord(&rec, hash, status.Valid, status.Int64, response)
+	return done, "", err
+}
+
+func (pgIdempotencyStore) Complete(ctx context.Context, key, reservation string, status int, body []byte) error {
+	_, err := db.ExecContext(ctx, `UPDATE idempotency_keys SET status_code = $3, response = $4, expires_at = $5 WHERE key = $1 AND reservation = $2`,
+		key, reservation, status, body, time.Now().Add(idempotencyTTL))
+	return err
+}
+
+func (pgIdempotencyStore) Release(ctx context.Context, key, reservation string) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = $1 AND reservation = $2 AND status_code IS NULL`, key, reservation)
+	return err
+}
+
+func (pgIdempotencyStore) Purge(ctx context.Context) (int64, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < $1`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// memoryIdempotencyStore keeps keys in memory when running on mock data
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]*idempotencyRecord
+}
+
+var memoryIdempotency = &memoryIdempotencyStore{records: make(map[string]*idempotencyRecord)}
+
+func (s *memoryIdempotencyStore) Reserve(_ context.Context, key, hash string) (*idempotencyRecord, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.records[key]
+	if !ok || time.Now().After(rec.ExpiresAt) {
+		reservation := generateToken()
+		s.records[key] = &idempotencyRecord{Reservation: reservation, RequestHash: hash, ExpiresAt: time.Now().Add(idempotencyLease)}
+		return nil, reservation, nil
+	}
+	done, err := checkIdempotencyRecord(rec, hash, rec.StatusCode != 0, int64(rec.StatusCode), rec.Response)
+	return done, "", err
+}
+
+func (s *memoryIdempotencyStore) Complete(_ context.Context, key, reservation string, status int, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.records[key]; ok && rec.Reservation == reservation {
+		rec.StatusCode = status
+		rec.Response = append([]byte(nil), body...)
+		rec.ExpiresAt = time.Now().Add(idempotencyTTL)
+	}
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Release(_ context.Context, key, reservation string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if rec, ok := s.records[key]; ok && rec.Reservation == reservation && rec.StatusCode == 0 {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+func (s *memoryIdempotencyStore) Purge(_ context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	now := time.Now()
+	for key, rec := range s.records {
+		if now.After(rec.ExpiresAt) {
+			delete(s.records, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// checkIdempotencyRecord decides what to do with an existing, unexpired key
+func checkIdempotencyRecord(rec *idempotencyRecord, hash string, completed bool, status int64, response []byte) (*idempotencyRecord, error) {
+	if rec.RequestHash != hash {
+		return nil, errIdempotencyMismatch
+	}
+	if !completed {
+		return nil, errIdempotencyInProgress
+	}
+	return &idempotencyRecord{
+		RequestHash: rec.RequestHash,
+		StatusCode:  int(status),
+		Response:    response,
+		ExpiresAt:   rec.ExpiresAt,
+	}, nil
+}
+
+// startIdempotencyPurger deletes expired keys periodically until ctx is done
+func startIdempotencyPurger(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				n, err := currentIdempotencyStore().Purge(ctx)
+				if err != nil {
+					slog.Error("Idempotency key purge failed", "error", err)
+				} else if n > 0 {
+					slog.Info("Purged expired idempotency keys", "count", n)
+				}
+			}
+		}
+	}()
+}
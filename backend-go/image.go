@@ -0,0 +1,618 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+var errImageNotFound = errors.New("image not found")
+
+// ImageStorage stores uploaded image files under slash-separated keys
+type ImageStorage interface {
+	Name() string
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns errImageNotFound when nothing is stored under key
+	Get(ctx context.Context, key string) (*StoredImage, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// StoredImage is an image file read back from storage
+type StoredImage struct {
+	Data        []byte
+	ContentType string
+	ModTime     time.Time
+}
+
+// ProductImage is one of the ordered images of a product. The first one is
+// also the product's Image. Renditions are keyed by renditionSizes name;
+// images uploaded before renditions existed have none.
+type ProductImage struct {
+	ID          int                       `json:"id"`
+	ProductID   int                       `json:"productId"`
+	Key         string                    `json:"key"`
+	URL         string                    `json:"url"`
+	ContentType string                    `json:"contentType"`
+	Size        int                       `json:"size"`
+	Width       int                       `json:"width"`
+	Height      int                       `json:"height"`
+	Renditions  map[string]ImageRendition `json:"renditions"`
+	Position    int                       `json:"position"`
+	CreatedAt   time.Time                 `json:"createdAt"`
+}
+
+// imageTypes maps the accepted content types to their file extension
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// maxImagesPerUpload caps the files accepted in one upload request
+const maxImagesPerUpload = 10
+
+var (
+	// imageStorage is the backend selected by IMAGE_STORAGE
+	imageStorage ImageStorage
+	// imageMaxBytes is the largest accepted image file
+	imageMaxBytes = int64(getenvInt("IMAGE_MAX_BYTES", 5<<20))
+	// imageBaseURL prefixes image keys to form their public URL
+	imageBaseURL = strings.TrimRight(getenv("IMAGE_BASE_URL", "/images"), "/")
+)
+
+var imageKeyPattern = regexp.MustCompile(`^[a-z0-9]+(/[a-z0-9]+)*\.[a-z]+$`)
+
+// setupImageStorage selects the image storage from the environment
+func setupImageStorage() error {
+	switch name := getenv("IMAGE_STORAGE", "local"); name {
+	case "local":
+		s, err := newLocalImageStorage(getenv("IMAGE_DIR", "uploads"))
+		if err != nil {
+			return err
+		}
+		imageStorage = s
+	case "s3":
+		s, err := newS3ImageStorage(os.Getenv("S3_ENDPOINT"), getenv("S3_REGION", "us-east-1"), os.Getenv("S3_BUCKET"),
+			os.Getenv("S3_ACCESS_KEY_ID"), os.Getenv("S3_SECRET_ACCESS_KEY"))
+		if err != nil {
+			return err
+		}
+		imageStorage = s
+	default:
+		return fmt.Errorf("unknown IMAGE_STORAGE %q", name)
+	}
+	return nil
+}
+
+// imageURL is where serveImage, or a CDN in front of the storage, serves key
+func imageURL(key string) string {
+	return imageBaseURL + "/" + key
+}
+
+const productImageColumns = `id, product_id, storage_key, content_type, size_bytes, width, height, renditions, position, created_at`
+
+func scanProductImage(row rowScanner) (ProductImage, error) {
+	var img ProductImage
+	var renditions []byte
+	err := row.Scan(&img.ID, &img.ProductID, &img.Key, &img.ContentType, &img.Size, &img.Width, &img.Height, &renditions, &img.Position, &img.CreatedAt)
+	if err != nil {
+		return img, err
+	}
+	img.URL = imageURL(img.Key)
+	img.Renditions, err = parseRenditions(renditions)
+	return img, err
+}
+
+// parseRenditions decodes the renditions column and fills in the URLs
+func parseRenditions(data []byte) (map[string]ImageRendition, error) {
+	renditions := make(map[string]ImageRendition)
+	if err := json.Unmarshal(data, &renditions); err != nil {
+		return nil, err
+	}
+	for name, r := range renditions {
+		r.URL = imageURL(r.Key)
+		renditions[name] = r
+	}
+	return renditions, nil
+}
+
+// loadProductImages returns the images of the products in display order
+func loadProductImages(ctx context.Context, q queryer, productIDs []int) (map[int][]ProductImage, error) {
+	images := make(map[int][]ProductImage)
+	rows, err := q.QueryContext(ctx, `SELECT `+productImageColumns+` FROM product_images WHERE product_id = ANY($1) ORDER BY position, id`, pq.Array(productIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		img, err := scanProductImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images[img.ProductID] = append(images[img.ProductID], img)
+	}
+	return images, rows.Err()
+}
+
+// attachImages loads the images of products from Postgres
+func attachImages(ctx context.Context, products []Product) error {
+	if db == nil || len(products) == 0 {
+		return nil
+	}
+	ids := make([]int, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+	images, err := loadProductImages(ctx, db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range products {
+		products[i].Images = images[products[i].ID]
+		if list := products[i].Images; len(list) > 0 && products[i].Image == list[0].URL {
+			products[i].ImageRenditions = make(map[string]string, len(list[0].Renditions))
+			for name, r := range list[0].Renditions {
+				products[i].ImageRenditions[name] = r.URL
+			}
+		}
+	}
+	return nil
+}
+
+// queryer is satisfied by *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// syncProductImage points the product's Image at its first image. Without
+// images left, an Image that pointed at removedURL is cleared.
+func syncProductImage(ctx context.Context, tx *sql.Tx, productID int, removedURL string) ([]ProductImage, error) {
+	images, err := loadProductImages(ctx, tx, []int{productID})
+	if err != nil {
+		return nil, err
+	}
+	list := images[productID]
+	if len(list) > 0 {
+		_, err = tx.ExecContext(ctx, `UPDATE products SET image = $1 WHERE id = $2`, list[0].URL, productID)
+	} else if removedURL != "" {
+		_, err = tx.ExecContext(ctx, `UPDATE products SET image = '' WHERE id = $1 AND image = $2`, productID, removedURL)
+	}
+	if list == nil {
+		list = []ProductImage{}
+	}
+	return list, err
+}
+
+// upload is an image file read from a multipart request
+type upload struct {
+	data        []byte
+	contentType string
+	img         image.Image
+}
+
+// readImageUploads reads and checks the "image" and "images" files of a
+// multipart request. It responds and returns false when they are unusable.
+func readImageUploads(c *gin.Context) ([]upload, bool) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, imageMaxBytes*maxImagesPerUpload+1<<20)
+	form, err := c.MultipartForm()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			respondError(c, ErrImageTooLarge, fmt.Sprintf("Uploads are limited to %d files of %d bytes", maxImagesPerUpload, imageMaxBytes))
+			return nil, false
+		}
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "image", Rule: "multipart", Message: "must be sent as multipart/form-data"})
+		return nil, false
+	}
+	defer form.RemoveAll()
+
+	files := append(form.File["image"], form.File["images"]...)
+	if len(files) == 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "image", Rule: "required", Message: "is required"})
+		return nil, false
+	}
+	if len(files) > maxImagesPerUpload {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "image", Rule: "max", Message: fmt.Sprintf("at most %d files per upload", maxImagesPerUpload)})
+		return nil, false
+	}
+
+	uploads := make([]upload, 0, len(files))
+	for _, fh := range files {
+		if fh.Size > imageMaxBytes {
+			respondError(c, ErrImageTooLarge, fmt.Sprintf("%s is larger than %d bytes", fh.Filename, imageMaxBytes))
+			return nil, false
+		}
+		f, err := fh.Open()
+		if err != nil {
+			respondInternalError(c, "Failed to read upload", err)
+			return nil, false
+		}
+		data, err := io.ReadAll(io.LimitReader(f, imageMaxBytes+1))
+		f.Close()
+		if err != nil {
+			respondInternalError(c, "Failed to read upload", err)
+			return nil, false
+		}
+		if int64(len(data)) > imageMaxBytes {
+			respondError(c, ErrImageTooLarge, fmt.Sprintf("%s is larger than %d bytes", fh.Filename, imageMaxBytes))
+			return nil, false
+		}
+		// Trust the bytes, not the client's Content-Type or file name
+		contentType := http.DetectContentType(data)
+		if _, ok := imageTypes[contentType]; !ok {
+			respondError(c, ErrUnsupportedImageType, fmt.Sprintf("%s is %s; accepted types are JPEG, PNG, GIF and WebP", fh.Filename, contentType))
+			return nil, false
+		}
+		img, err := decodeImage(data, contentType)
+		if errors.Is(err, errImageDimensions) {
+			respondError(c, ErrImageTooLarge, fmt.Sprintf("%s has more than %d pixels", fh.Filename, maxImagePixels))
+			return nil, false
+		}
+		if err != nil {
+			respondError(c, ErrUnsupportedImageType, fmt.Sprintf("%s could not be decoded as %s", fh.Filename, contentType))
+			return nil, false
+		}
+		uploads = append(uploads, upload{data: data, contentType: contentType, img: img})
+	}
+	return uploads, true
+}
+
+// uploadProductImages handles POST /api/admin/products/:id/images. New
+// images are appended after the existing ones.
+func uploadProductImages(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid product ID")
+		return
+	}
+	exists, err := productExists(ctx, productID)
+	if err != nil {
+		respondInternalError(c, "Failed to load product", err)
+		return
+	}
+	if !exists {
+		respondError(c, ErrProductNotFound, "")
+		return
+	}
+	uploads, ok := readImageUploads(c)
+	if !ok {
+		return
+	}
+
+	// Store the files first; if saving their rows fails they are removed again
+	keys := make([]string, 0, len(uploads))
+	removeStored := func() {
+		for _, key := range keys {
+			if err := imageStorage.Delete(context.WithoutCancel(ctx), key); err != nil {
+				loggerFrom(c).Error("Failed to remove stored image", "error", err, "key", key)
+			}
+		}
+	}
+	images := make([]ProductImage, 0, len(uploads))
+	for _, u := range uploads {
+		key := fmt.Sprintf("products/%d/%s%s", productID, randomHex(16), imageTypes[u.contentType])
+		img, err := storeImage(ctx, key, u)
+		keys = append(keys, img.keys()...)
+		if err != nil {
+			removeStored()
+			respondInternalError(c, "Failed to store image", err)
+			return
+		}
+		images = append(images, img)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		removeStored()
+		respondInternalError(c, "Failed to save images", err)
+		return
+	}
+	defer tx.Rollback()
+	// Lock the product so concurrent uploads get distinct positions
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM products WHERE id = $1 FOR UPDATE`, productID); err != nil {
+		removeStored()
+		respondInternalError(c, "Failed to save images", err)
+		return
+	}
+	for _, img := range images {
+		renditions, err := json.Marshal(img.Renditions)
+		if err != nil {
+			removeStored()
+			respondInternalError(c, "Failed to save images", err)
+			return
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO product_images (product_id, storage_key, content_type, size_bytes, width, height, renditions, position, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, (SELECT COALESCE(MAX(position) + 1, 0) FROM product_images WHERE product_id = $1), $8)`,
+			productID, img.Key, img.ContentType, img.Size, img.Width, img.Height, renditions, time.Now())
+		if err != nil {
+			removeStored()
+			respondInternalError(c, "Failed to save images", err)
+			return
+		}
+	}
+	images, err = syncProductImage(ctx, tx, productID, "")
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		removeStored()
+		respondInternalError(c, "Failed to save images", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    images,
+		"message": "Images uploaded successfully",
+	})
+}
+
+// ImageOrderRequest lists every image of a product in its new order
+type ImageOrderRequest struct {
+	ImageIDs []int `json:"imageIds" binding:"required"`
+}
+
+// reorderProductImages handles PUT /api/admin/products/:id/images/order
+func reorderProductImages(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid product ID")
+		return
+	}
+	var req ImageOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, "Failed to reorder images", err)
+		return
+	}
+	defer tx.Rollback()
+	err = tx.QueryRowContext(ctx, `SELECT id FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, productID).Scan(&productID)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, ErrProductNotFound, "")
+		return
+	}
+	if err != nil {
+		respondInternalError(c, "Failed to reorder images", err)
+		return
+	}
+	current, err := loadProductImages(ctx, tx, []int{productID})
+	if err != nil {
+		respondInternalError(c, "Failed to reorder images", err)
+		return
+	}
+	if !sameImageSet(current[productID], req.ImageIDs) {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{
+			Field:   "imageIds",
+			Rule:    "permutation",
+			Message: "must list every image of the product exactly once",
+		})
+		return
+	}
+	for position, id := range req.ImageIDs {
+		if _, err := tx.ExecContext(ctx, `UPDATE product_images SET position = $1 WHERE id = $2`, position, id); err != nil {
+			respondInternalError(c, "Failed to reorder images", err)
+			return
+		}
+	}
+	images, err := syncProductImage(ctx, tx, productID, "")
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		respondInternalError(c, "Failed to reorder images", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    images,
+		"message": "Images reordered successfully",
+	})
+}
+
+// sameImageSet reports whether ids holds the IDs of images exactly once each
+func sameImageSet(images []ProductImage, ids []int) bool {
+	if len(images) != len(ids) {
+		return false
+	}
+	seen := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		seen[id] = true
+	}
+	for _, img := range images {
+		if !seen[img.ID] {
+			return false
+		}
+		delete(seen, img.ID)
+	}
+	return len(seen) == 0
+}
+
+// deleteProductImage handles DELETE /api/admin/products/:id/images/:imageId
+func deleteProductImage(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid product ID")
+		return
+	}
+	imageID, err := strconv.Atoi(c.Param("imageId"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid image ID")
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, "Failed to delete image", err)
+		return
+	}
+	defer tx.Rollback()
+	var key string
+	var renditions []byte
+	err = tx.QueryRowContext(ctx, `DELETE FROM product_images WHERE id = $1 AND product_id = $2 RETURNING storage_key, renditions`, imageID, productID).Scan(&key, &renditions)
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, ErrImageNotFound, "")
+		return
+	}
+	if err != nil {
+		respondInternalError(c, "Failed to delete image", err)
+		return
+	}
+	images, err := syncProductImage(ctx, tx, productID, imageURL(key))
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		respondInternalError(c, "Failed to delete image", err)
+		return
+	}
+	// The row is gone, so a file left behind is only wasted space
+	removed := ProductImage{Key: key}
+	removed.Renditions, err = parseRenditions(renditions)
+	if err != nil {
+		loggerFrom(c).Error("Failed to read image renditions", "error", err, "key", key)
+	}
+	for _, k := range removed.keys() {
+		if err := imageStorage.Delete(ctx, k); err != nil {
+			loggerFrom(c).Error("Failed to remove stored image", "error", err, "key", k)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    images,
+		"message": "Image deleted successfully",
+	})
+}
+
+// serveImage handles GET /images/*key. Keys are random and never reused, so
+// responses can be cached for good.
+func serveImage(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !imageKeyPattern.MatchString(key) || path.Clean(key) != key {
+		respondError(c, ErrImageNotFound, "")
+		return
+	}
+	img, err := imageStorage.Get(c.Request.Context(), key)
+	if errors.Is(err, errImageNotFound) {
+		respondError(c, ErrImageNotFound, "")
+		return
+	}
+	if err != nil {
+		respondInternalError(c, "Failed to load image", err)
+		return
+	}
+
+	c.Header("Content-Type", img.ContentType)
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("ETag", `"`+sha256Hex([]byte(key))[:32]+`"`)
+	c.Header("X-Content-Type-Options", "nosniff")
+	http.ServeContent(c.Writer, c.Request, path.Base(key), img.ModTime, bytes.NewReader(img.Data))
+}
+
+// queryImageKeys returns the keys of the originals and renditions of the
+// images selected by query, which selects storage_key and renditions
+func queryImageKeys(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var img ProductImage
+		var renditions []byte
+		if err := rows.Scan(&img.Key, &renditions); err != nil {
+			return nil, err
+		}
+		if img.Renditions, err = parseRenditions(renditions); err != nil {
+			return nil, err
+		}
+		keys = append(keys, img.keys()...)
+	}
+	return keys, rows.Err()
+}
+
+// keys returns the storage keys of the image and its renditions
+func (img ProductImage) keys() []string {
+	var keys []string
+	if img.Key != "" {
+		keys = append(keys, img.Key)
+	}
+	for _, r := range img.Renditions {
+		keys = append(keys, r.Key)
+	}
+	return keys
+}
+
+// storeImage stores an upload and its renditions next to it, under
+// "<key without extension>/<rendition>.<ext>". The returned image lists
+// whatever was stored, even on error, so it can be removed again.
+func storeImage(ctx context.Context, key string, u upload) (ProductImage, error) {
+	b := u.img.Bounds()
+	img := ProductImage{Key: key, ContentType: u.contentType, Size: len(u.data), Width: b.Dx(), Height: b.Dy()}
+	if err := imageStorage.Put(ctx, key, u.data, u.contentType); err != nil {
+		return ProductImage{}, err
+	}
+	files, err := makeRenditions(u.img)
+	if err != nil {
+		return img, err
+	}
+	img.Renditions = make(map[string]ImageRendition, len(files))
+	base := strings.TrimSuffix(key, path.Ext(key))
+	for _, f := range files {
+		rkey := base + "/" + f.name + imageTypes[f.contentType]
+		if err := imageStorage.Put(ctx, rkey, f.data, f.contentType); err != nil {
+			return img, err
+		}
+		img.Renditions[f.name] = ImageRendition{Key: rkey, URL: imageURL(rkey), Width: f.width, Height: f.height, ContentType: f.contentType}
+	}
+	return img, nil
+}
+
+// purgeImages removes stored files whose rows were deleted; failures are
+// logged since the rows are already gone
+func purgeImages(ctx context.Context, keys []string) {
+	for _, key := range keys {
+		if err := imageStorage.Delete(ctx, key); err != nil {
+			slog.Error("Failed to remove stored image", "error", err, "key", key)
+		}
+	}
+}
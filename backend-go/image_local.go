@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// localImageStorage keeps images as files under a directory
+type localImageStorage struct {
+	root string
+}
+
+func newLocalImageStorage(root string) (*localImageStorage, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &localImageStorage{root: root}, nil
+}
+
+func (s *localImageStorage) Name() string { return "local" }
+
+// path maps a key onto a file below root
+func (s *localImageStorage) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *localImageStorage) Put(_ context.Context, key string, data []byte, _ string) error {
+	name := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so a half-written image is never served
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *localImageStorage) Get(_ context.Context, key string) (*StoredImage, error) {
+	name := s.path(key)
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errImageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return &StoredImage{Data: data, ContentType: contentType, ModTime: info.ModTime()}, nil
+}
+
+func (s *localImageStorage) Delete(_ context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
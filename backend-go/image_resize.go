@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// ImageRendition is a resized copy of an uploaded image
+type ImageRendition struct {
+	Key         string `json:"key"`
+	URL         string `json:"url"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	ContentType string `json:"contentType"`
+}
+
+// renditionSizes are the renditions made of every upload. Each fits inside
+// a square of the given size and is never larger than the original.
+var renditionSizes = []struct {
+	name string
+	size int
+}{
+	{"thumbnail", 200},
+	{"medium", 600},
+	{"large", 1200},
+}
+
+// maxImagePixels bounds the decoded size of an upload, since a small file
+// can decode into a huge bitmap
+const maxImagePixels = 40_000_000
+
+var errImageDimensions = errors.New("image dimensions too large")
+
+// renditionFile is an encoded rendition waiting to be stored
+type renditionFile struct {
+	name        string
+	data        []byte
+	contentType string
+	width       int
+	height      int
+}
+
+// imageDecoders decode the accepted imageTypes. GIFs decode to their first
+// frame.
+var imageDecoders = map[string]func(io.Reader) (image.Image, error){
+	"image/jpeg": jpeg.Decode,
+	"image/png":  png.Decode,
+	"image/gif":  gif.Decode,
+	"image/webp": webp.Decode,
+}
+
+// decodeImage decodes an upload after checking its dimensions
+func decodeImage(data []byte, contentType string) (image.Image, error) {
+	decode, ok := imageDecoders[contentType]
+	if !ok {
+		return nil, fmt.Errorf("cannot decode %s", contentType)
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, errImageDimensions
+	}
+	return decode(bytes.NewReader(data))
+}
+
+// makeRenditions resizes img to every renditionSizes entry. Images with
+// transparency become PNGs, everything else JPEGs.
+func makeRenditions(img image.Image) ([]renditionFile, error) {
+	opaque := true
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		opaque = o.Opaque()
+	}
+	files := make([]renditionFile, 0, len(renditionSizes))
+	for _, r := range renditionSizes {
+		resized := resizeToFit(img, r.size)
+		var buf bytes.Buffer
+		contentType := "image/jpeg"
+		var err error
+		if opaque {
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 85})
+		} else {
+			contentType = "image/png"
+			err = png.Encode(&buf, resized)
+		}
+		if err != nil {
+			return nil, err
+		}
+		b := resized.Bounds()
+		files = append(files, renditionFile{name: r.name, data: buf.Bytes(), contentType: contentType, width: b.Dx(), height: b.Dy()})
+	}
+	return files, nil
+}
+
+// resizeToFit scales img down to fit inside a size×size square, keeping its
+// aspect ratio
+func resizeToFit(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/w)
+		} else {
+			w, h = max(1, w*size/h), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
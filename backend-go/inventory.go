@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Inventory movement types
+const (
+	movementSale       = "sale"
+	movementRestock    = "restock"
+	movementAdjustment = "adjustment"
+	movementReturn     = "return"
+)
+
+var errStockNotFound = errors.New("product or variant not found")
+
+// InventoryMovement is one entry of the stock ledger. Quantity is the signed
+// change and StockAfter the stock of the product, or of the variant when
+// VariantID is set, once it was applied.
+type InventoryMovement struct {
+	ID         int64     `json:"id"`
+	ProductID  int       `json:"productId"`
+	VariantID  int       `json:"variantId,omitempty"`
+	Type       string    `json:"type"`
+	Quantity   int       `json:"quantity"`
+	StockAfter int       `json:"stockAfter"`
+	Reason     string    `json:"reason"`
+	OrderID    string    `json:"orderId,omitempty"`
+	ActorID    *int      `json:"actorId"`
+	Actor      string    `json:"actor"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// StockAdjustmentRequest represents POST /api/admin/products/:id/stock-adjustments
+type StockAdjustmentRequest struct {
+	VariantID int    `json:"variantId" binding:"min=0"`
+	Quantity  int    `json:"quantity" binding:"required"`
+	Type      string `json:"type" binding:"omitempty,oneof=restock adjustment return"`
+	Reason    string `json:"reason" binding:"required,max=500"`
+}
+
+// stockError reports a movement that would take stock below zero
+type stockError struct {
+	available int
+}
+
+func (e *stockError) Error() string {
+	return fmt.Sprintf("only %d items in stock", e.available)
+}
+
+// setActor attributes the movement to the request's user
+func (m *InventoryMovement) setActor(c *gin.Context) {
+	if user, ok := sessionUser(c); ok {
+		id := user.ID
+		m.ActorID = &id
+		m.Actor = user.Username
+	}
+}
+
+// moveStock applies m.Quantity to the stock of the product, or of its
+// variant and with it the product's total, and records the movement. The
+// change is relative, so concurrent movements never overwrite each other;
+// one that would leave less than zero in stock fails with a *stockError.
+func moveStock(ctx context.Context, tx *sql.Tx, m *InventoryMovement) error {
+	var err error
+	if m.VariantID != 0 {
+		err = tx.QueryRowContext(ctx, `UPDATE product_variants SET stock = stock + $1 WHERE id = $2 AND product_id = $3 AND stock + $1 >= 0 RETURNING stock`,
+			m.Quantity, m.VariantID, m.ProductID).Scan(&m.StockAfter)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, `UPDATE products SET stock = stock + $1 WHERE id = $2`, m.Quantity, m.ProductID)
+		}
+	} else {
+		err = tx.QueryRowContext(ctx, `UPDATE products SET stock = stock + $1 WHERE id = $2 AND stock + $1 >= 0 RETURNING stock`,
+			m.Quantity, m.ProductID).Scan(&m.StockAfter)
+	}
+	if err == sql.ErrNoRows {
+		return currentStockError(ctx, tx, m)
+	}
+	if err != nil {
+		return err
+	}
+
+	m.CreatedAt = time.Now()
+	var orderID interface{}
+	if m.OrderID != "" {
+		orderID = m.OrderID
+	}
+	return tx.QueryRowContext(ctx, `INSERT INTO inventory_movements (product_id, variant_id, type, quantity, stock_after, reason, order_id, actor_id, actor, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING id`,
+		m.ProductID, variantIDArg(m.VariantID), m.Type, m.Quantity, m.StockAfter, m.Reason, orderID, m.ActorID, m.Actor, m.CreatedAt).Scan(&m.ID)
+}
+
+// currentStockError tells a missing product or variant from one short of stock
+func currentStockError(ctx context.Context, tx *sql.Tx, m *InventoryMovement) error {
+	var available int
+	var err error
+	if m.VariantID != 0 {
+		err = tx.QueryRowContext(ctx, `SELECT stock FROM product_variants WHERE id = $1 AND product_id = $2`, m.VariantID, m.ProductID).Scan(&available)
+	} else {
+		err = tx.QueryRowContext(ctx, `SELECT stock FROM products WHERE id = $1`, m.ProductID).Scan(&available)
+	}
+	if err == sql.ErrNoRows {
+		return errStockNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return &stockError{available: available}
+}
+
+// createStockAdjustment handles POST /api/admin/products/:id/stock-adjustments.
+// The quantity is added to the current stock, so it is negative for
+// removals. Products with variants are adjusted per variant.
+func createStockAdjustment(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid product ID")
+		return
+	}
+	var req StockAdjustmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	if req.Type == "" {
+		req.Type = movementAdjustment
+	}
+	if req.Type != movementAdjustment && req.Quantity < 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "quantity", Rule: "gt", Message: fmt.Sprintf("must be positive for a %s", req.Type)})
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, "Failed to adjust stock", err)
+		return
+	}
+	defer tx.Rollback()
+
+	var hasVariants bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM product_variants WHERE product_id = p.id) FROM products p WHERE p.id = $1 AND p.deleted_at IS NULL FOR UPDATE`, productID).Scan(&hasVariants)
+	if err == sql.ErrNoRows {
+		respondError(c, ErrProductNotFound, "")
+		return
+	}
+	if err != nil {
+		respondInternalError(c, "Failed to adjust stock", err)
+		return
+	}
+	if hasVariants && req.VariantID == 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "variantId", Rule: "required", Message: "is required for a product with variants"})
+		return
+	}
+	if !hasVariants && req.VariantID != 0 {
+		respondError(c, ErrVariantNotFound, "")
+		return
+	}
+
+	movement := InventoryMovement{ProductID: productID, VariantID: req.VariantID, Type: req.Type, Quantity: req.Quantity, Reason: req.Reason}
+	movement.setActor(c)
+	if err := moveStock(ctx, tx, &movement); err != nil {
+		var serr *stockError
+		switch {
+		case errors.As(err, &serr):
+			respondError(c, ErrInsufficientStock, fmt.Sprintf("Cannot remove %d items, only %d in stock", -req.Quantity, serr.available))
+		case errors.Is(err, errStockNotFound):
+			respondError(c, ErrVariantNotFound, "")
+		default:
+			respondInternalError(c, "Failed to adjust stock", err)
+		}
+		return
+	}
+	entity, entityID := "product", productID
+	if req.VariantID != 0 {
+		entity, entityID = "product_variant", req.VariantID
+	}
+	before := movement.StockAfter - movement.Quantity
+	if err := recordAudit(c, tx, "adjust_stock", entity, strconv.Itoa(entityID), gin.H{"stock": before, "reason": ""}, gin.H{"stock": movement.StockAfter, "reason": movement.Reason}); err != nil {
+		respondInternalError(c, "Failed to adjust stock", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, "Failed to adjust stock", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    movement,
+		"message": "Stock adjusted successfully",
+	})
+}
+
+// getInventoryMovements handles GET /api/admin/products/:id/inventory-movements,
+// newest first
+func getInventoryMovements(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid product ID")
+		return
+	}
+	if exists, err := productExists(ctx, productID); err != nil {
+		respondInternalError(c, "Failed to fetch inventory movements", err)
+		return
+	} else if !exists {
+		respondError(c, ErrProductNotFound, "")
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT id, product_id, COALESCE(variant_id, 0), type, quantity, stock_after, reason, COALESCE(order_id::text, ''), actor_id, actor, created_at
+		FROM inventory_movements WHERE product_id = $1 ORDER BY id DESC`, productID)
+	if err != nil {
+		respondInternalError(c, "Failed to fetch inventory movements", err)
+		return
+	}
+	defer rows.Close()
+	movements := make([]InventoryMovement, 0)
+	for rows.Next() {
+		var m InventoryMovement
+		var actorID sql.NullInt64
+		if err := rows.Scan(&m.ID, &m.ProductID, &m.VariantID, &m.Type, &m.Quantity, &m.StockAfter, &m.Reason, &m.OrderID, &actorID, &m.Actor, &m.CreatedAt); err != nil {
+			respondInternalError(c, "Failed to fetch inventory movements", err)
+			return
+		}
+		if actorID.Valid {
+			id := int(actorID.Int64)
+			m.ActorID = &id
+		}
+		movements = append(movements, m)
+	}
+	if err := rows.Err(); err != nil {
+		respondInternalError(c, "Failed to fetch inventory movements", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": movements, "total": len(movements)})
+}
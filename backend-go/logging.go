@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength caps client supplied IDs so they can't bloat log lines
+	maxRequestIDLength = 128
+
+	requestIDKey = "requestID"
+	loggerKey    = "logger"
+)
+
+// setupLogger configures the default slog logger from LOG_LEVEL
+// (debug, info, warn, error) and LOG_FORMAT (json, text).
+func setupLogger() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(getenv("LOG_LEVEL", "info"))); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	if strings.EqualFold(getenv("LOG_FORMAT", "json"), "text") {
+		handler = slog.NewTextHandler(os.Stdout, opts)
+	} else {
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// validRequestID reports whether a client supplied request ID is safe to reuse
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDMiddleware honors an incoming X-Request-ID or generates one, echoes
+// it on the response and attaches a request scoped logger to the context.
+// It must run after TracingMiddleware so log lines carry the trace ID.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+
+		ctx := c.Request.Context()
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("request.id", id))
+		logger := slog.Default().With("request_id", id)
+		if attrs := traceAttrs(ctx); attrs != nil {
+			logger = logger.With(attrs...)
+		}
+		c.Set(loggerKey, logger)
+		c.Next()
+	}
+}
+
+// RequestLoggerMiddleware writes one structured log line per request
+func RequestLoggerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", status,
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"client_ip", c.ClientIP(),
+			"bytes", c.Writer.Size(),
+			"user_agent", c.Request.UserAgent(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		loggerFrom(c).Log(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// RecoveryMiddleware logs panics as structured errors and returns a 500
+func RecoveryMiddleware() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, recovered any) {
+		loggerFrom(c).Error("panic recovered", "panic", recovered, "stack", string(debug.Stack()))
+		respondError(c, ErrInternal, "")
+		c.Abort()
+	})
+}
+
+// requestID returns the ID assigned by RequestIDMiddleware
+func requestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// loggerFrom returns the request scoped logger, or the default logger when
+// the request didn't pass through RequestIDMiddleware.
+func loggerFrom(c *gin.Context) *slog.Logger {
+	if l, ok := c.Get(loggerKey); ok {
+		if logger, ok := l.(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
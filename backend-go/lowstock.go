@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LowStockAlert reports a product whose stock fell to its reorder threshold
+type LowStockAlert struct {
+	ProductID  int       `json:"productId"`
+	SKU        string    `json:"sku,omitempty"`
+	Name       string    `json:"name"`
+	Stock      int       `json:"stock"`
+	Threshold  int       `json:"threshold"`
+	DetectedAt time.Time `json:"detectedAt"`
+}
+
+// LowStockProduct is an entry of GET /api/admin/inventory/low-stock.
+// AlertedAt is when the checker sent its alert, if it has yet.
+type LowStockProduct struct {
+	ProductID int        `json:"productId"`
+	SKU       string     `json:"sku,omitempty"`
+	Name      string     `json:"name"`
+	Stock     int        `json:"stock"`
+	Threshold int        `json:"threshold"`
+	AlertedAt *time.Time `json:"alertedAt"`
+}
+
+// StockNotifier delivers low-stock alerts
+type StockNotifier interface {
+	Name() string
+	// Notify delivers a batch of alerts; on error the checker sends them
+	// again on its next run
+	Notify(ctx context.Context, alerts []LowStockAlert) error
+}
+
+// stockNotifier is the notifier selected by LOW_STOCK_NOTIFIER
+var stockNotifier StockNotifier
+
+// setupStockNotifier selects the low-stock notifier from the environment
+func setupStockNotifier() error {
+	switch name := getenv("LOW_STOCK_NOTIFIER", "log"); name {
+	case "log":
+		stockNotifier = logNotifier{}
+	case "webhook":
+		n, err := newWebhookNotifier(os.Getenv("LOW_STOCK_WEBHOOK_URL"), os.Getenv("LOW_STOCK_WEBHOOK_SECRET"))
+		if err != nil {
+			return err
+		}
+		stockNotifier = n
+	case "outbox":
+		stockNotifier = newOutboxNotifier(getenv("LOW_STOCK_OUTBOX", "outbox/low-stock.ndjson"))
+	default:
+		return fmt.Errorf("unknown LOW_STOCK_NOTIFIER %q", name)
+	}
+	return nil
+}
+
+// logNotifier writes alerts to the application log
+type logNotifier struct{}
+
+func (logNotifier) Name() string { return "log" }
+
+func (logNotifier) Notify(ctx context.Context, alerts []LowStockAlert) error {
+	for _, a := range alerts {
+		slog.WarnContext(ctx, "Product stock is low", "product_id", a.ProductID, "sku", a.SKU, "name", a.Name, "stock", a.Stock, "threshold", a.Threshold)
+	}
+	return nil
+}
+
+// In-memory alert state used when running on mock data
+var (
+	mockAlertsMu sync.Mutex
+	mockAlerts   = map[int]time.Time{}
+)
+
+// productThreshold is the reorder threshold of a product, falling back to
+// LOW_STOCK_THRESHOLD
+func productThreshold(p Product) int {
+	if p.ReorderThreshold != nil {
+		return *p.ReorderThreshold
+	}
+	return lowStockThreshold
+}
+
+// checkLowStock alerts on every product whose stock is at or below its
+// threshold and hasn't been alerted on since it last was above it. Claiming
+// the alerts and sending them share a transaction, so a failed delivery is
+// retried and several instances never alert twice.
+func checkLowStock(ctx context.Context) (int, error) {
+	if db == nil {
+		return checkMockLowStock(ctx)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Products back above their threshold can alert again
+	if _, err := tx.ExecContext(ctx, `DELETE FROM low_stock_alerts a USING products p
+		WHERE p.id = a.product_id AND (p.deleted_at IS NOT NULL OR p.stock > COALESCE(p.reorder_threshold, $1))`, lowStockThreshold); err != nil {
+		return 0, err
+	}
+	rows, err := tx.QueryContext(ctx, `WITH claimed AS (
+			INSERT INTO low_stock_alerts (product_id, stock, threshold, alerted_at)
+			SELECT id, stock, COALESCE(reorder_threshold, $1), $2 FROM products
+			WHERE deleted_at IS NULL AND stock <= COALESCE(reorder_threshold, $1)
+			ON CONFLICT (product_id) DO NOTHING
+			RETURNING product_id, stock, threshold, alerted_at
+		)
+		SELECT c.product_id, COALESCE(p.sku, ''), p.name, c.stock, c.threshold, c.alerted_at
+		FROM claimed c JOIN products p ON p.id = c.product_id ORDER BY c.product_id`, lowStockThreshold, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	var alerts []LowStockAlert
+	for rows.Next() {
+		var a LowStockAlert
+		if err := rows.Scan(&a.ProductID, &a.SKU, &a.Name, &a.Stock, &a.Threshold, &a.DetectedAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		alerts = append(alerts, a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(alerts) > 0 {
+		if err := stockNotifier.Notify(ctx, alerts); err != nil {
+			return 0, fmt.Errorf("%s notifier: %w", stockNotifier.Name(), err)
+		}
+	}
+	return len(alerts), tx.Commit()
+}
+
+// checkMockLowStock is checkLowStock for the mock catalog
+func checkMockLowStock(ctx context.Context) (int, error) {
+	mockAlertsMu.Lock()
+	defer mockAlertsMu.Unlock()
+	now := time.Now()
+	var alerts []LowStockAlert
+	for _, p := range mockProducts {
+		threshold := productThreshold(p)
+		if p.Stock > threshold {
+			delete(mockAlerts, p.ID)
+			continue
+		}
+		if _, alerted := mockAlerts[p.ID]; !alerted {
+			alerts = append(alerts, LowStockAlert{ProductID: p.ID, SKU: p.SKU, Name: p.Name, Stock: p.Stock, Threshold: threshold, DetectedAt: now})
+		}
+	}
+	if len(alerts) == 0 {
+		return 0, nil
+	}
+	if err := stockNotifier.Notify(ctx, alerts); err != nil {
+		return 0, fmt.Errorf("%s notifier: %w", stockNotifier.Name(), err)
+	}
+	for _, a := range alerts {
+		mockAlerts[a.ProductID] = now
+	}
+	return len(alerts), nil
+}
+
+// startLowStockChecker runs checkLowStock periodically until ctx is done
+func startLowStockChecker(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if n, err := checkLowStock(ctx); err != nil {
+					slog.Error("Low stock check failed", "error", err)
+				} else if n > 0 {
+					slog.Info("Sent low stock alerts", "products", n, "notifier", stockNotifier.Name())
+				}
+			}
+		}
+	}()
+}
+
+// getLowStockProducts handles GET /api/admin/inventory/low-stock, listing
+// the products at or below their reorder threshold, lowest stock first
+func getLowStockProducts(c *gin.Context) {
+	ctx := c.Request.Context()
+	products := make([]LowStockProduct, 0)
+	if db != nil {
+		rows, err := db.QueryContext(ctx, `SELECT p.id, COALESCE(p.sku, ''), p.name, p.stock, COALESCE(p.reorder_threshold, $1), a.alerted_at
+			FROM products p LEFT JOIN low_stock_alerts a ON a.product_id = p.id
+			WHERE p.deleted_at IS NULL AND p.stock <= COALESCE(p.reorder_threshold, $1)
+			ORDER BY p.stock, p.id`, lowStockThreshold)
+		if err != nil {
+			respondInternalError(c, "Failed to fetch low stock products", err)
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p LowStockProduct
+			var alertedAt sql.NullTime
+			if err := rows.Scan(&p.ProductID, &p.SKU, &p.Name, &p.Stock, &p.Threshold, &alertedAt); err != nil {
+				respondInternalError(c, "Failed to fetch low stock products", err)
+				return
+			}
+			if alertedAt.Valid {
+				p.AlertedAt = &alertedAt.Time
+			}
+			products = append(products, p)
+		}
+		if err := rows.Err(); err != nil {
+			respondInternalError(c, "Failed to fetch low stock products", err)
+			return
+		}
+	} else {
+		mockAlertsMu.Lock()
+		for _, mp := range mockProducts {
+			threshold := productThreshold(mp)
+			if mp.Stock > threshold {
+				continue
+			}
+			p := LowStockProduct{ProductID: mp.ID, SKU: mp.SKU, Name: mp.Name, Stock: mp.Stock, Threshold: threshold}
+			if t, ok := mockAlerts[mp.ID]; ok {
+				p.AlertedAt = &t
+			}
+			products = append(products, p)
+		}
+		mockAlertsMu.Unlock()
+		sort.Slice(products, func(i, j int) bool {
+			if products[i].Stock != products[j].Stock {
+				return products[i].Stock < products[j].Stock
+			}
+			return products[i].ProductID < products[j].ProductID
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success":          true,
+		"defaultThreshold": lowStockThreshold,
+		"data":             products,
+		"total":            len(products),
+	})
+}
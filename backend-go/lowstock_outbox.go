@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// outboxNotifier appends each alert as a line of JSON to a local file, for
+// another process to pick up
+type outboxNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newOutboxNotifier(path string) *outboxNotifier {
+	return &outboxNotifier{path: path}
+}
+
+func (n *outboxNotifier) Name() string { return "outbox" }
+
+func (n *outboxNotifier) Notify(ctx context.Context, alerts []LowStockAlert) error {
+	var buf []byte
+	for _, a := range alerts {
+		line, err := json.Marshal(a)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(n.path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	// Write the batch at once so concurrent checks can't interleave lines
+	if _, err := f.Write(buf); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// lowStockSignatureHeader carries the signature of a low-stock webhook,
+// in the same "t=<unix>,v1=<hex>" form as payment webhooks
+const lowStockSignatureHeader = "Shop-Signature"
+
+// webhookNotifier POSTs each batch of alerts as JSON to a URL, signed with
+// LOW_STOCK_WEBHOOK_SECRET when one is set
+type webhookNotifier struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func newWebhookNotifier(url, secret string) (*webhookNotifier, error) {
+	if url == "" {
+		return nil, errors.New("webhook notifier requires LOW_STOCK_WEBHOOK_URL")
+	}
+	return &webhookNotifier{url: url, secret: secret, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+func (n *webhookNotifier) Name() string { return "webhook" }
+
+func (n *webhookNotifier) Notify(ctx context.Context, alerts []LowStockAlert) error {
+	payload, err := json.Marshal(struct {
+		Type   string          `json:"type"`
+		Alerts []LowStockAlert `json:"alerts"`
+	}{"inventory.low_stock", alerts})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if n.secret != "" {
+		req.Header.Set(lowStockSignatureHeader, signWebhook(payload, n.secret, time.Now()))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: POST %s: %d", n.url, resp.StatusCode)
+	}
+	return nil
+}
@@ -1,83 +1,73 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// Define metrics
-var (
-	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
-		Name: "http_requests_total",
-		Help: "Total number of HTTP requests",
-	}, []string{"method", "path", "status_code"})
-
-	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
-		Name:    "http_request_duration_seconds",
-		Help:    "Duration of HTTP requests in seconds",
-		Buckets: []float64{0.1, 0.5, 1, 2, 5},
-	}, []string{"method", "path"})
-
-	httpRequestsInProgress = promauto.NewGauge(prometheus.GaugeOpts{
-		Name: "http_requests_in_progress",
-		Help: "Current number of HTTP requests in progress",
-	})
-)
-
-// PrometheusMiddleware tracks request metrics
-func PrometheusMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		start := time.Now()
-		path := c.FullPath()
-		if path == "" {
-			path = "unknown"
-		}
-
-		// Increment in-progress requests
-		httpRequestsInProgress.Inc()
-
-		// Process request
-		c.Next()
-
-		// Record metrics after request is processed
-		duration := time.Since(start).Seconds()
-		statusCode := c.Writer.Status()
-
-		httpRequestDuration.WithLabelValues(c.Request.Method, path).Observe(duration)
-		httpRequestsTotal.WithLabelValues(c.Request.Method, path, string(statusCode)).Inc()
-
-		// Decrement in-progress requests
-		httpRequestsInProgress.Dec()
-	}
-}
-
 func main() {
 	// Set Gin mode
 	if os.Getenv("GIN_MODE") == "release" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
+	setupLogger()
+
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		slog.Error("Tracing setup failed", "error", err)
+		os.Exit(1)
+	}
+
 	// Connect to Postgres (if configured via env)
 	if err := connectPostgres(); err != nil {
-		log.Printf("Postgres not connected: %v (set PG* env vars to enable DB)", err)
+		slog.Warn("Postgres not connected, serving mock data", "error", err, "hint", "set DATABASE_URL or DB_* env vars to enable DB")
 	} else {
 		if err := migratePostgres(); err != nil {
-			log.Printf("Postgres migration failed: %v", err)
+			slog.Error("Postgres migration failed", "error", err)
 		} else if err := seedPostgresIfEmpty(); err != nil {
-			log.Printf("Postgres seed failed: %v", err)
+			slog.Error("Postgres seed failed", "error", err)
 		} else {
-			log.Printf("Postgres connected and ready")
+			slog.Info("Postgres connected and ready")
 		}
 	}
+	registerDBMetrics()
+
+	if err := setupPayments(); err != nil {
+		slog.Error("Payment provider setup failed", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Payment provider ready", "provider", paymentProvider.Name())
+
+	if err := setupImageStorage(); err != nil {
+		slog.Error("Image storage setup failed", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Image storage ready", "storage", imageStorage.Name())
+
+	if err := setupStockNotifier(); err != nil {
+		slog.Error("Low stock notifier setup failed", "error", err)
+		os.Exit(1)
+	}
+	slog.Info("Low stock notifier ready", "notifier", stockNotifier.Name())
 
 	// Create router
-	r := gin.Default()
+	r := gin.New()
+
+	// Tracing and request IDs first so every later log line can carry them
+	r.Use(TracingMiddleware())
+	r.Use(RequestIDMiddleware())
+	r.Use(RequestLoggerMiddleware())
+	r.Use(RecoveryMiddleware())
 
 	// Add Prometheus middleware
 	r.Use(PrometheusMiddleware())
@@ -86,7 +76,8 @@ func main() {
 	r.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Requested-With")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Requested-With, X-Request-ID, Idempotency-Key, X-Confirmation-Token, traceparent, tracestate")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID, Idempotent-Replayed")
 		c.Header("Access-Control-Max-Age", "86400")
 		
 		if c.Request.Method == "OPTIONS" {
@@ -97,20 +88,19 @@ func main() {
 		c.Next()
 	})
 
-	// Health check endpoint
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status":    "OK",
-			"message":   "Vue Shop Backend is running",
-			"language":  "Go",
-			"framework": "Gin",
-			"version":   "1.0.0",
-		})
-	})
+	r.NoRoute(notFound)
+
+	// Health check endpoints
+	r.GET("/health", livez)
+	r.GET("/livez", livez)
+	r.GET("/readyz", readyz)
 
 	// Add Prometheus metrics endpoint
 	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
+	// Uploaded images
+	r.GET("/images/*key", serveImage)
+
 	// API routes
 	api := r.Group("/api")
 	{
@@ -127,12 +117,22 @@ func main() {
 		api.GET("/products/:id", getProduct)
 		api.GET("/products/categories", getCategories)
 
+		// Cart routes
+		api.POST("/cart/validate-coupon", validateCouponPreview)
+
+		// Shipping routes
+		api.GET("/shipping/quote", getShippingQuote)
+
 		// Order routes
-		api.POST("/orders", createOrder)
+		api.POST("/orders", IdempotencyMiddleware(), createOrder)
 		api.GET("/orders", getAllOrders)
 		api.GET("/orders/:orderNumber", getOrderByNumber)
 		api.PUT("/orders/:orderId/status", updateOrderStatus)
 		api.DELETE("/orders/:orderId", deleteOrder)
+		api.POST("/orders/:orderId/payment", startPayment)
+
+		// Payment routes
+		api.POST("/payments/webhook", paymentWebhook)
 
 		// Admin routes
 		admin := api.Group("/admin")
@@ -141,12 +141,50 @@ func main() {
 			admin.GET("/stats", getStats)
 			admin.GET("/users", getAllUsers)
 			admin.POST("/seed", seedDatabase)
-			admin.POST("/clear", clearDatabase)
+			admin.POST("/clear", DestructiveMiddleware(), clearDatabase)
 			admin.GET("/export", exportData)
+			admin.POST("/import", DestructiveMiddleware(), importData)
+			admin.GET("/audit", getAuditLog)
 			admin.POST("/products", createProduct)
+			admin.POST("/products/import", importProducts)
+			admin.GET("/products/export", exportProducts)
 			admin.PUT("/products/:id", updateProduct)
 			admin.DELETE("/products/:id", deleteProduct)
+			admin.POST("/products/:id/restore", restoreProduct)
+			admin.POST("/products/:id/images", uploadProductImages)
+			admin.PUT("/products/:id/images/order", reorderProductImages)
+			admin.DELETE("/products/:id/images/:imageId", deleteProductImage)
+			admin.GET("/products/:id/variants", getProductVariants)
+			admin.POST("/products/:id/variants", createProductVariant)
+			admin.PUT("/products/:id/variants/:variantId", updateProductVariant)
+			admin.DELETE("/products/:id/variants/:variantId", deleteProductVariant)
+			admin.POST("/products/:id/stock-adjustments", createStockAdjustment)
+			admin.GET("/products/:id/inventory-movements", getInventoryMovements)
+			admin.GET("/inventory/low-stock", getLowStockProducts)
 			admin.DELETE("/users/:id", deleteUser)
+			admin.POST("/users/:id/restore", restoreUser)
+			admin.POST("/orders/:orderId/restore", restoreOrder)
+			admin.GET("/orders/:orderId/refunds", getRefunds)
+			admin.POST("/orders/:orderId/refunds", createRefund)
+			admin.GET("/exchange-rates", getExchangeRates)
+			admin.PUT("/exchange-rates", updateExchangeRates)
+			admin.POST("/exchange-rates/import", importExchangeRates)
+			admin.GET("/tax-rules", getTaxRules)
+			admin.POST("/tax-rules", createTaxRule)
+			admin.PUT("/tax-rules/:id", updateTaxRule)
+			admin.DELETE("/tax-rules/:id", deleteTaxRule)
+			admin.GET("/categories", getAdminCategories)
+			admin.POST("/categories", createCategory)
+			admin.PUT("/categories/:id", updateCategory)
+			admin.DELETE("/categories/:id", deleteCategory)
+			admin.GET("/shipping-methods", getShippingMethods)
+			admin.POST("/shipping-methods", createShippingMethod)
+			admin.PUT("/shipping-methods/:id", updateShippingMethod)
+			admin.DELETE("/shipping-methods/:id", deleteShippingMethod)
+			admin.GET("/coupons", getCoupons)
+			admin.POST("/coupons", createCoupon)
+			admin.PUT("/coupons/:id", updateCoupon)
+			admin.DELETE("/coupons/:id", deleteCoupon)
 		}
 	}
 
@@ -157,13 +195,40 @@ func main() {
 	}
 
 	// Start server
-	log.Printf("🚀 Vue Shop Backend (Go) running on port %s", port)
-	log.Printf("📊 Health check: http://localhost:%s/health", port)
-	log.Printf("📈 Metrics endpoint: http://localhost:%s/metrics", port)
-	log.Printf("🛍️ API Base URL: http://localhost:%s/api", port)
-	log.Printf("🌐 CORS enabled for: http://localhost:3000, 3001, 3002")
-
-	if err := r.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	slog.Info("Vue Shop Backend (Go) starting",
+		"port", port,
+		"version", version,
+		"commit", commit,
+		"storage", storageMode(),
+		"health", "/livez",
+		"readiness", "/readyz",
+		"metrics", "/metrics",
+		"api", "/api",
+	)
+
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Failed to start server", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	// Wait for a termination signal, then drain requests and flush spans
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	startIdempotencyPurger(ctx, time.Hour)
+	startRetentionPurger(ctx, time.Hour)
+	startLowStockChecker(ctx, getenvDuration("LOW_STOCK_CHECK_INTERVAL", 5*time.Minute))
+	<-ctx.Done()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Server shutdown failed", "error", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Tracing shutdown failed", "error", err)
 	}
+	slog.Info("Server stopped")
 }
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Checkout failure reasons used as the "reason" label
+const (
+	checkoutValidation        = "validation"
+	checkoutProductNotFound   = "product_not_found"
+	checkoutInsufficientStock = "insufficient_stock"
+	checkoutDBError           = "db_error"
+	checkoutInvalidCoupon     = "invalid_coupon"
+)
+
+// HTTP metrics
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests",
+	}, []string{"method", "path", "status_code"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Duration of HTTP requests in seconds",
+		Buckets: []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+	}, []string{"method", "path"})
+
+	httpRequestsInProgress = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "http_requests_in_progress",
+		Help: "Current number of HTTP requests in progress",
+	})
+)
+
+// Business metrics
+var (
+	ordersCreatedTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "shop_orders_created_total",
+		Help: "Total number of orders created",
+	})
+
+	orderRevenueTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shop_order_revenue_total",
+		Help: "Total revenue of created orders by currency",
+	}, []string{"currency"})
+
+	itemsSoldTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shop_items_sold_total",
+		Help: "Total number of items sold per product category",
+	}, []string{"category"})
+
+	checkoutFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shop_checkout_failures_total",
+		Help: "Total number of failed checkouts by reason",
+	}, []string{"reason"})
+
+	refundsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "shop_refunds_total",
+		Help: "Total number of refunds issued",
+	})
+
+	refundedAmountTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "shop_refunded_amount_total",
+		Help: "Total amount refunded by currency",
+	}, []string{"currency"})
+)
+
+// PrometheusMiddleware tracks request metrics
+func PrometheusMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.FullPath()
+		if path == "" {
+			path = "unknown"
+		}
+
+		// Increment in-progress requests
+		httpRequestsInProgress.Inc()
+
+		// Process request
+		c.Next()
+
+		// Record metrics after request is processed
+		duration := time.Since(start).Seconds()
+		statusCode := strconv.Itoa(c.Writer.Status())
+
+		httpRequestDuration.WithLabelValues(c.Request.Method, path).Observe(duration)
+		httpRequestsTotal.WithLabelValues(c.Request.Method, path, statusCode).Inc()
+
+		// Decrement in-progress requests
+		httpRequestsInProgress.Dec()
+	}
+}
+
+// registerDBMetrics exports database/sql pool statistics. It must be called
+// once the connection has been established.
+func registerDBMetrics() {
+	if db == nil {
+		return
+	}
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "vueshop"))
+}
+
+// recordOrderCreated updates the business metrics for a successful checkout.
+// categories maps product IDs to their category.
+func recordOrderCreated(order Order, categories map[int]string) {
+	ordersCreatedTotal.Inc()
+	orderRevenueTotal.WithLabelValues(order.Currency).Add(order.Total.Float64())
+	for _, item := range order.Items {
+		category, ok := categories[item.ID]
+		if !ok {
+			category = "unknown"
+		}
+		itemsSoldTotal.WithLabelValues(category).Add(float64(item.Quantity))
+	}
+}
+
+// recordRefund updates the business metrics for an issued refund
+func recordRefund(refund Refund) {
+	refundsTotal.Inc()
+	refundedAmountTotal.WithLabelValues(refund.Currency).Add(refund.Amount.Float64())
+}
+
+// recordCheckoutFailure counts a rejected or failed checkout
+func recordCheckoutFailure(reason string) {
+	checkoutFailuresTotal.WithLabelValues(reason).Inc()
+}
+
+// lowStockThreshold is the stock level at or below which a product without
+// its own reorder threshold counts as low stock
+var lowStockThreshold = getenvInt("LOW_STOCK_THRESHOLD", 5)
+
+// lowStockCollector reports the number of low-stock products at scrape time
+type lowStockCollector struct {
+	desc *prometheus.Desc
+}
+
+func newLowStockCollector() *lowStockCollector {
+	return &lowStockCollector{
+		desc: prometheus.NewDesc("shop_low_stock_products", "Number of products with stock at or below their reorder threshold", nil, nil),
+	}
+}
+
+func (c *lowStockCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *lowStockCollector) Collect(ch chan<- prometheus.Metric) {
+	count := 0
+	if db != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM products WHERE stock <= COALESCE(reorder_threshold, $1) AND deleted_at IS NULL`, lowStockThreshold).Scan(&count); err != nil {
+			slog.Error("Low stock metric query failed", "error", err)
+			return
+		}
+	} else {
+		for _, p := range mockProducts {
+			if p.Stock <= productThreshold(p) {
+				count++
+			}
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count))
+}
+
+func init() {
+	prometheus.MustRegister(newLowStockCollector())
+}
@@ -1,33 +1,69 @@
 package main
 
 import (
+	"encoding/json"
+	"sync"
 	"time"
 )
 
 // Product represents a product in the shop
 type Product struct {
-	ID          int     `json:"id"`
-	Name        string  `json:"name"`
-	Description string  `json:"description"`
-	Price       float64 `json:"price"`
-	Category    string  `json:"category"`
-	Image       string  `json:"image"`
-	Stock       int     `json:"stock"`
+	ID int `json:"id"`
+	// SKU optionally identifies the product in bulk imports; see importProducts
+	SKU         string `json:"sku,omitempty" binding:"max=64"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Price       Money  `json:"price"`
+	Currency    string `json:"currency" binding:"omitempty,len=3,alpha"`
+	Category    string `json:"category"`
+	Image       string `json:"image"`
+	Stock       int    `json:"stock"`
+	// ReorderThreshold is the stock at or below which the product counts as
+	// low; nil falls back to LOW_STOCK_THRESHOLD
+	ReorderThreshold *int `json:"reorderThreshold,omitempty" binding:"omitempty,min=0"`
+	// Weight is the shipping weight in grams
+	Weight int `json:"weight" binding:"min=0"`
+	// Variants, when present, are what can be ordered; see ProductVariant
+	Variants []ProductVariant `json:"variants,omitempty"`
+	// Images are the uploaded images in display order; Image is the first
+	Images []ProductImage `json:"images,omitempty"`
+	// ImageRenditions maps rendition names to the URLs of Image's renditions
+	ImageRenditions map[string]string `json:"imageRenditions,omitempty"`
+	DeletedAt       *time.Time        `json:"deletedAt,omitempty"`
 }
 
 // Customer represents customer information
 type Customer struct {
-	Name    string `json:"name" binding:"required"`
-	Email   string `json:"email" binding:"required,email"`
-	Address string `json:"address" binding:"required"`
+	Name  string `json:"name" binding:"required"`
+	Email string `json:"email" binding:"required,email"`
+	// Address is free text; ShippingAddress replaces it when given
+	Address         string   `json:"address" binding:"required_without=ShippingAddress"`
+	ShippingAddress *Address `json:"shippingAddress,omitempty"`
+	// Country (ISO 3166-1 alpha-2) and Region select the tax rules
+	Country string `json:"country,omitempty" binding:"omitempty,len=2,alpha"`
+	Region  string `json:"region,omitempty"`
 }
 
 // OrderItem represents an item in an order
 type OrderItem struct {
-	ID       int     `json:"id" binding:"required"`
-	Name     string  `json:"name"`
-	Price    float64 `json:"price"`
-	Quantity int     `json:"quantity" binding:"required,min=1"`
+	ID       int    `json:"id" binding:"required"`
+	Name     string `json:"name"`
+	Price    Money  `json:"price"`
+	Quantity int    `json:"quantity" binding:"required,min=1"`
+	// VariantID picks the variant of a product that has them; SKU and
+	// Options are copied from it at checkout
+	VariantID int               `json:"variantId,omitempty"`
+	SKU       string            `json:"sku,omitempty"`
+	Options   map[string]string `json:"options,omitempty"`
+	// Discount is this line's share of the order discount
+	Discount Money `json:"discount"`
+	// Tax breakdown computed at checkout; any client-sent values are replaced
+	TaxName      string      `json:"taxName,omitempty"`
+	TaxRate      json.Number `json:"taxRate,omitempty"`
+	TaxAmount    Money       `json:"taxAmount"`
+	TaxInclusive bool        `json:"taxInclusive"`
+	// RefundedQuantity counts the units refunded so far
+	RefundedQuantity int `json:"refundedQuantity"`
 }
 
 // Order represents a complete order
@@ -36,18 +72,33 @@ type Order struct {
 	OrderNumber string      `json:"orderNumber"`
 	Customer    Customer    `json:"customer" binding:"required"`
 	Items       []OrderItem `json:"items" binding:"required,min=1"`
-	Subtotal    float64     `json:"subtotal" binding:"required,min=0"`
-	Shipping    float64     `json:"shipping" binding:"required,min=0"`
-	Tax         float64     `json:"tax" binding:"required,min=0"`
-	Total       float64     `json:"total" binding:"required,min=0"`
-	Status      string      `json:"status"`
-	CreatedAt   time.Time   `json:"createdAt"`
-	UpdatedAt   time.Time   `json:"updatedAt"`
+	Subtotal    Money       `json:"subtotal" binding:"min=0"`
+	// CouponCode is applied at checkout; Discounts lists what it took off
+	CouponCode string         `json:"couponCode,omitempty"`
+	Discount   Money          `json:"discount"`
+	Discounts  []DiscountLine `json:"discounts,omitempty"`
+	Shipping   Money          `json:"shipping" binding:"min=0"`
+	// ShippingMethod is the chosen method code; empty picks the cheapest
+	ShippingMethod string `json:"shippingMethod,omitempty"`
+	Tax            Money  `json:"tax" binding:"min=0"`
+	Total          Money  `json:"total" binding:"min=0"`
+	Currency       string `json:"currency" binding:"omitempty,len=3,alpha"`
+	// BaseCurrency and ExchangeRate snapshot the conversion used at checkout
+	BaseCurrency string      `json:"baseCurrency,omitempty"`
+	ExchangeRate json.Number `json:"exchangeRate,omitempty"`
+	// Refunded is the part of Total returned to the customer
+	Refunded  Money     `json:"refunded"`
+	Refunds   []Refund  `json:"refunds,omitempty"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	// DeletedAt is set while the order is soft-deleted
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
 // OrderStatus represents order status update request
 type OrderStatus struct {
-	Status string `json:"status" binding:"required,oneof=pending processing shipped delivered cancelled"`
+	Status string `json:"status" binding:"required,oneof=pending paid processing shipped delivered cancelled refunded partially_refunded"`
 }
 
 // API Response structures
@@ -63,18 +114,19 @@ type ProductResponse struct {
 }
 
 type CategoriesResponse struct {
-	Success bool     `json:"success"`
-	Data    []string `json:"data"`
+	Success bool       `json:"success"`
+	Data    []Category `json:"data"`
 }
 
 type OrderResponse struct {
-	Success bool  `json:"success"`
+	Success bool   `json:"success"`
 	Message string `json:"message"`
 	Data    struct {
-		OrderID      string  `json:"orderId"`
-		OrderNumber  string  `json:"orderNumber"`
-		Total        float64 `json:"total"`
-		Status       string  `json:"status"`
+		OrderID     string `json:"orderId"`
+		OrderNumber string `json:"orderNumber"`
+		Total       Money  `json:"total"`
+		Currency    string `json:"currency"`
+		Status      string `json:"status"`
 	} `json:"data"`
 }
 
@@ -96,6 +148,8 @@ type User struct {
 	Email    string `json:"email"`
 	Name     string `json:"name"`
 	Role     string `json:"role"`
+	// DeletedAt is set once the user is deleted; they can no longer log in
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
 }
 
 // LoginRequest represents login credentials
@@ -120,94 +174,129 @@ type UserResponse struct {
 	Data    User `json:"data"`
 }
 
+// ErrorResponse is the body of every error. Code is stable and meant for
+// clients; Error is the human-readable title for that code.
 type ErrorResponse struct {
-	Success bool   `json:"success"`
-	Error   string `json:"error"`
-	Message string `json:"message,omitempty"`
+	Success   bool         `json:"success"`
+	Code      ErrorCode    `json:"code"`
+	Error     string       `json:"error"`
+	Message   string       `json:"message,omitempty"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"requestId,omitempty"`
 }
 
-// Mock data
+// Mock data (prices in cents)
 var mockProducts = []Product{
 	{
 		ID:          1,
 		Name:        "Vue.js T-Shirt",
 		Description: "Comfortable cotton t-shirt with Vue.js logo",
-		Price:       25.99,
+		Price:       2599,
+		Currency:    baseCurrency,
 		Category:    "clothing",
 		Image:       "https://images.unsplash.com/photo-1521572163474-6864f9cf17ab?w=400&h=300&fit=crop&bg=white",
 		Stock:       50,
+		Weight:      200,
+		Variants: []ProductVariant{
+			{ID: 1, ProductID: 1, SKU: "VUE-TEE-S", Options: map[string]string{"size": "S"}, Stock: 10},
+			{ID: 2, ProductID: 1, SKU: "VUE-TEE-M", Options: map[string]string{"size": "M"}, Stock: 15},
+			{ID: 3, ProductID: 1, SKU: "VUE-TEE-L", Options: map[string]string{"size": "L"}, Stock: 15},
+			{ID: 4, ProductID: 1, SKU: "VUE-TEE-XL", Options: map[string]string{"size": "XL"}, Stock: 10},
+		},
 	},
 	{
 		ID:          2,
 		Name:        "JavaScript Book",
 		Description: "Comprehensive guide to modern JavaScript",
-		Price:       39.99,
+		Price:       3999,
+		Currency:    baseCurrency,
 		Category:    "books",
 		Image:       "https://images.unsplash.com/photo-1544947950-fa07a98d237f?w=400&h=300&fit=crop&bg=white",
 		Stock:       25,
+		Weight:      800,
 	},
 	{
 		ID:          3,
 		Name:        "Wireless Headphones",
 		Description: "High-quality wireless headphones with noise cancellation",
-		Price:       89.99,
+		Price:       8999,
+		Currency:    baseCurrency,
 		Category:    "electronics",
 		Image:       "https://images.unsplash.com/photo-1505740420928-5e560c06d30e?w=400&h=300&fit=crop&bg=white",
 		Stock:       15,
+		Weight:      350,
 	},
 	{
 		ID:          4,
 		Name:        "Vue Hoodie",
 		Description: "Warm and cozy hoodie perfect for Vue developers",
-		Price:       45.99,
+		Price:       4599,
+		Currency:    baseCurrency,
 		Category:    "clothing",
 		Image:       "https://images.unsplash.com/photo-1556821840-3a63f95609a7?w=400&h=300&fit=crop&bg=white",
 		Stock:       30,
+		Weight:      600,
+		Variants: []ProductVariant{
+			{ID: 5, ProductID: 4, SKU: "VUE-HOODIE-S", Options: map[string]string{"size": "S"}, Stock: 5},
+			{ID: 6, ProductID: 4, SKU: "VUE-HOODIE-M", Options: map[string]string{"size": "M"}, Stock: 10},
+			{ID: 7, ProductID: 4, SKU: "VUE-HOODIE-L", Options: map[string]string{"size": "L"}, Stock: 10},
+			{ID: 8, ProductID: 4, SKU: "VUE-HOODIE-XL", Options: map[string]string{"size": "XL"}, Stock: 5},
+		},
 	},
 	{
 		ID:          5,
 		Name:        "Laptop Stand",
 		Description: "Ergonomic laptop stand for better posture",
-		Price:       29.99,
-		Category:    "electronics",
+		Price:       2999,
+		Currency:    baseCurrency,
+		Category:    "computer-accessories",
 		Image:       "https://images.unsplash.com/photo-1586953208448-b95a79798f07?w=400&h=300&fit=crop&bg=white",
 		Stock:       20,
+		Weight:      1500,
 	},
 	{
 		ID:          6,
 		Name:        "Vue.js Guide",
 		Description: "Complete Vue.js 3 tutorial and reference",
-		Price:       19.99,
+		Price:       1999,
+		Currency:    baseCurrency,
 		Category:    "books",
 		Image:       "https://images.unsplash.com/photo-1481627834876-b7833e8f5570?w=400&h=300&fit=crop&bg=white",
 		Stock:       40,
+		Weight:      500,
 	},
 	{
 		ID:          7,
 		Name:        "Mechanical Keyboard",
 		Description: "Premium mechanical keyboard with RGB backlighting",
-		Price:       129.99,
-		Category:    "electronics",
+		Price:       12999,
+		Currency:    baseCurrency,
+		Category:    "computer-accessories",
 		Image:       "https://images.unsplash.com/photo-1541140532154-b024d705b90a?w=400&h=300&fit=crop&bg=white",
 		Stock:       10,
+		Weight:      1100,
 	},
 	{
 		ID:          8,
 		Name:        "Developer Mug",
 		Description: "Ceramic coffee mug perfect for coding sessions",
-		Price:       12.99,
-		Category:    "clothing",
+		Price:       1299,
+		Currency:    baseCurrency,
+		Category:    "home",
 		Image:       "https://images.unsplash.com/photo-1578662996442-48f60103fc96?w=400&h=300&fit=crop&bg=white",
 		Stock:       100,
+		Weight:      400,
 	},
 	{
 		ID:          9,
 		Name:        "React vs Vue Book",
 		Description: "In-depth comparison of modern JavaScript frameworks",
-		Price:       34.99,
+		Price:       3499,
+		Currency:    baseCurrency,
 		Category:    "books",
 		Image:       "https://images.unsplash.com/photo-1589829085413-56de8ae18c73?w=400&h=300&fit=crop&bg=white",
 		Stock:       35,
+		Weight:      300,
 	},
 }
 
@@ -215,6 +304,9 @@ var mockProducts = []Product{
 var orders = make(map[string]Order)
 var orderCounter = 1000
 
+// ordersMu guards orders and orderCounter
+var ordersMu sync.Mutex
+
 // Mock user data
 var mockUsers = []User{
 	{
@@ -249,3 +341,6 @@ var userCredentials = map[string]string{
 
 // In-memory storage for active sessions
 var activeSessions = make(map[string]User)
+
+// sessionsMu guards activeSessions
+var sessionsMu sync.RWMutex
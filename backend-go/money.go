@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// baseCurrency is the ISO 4217 code prices are stored in
+var baseCurrency = strings.ToUpper(getenv("BASE_CURRENCY", "USD"))
+
+// Money is an exact amount in minor units (cents). It is encoded in JSON as
+// a decimal number with two places and stored in NUMERIC(10,2) columns, so
+// sums never accumulate floating point error.
+//
+// Rounding: any value with more than two decimal places, whether parsed from
+// JSON or produced by MulRate, is rounded half away from zero to the cent
+// (e.g. 4.1585 -> 4.16, -0.005 -> -0.01).
+type Money int64
+
+// normalizeCurrency upper-cases a currency code, defaulting to baseCurrency
+func normalizeCurrency(code string) string {
+	if code == "" {
+		return baseCurrency
+	}
+	return strings.ToUpper(code)
+}
+
+// decimalPattern matches a plain decimal literal. big.Rat alone would also
+// take fractions like "1/3" and exponents like "1e999999".
+var decimalPattern = regexp.MustCompile(`^[+-]?([0-9]{1,18}(\.[0-9]*)?|\.[0-9]+)$`)
+
+// parseMoney parses a decimal string such as "25.99", "-3" or ".5"
+func parseMoney(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	if !decimalPattern.MatchString(s) {
+		return 0, fmt.Errorf("invalid money amount %q", s)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid money amount %q", s)
+	}
+	return moneyFromRat(r.Mul(r, big.NewRat(100, 1)))
+}
+
+// decimalDigits matches a plain decimal literal, capturing the digits before
+// and after the decimal point
+var decimalDigits = regexp.MustCompile(`^[+-]?([0-9]*)(?:\.([0-9]*))?$`)
+
+// parseDecimal parses a plain decimal literal that fits a NUMERIC(precision,
+// scale) column, so it is stored exactly: at most precision-scale digits
+// before the decimal point and scale after it, leading and trailing zeros
+// aside
+func parseDecimal(s string, precision, scale int) (*big.Rat, error) {
+	s = strings.TrimSpace(s)
+	m := decimalDigits.FindStringSubmatch(s)
+	if m == nil || m[1]+m[2] == "" {
+		return nil, fmt.Errorf("%q is not a decimal number", s)
+	}
+	if len(strings.TrimLeft(m[1], "0")) > precision-scale || len(strings.TrimRight(m[2], "0")) > scale {
+		return nil, fmt.Errorf("%q needs more than %d digits before or %d after the decimal point", s, precision-scale, scale)
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a decimal number", s)
+	}
+	return r, nil
+}
+
+// moneyFromRat rounds a value in minor units half away from zero
+func moneyFromRat(cents *big.Rat) (Money, error) {
+	num := new(big.Int).Set(cents.Num())
+	den := cents.Denom()
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	// |rem|*2 >= den means the fraction is at least one half
+	if rem.Sign() != 0 && new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	if !quo.IsInt64() {
+		return 0, fmt.Errorf("money amount out of range")
+	}
+	return Money(quo.Int64()), nil
+}
+
+// Mul returns the amount multiplied by a quantity
+func (m Money) Mul(qty int) Money {
+	return m * Money(qty)
+}
+
+// MulRate returns m multiplied by the decimal rate (e.g. "0.08"), rounded
+// half away from zero to the cent.
+func (m Money) MulRate(rate *big.Rat) Money {
+	r := new(big.Rat).Mul(big.NewRat(int64(m), 1), rate)
+	v, err := moneyFromRat(r)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// Float64 converts to a float for metrics only; never use it for arithmetic
+func (m Money) Float64() float64 {
+	return float64(m) / 100
+}
+
+// String formats the amount as a decimal with two places
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, v/100, v%100)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a numeric string
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	} else {
+		var n json.Number
+		if err := json.Unmarshal(data, &n); err != nil {
+			return fmt.Errorf("invalid money amount %s", s)
+		}
+		s = n.String()
+	}
+	v, err := parseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Scan reads NUMERIC columns, which the driver returns as text
+func (m *Money) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	case int64:
+		*m = Money(v * 100)
+		return nil
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case nil:
+		*m = 0
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	v, err := parseMoney(s)
+	if err != nil {
+		return err
+	}
+	*m = v
+	return nil
+}
+
+// Value writes the amount as an exact decimal string
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Payment statuses
+const (
+	paymentPending    = "pending"
+	paymentAuthorized = "authorized"
+	paymentSucceeded  = "succeeded"
+	paymentFailed     = "failed"
+)
+
+// Normalized webhook event types
+const (
+	eventPaymentAuthorized = "payment.authorized"
+	eventPaymentSucceeded  = "payment.succeeded"
+	eventPaymentFailed     = "payment.failed"
+)
+
+const (
+	maxWebhookSize   = 1 << 20
+	webhookTolerance = 5 * time.Minute
+)
+
+var (
+	errInvalidSignature = errors.New("invalid webhook signature")
+	errPaymentNotFound  = errors.New("payment not found")
+	errPaymentMismatch  = errors.New("event amount does not match payment")
+)
+
+// PaymentProvider is a payment gateway. Amounts are in minor units of the
+// order currency.
+type PaymentProvider interface {
+	// Name identifies the provider in the payments table
+	Name() string
+	// Authorize starts a payment that holds funds without capturing them
+	Authorize(ctx context.Context, req PaymentRequest) (*PaymentResult, error)
+	// Capture collects a previously authorized payment
+	Capture(ctx context.Context, ref string, amount Money, currency string) (*PaymentResult, error)
+	// Refund returns amount of a captured payment, returning the provider's
+	// refund ID. refundID is the shop's ID for the refund and makes retries
+	// of the same refund idempotent.
+	Refund(ctx context.Context, ref string, amount Money, currency, refundID string) (string, error)
+	// VerifyWebhook checks the signature of a webhook delivery and parses it.
+	// Events the shop doesn't act on come back with an empty Type.
+	VerifyWebhook(payload []byte, header http.Header) (*PaymentEvent, error)
+}
+
+// PaymentRequest describes the payment for an order
+type PaymentRequest struct {
+	// PaymentID makes retries of the same authorization idempotent
+	PaymentID string
+	OrderID   string
+	Amount    Money
+	Currency  string
+	Email     string
+}
+
+// PaymentResult is the provider's view of a payment
+type PaymentResult struct {
+	Ref          string
+	Status       string
+	ClientSecret string
+}
+
+// PaymentEvent is a verified webhook event
+type PaymentEvent struct {
+	ID       string
+	Type     string
+	Ref      string
+	Amount   Money
+	Currency string
+}
+
+// Payment is an attempt to pay for an order
+type Payment struct {
+	ID           string    `json:"id"`
+	OrderID      string    `json:"orderId"`
+	Provider     string    `json:"provider"`
+	ProviderRef  string    `json:"providerRef"`
+	Amount       Money     `json:"amount"`
+	Currency     string    `json:"currency"`
+	Status       string    `json:"status"`
+	ClientSecret string    `json:"clientSecret,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// paymentProvider is the gateway selected by PAYMENT_PROVIDER
+var paymentProvider PaymentProvider
+
+// setupPayments selects the payment provider from the environment
+func setupPayments() error {
+	switch name := getenv("PAYMENT_PROVIDER", "fake"); name {
+	case "fake":
+		if gin.Mode() == gin.ReleaseMode {
+			slog.Warn("Fake payment provider enabled in release mode; orders can be marked paid without a real payment")
+		}
+		paymentProvider = newFakeProvider(getenv("PAYMENT_WEBHOOK_SECRET", "whsec_fake"))
+	case "stripe":
+		p, err := newStripeProvider(os.Getenv("STRIPE_SECRET_KEY"), os.Getenv("STRIPE_WEBHOOK_SECRET"), getenv("STRIPE_API_BASE", "https://api.stripe.com"))
+		if err != nil {
+			return err
+		}
+		paymentProvider = p
+	default:
+		return fmt.Errorf("unknown PAYMENT_PROVIDER %q", name)
+	}
+	return nil
+}
+
+// signWebhook computes a Stripe-style signature header, "t=<unix>,v1=<hex>"
+// where the HMAC-SHA256 covers "<unix>.<payload>"
+func signWebhook(payload []byte, secret string, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + string(payload)))
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyWebhookSignature checks a Stripe-style signature header, rejecting
+// deliveries older than webhookTolerance to block replays
+func verifyWebhookSignature(payload []byte, header, secret string) error {
+	var ts string
+	var sigs []string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sigs = append(sigs, v)
+		}
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || len(sigs) == 0 {
+		return errInvalidSignature
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > webhookTolerance || age < -webhookTolerance {
+		return errInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + string(payload)))
+	expected := mac.Sum(nil)
+	for _, sig := range sigs {
+		if got, err := hex.DecodeString(sig); err == nil && hmac.Equal(got, expected) {
+			return nil
+		}
+	}
+	return errInvalidSignature
+}
+
+// paymentStore persists payments and the orders they pay for
+type paymentStore interface {
+	// LockOrder keeps other payments for the order from starting until
+	// unlock is called
+	LockOrder(ctx context.Context, orderID string) (unlock func(), err error)
+	// Order returns the order with the given ID, or nil if it doesn't exist
+	Order(ctx context.Context, orderID string) (*Order, error)
+	// ForOrder returns the latest payment for an order, or nil
+	ForOrder(ctx context.Context, orderID string) (*Payment, error)
+	// ByRef returns the payment with the provider reference
+	ByRef(ctx context.Context, provider, ref string) (*Payment, error)
+	Create(ctx context.Context, p *Payment) error
+	// SetStatus updates a payment and, when orderStatus is not empty, moves
+	// its order from pending to orderStatus
+	SetStatus(ctx context.Context, p *Payment, status, orderStatus string) error
+	// EventSeen reports whether a webhook event was already processed
+	EventSeen(ctx context.Context, provider, eventID string) (bool, error)
+	// RecordEvent marks a webhook event as processed
+	RecordEvent(ctx context.Context, provider, eventID string) error
+}
+
+// currentPaymentStore picks the store matching the storage mode
+func currentPaymentStore() paymentStore {
+	if db != nil {
+		return pgPaymentStore{}
+	}
+	return memoryPayments
+}
+
+// startPayment handles POST /api/orders/:orderId/payment. It authorizes the
+// order total with the provider, or returns the payment already in progress.
+// The order stays locked until the payment is saved, so concurrent requests
+// can't both start one.
+func startPayment(c *gin.Context) {
+	ctx := c.Request.Context()
+	store := currentPaymentStore()
+	orderID := c.Param("orderId")
+
+	unlock, err := store.LockOrder(ctx, orderID)
+	if err != nil {
+		respondInternalError(c, "Failed to lock order", err)
+		return
+	}
+	defer unlock()
+
+	order, err := store.Order(ctx, orderID)
+	if err != nil {
+		respondInternalError(c, "Failed to load order", err)
+		return
+	}
+	if order == nil {
+		respondError(c, ErrOrderNotFound, "")
+		return
+	}
+	if order.Status != "pending" {
+		respondError(c, ErrOrderNotPayable, fmt.Sprintf("Order is %s", order.Status))
+		return
+	}
+
+	existing, err := store.ForOrder(ctx, orderID)
+	if err != nil {
+		respondInternalError(c, "Failed to load payment", err)
+		return
+	}
+	if existing != nil && existing.Status != paymentFailed {
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": existing})
+		return
+	}
+
+	paymentID := uuid.New().String()
+	result, err := paymentProvider.Authorize(ctx, PaymentRequest{
+		PaymentID: paymentID,
+		OrderID:   order.ID,
+		Amount:    order.Total,
+		Currency:  order.Currency,
+		Email:     order.Customer.Email,
+	})
+	if err != nil {
+		loggerFrom(c).Error("Payment authorization failed", "error", err, "order_id", orderID, "provider", paymentProvider.Name())
+		respondError(c, ErrPaymentFailed, "The payment provider rejected the payment")
+		return
+	}
+
+	now := time.Now()
+	payment := &Payment{
+		ID:           paymentID,
+		OrderID:      order.ID,
+		Provider:     paymentProvider.Name(),
+		ProviderRef:  result.Ref,
+		Amount:       order.Total,
+		Currency:     order.Currency,
+		Status:       result.Status,
+		ClientSecret: result.ClientSecret,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	if err := store.Create(ctx, payment); err != nil {
+		respondInternalError(c, "Failed to save payment", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"success": true, "data": payment})
+}
+
+// paymentWebhook handles POST /api/payments/webhook. Only signed deliveries
+// are accepted; an authorized payment is captured and a succeeded payment
+// moves its order from pending to paid.
+func paymentWebhook(c *gin.Context) {
+	ctx := c.Request.Context()
+	payload, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookSize))
+	if err != nil {
+		respondError(c, ErrValidationFailed, "Webhook body is too large")
+		return
+	}
+	event, err := paymentProvider.VerifyWebhook(payload, c.Request.Header)
+	if err != nil {
+		loggerFrom(c).Warn("Rejected payment webhook", "error", err)
+		respondError(c, ErrInvalidSignature, "")
+		return
+	}
+	if event.Type == "" {
+		c.JSON(http.StatusOK, gin.H{"success": true, "received": true})
+		return
+	}
+
+	if err := handlePaymentEvent(ctx, event); err != nil {
+		if errors.Is(err, errPaymentMismatch) {
+			// Retrying won't change the amount; acknowledge and leave the
+			// payment for someone to look into
+			loggerFrom(c).Error("Webhook amount doesn't match payment", "ref", event.Ref, "type", event.Type, "amount", event.Amount, "currency", event.Currency)
+			c.JSON(http.StatusOK, gin.H{"success": true, "received": true})
+			return
+		}
+		if errors.Is(err, errPaymentNotFound) {
+			// Not ours, e.g. a payment made outside the shop; acknowledge so
+			// the provider stops retrying
+			loggerFrom(c).Warn("Webhook for unknown payment", "ref", event.Ref, "type", event.Type)
+			c.JSON(http.StatusOK, gin.H{"success": true, "received": true})
+			return
+		}
+		// A 5xx makes the provider retry the delivery later
+		respondInternalError(c, "Failed to process webhook", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "received": true})
+}
+
+// handlePaymentEvent applies a verified webhook event
+func handlePaymentEvent(ctx context.Context, event *PaymentEvent) error {
+	store := currentPaymentStore()
+	payment, err := store.ByRef(ctx, paymentProvider.Name(), event.Ref)
+	if err != nil {
+		return err
+	}
+	if payment == nil {
+		return errPaymentNotFound
+	}
+	if event.ID != "" {
+		seen, err := store.EventSeen(ctx, paymentProvider.Name(), event.ID)
+		if err != nil || seen {
+			return err
+		}
+	}
+	if err := applyPaymentEvent(ctx, store, payment, event); err != nil {
+		return err
+	}
+	if event.ID != "" {
+		return store.RecordEvent(ctx, paymentProvider.Name(), event.ID)
+	}
+	return nil
+}
+
+// applyPaymentEvent moves a payment and its order forward. Replays of an
+// event are harmless since each transition checks the current status. An
+// authorization or charge for anything but the payment's amount and currency
+// is rejected with errPaymentMismatch.
+func applyPaymentEvent(ctx context.Context, store paymentStore, payment *Payment, event *PaymentEvent) error {
+	switch event.Type {
+	case eventPaymentAuthorized:
+		if payment.Status != paymentPending {
+			return nil
+		}
+		if !event.matches(payment) {
+			return errPaymentMismatch
+		}
+		if err := store.SetStatus(ctx, payment, paymentAuthorized, ""); err != nil {
+			return err
+		}
+		result, err := paymentProvider.Capture(ctx, payment.ProviderRef, payment.Amount, payment.Currency)
+		if err != nil {
+			return fmt.Errorf("capture %s: %w", payment.ProviderRef, err)
+		}
+		if result.Status == paymentSucceeded {
+			return store.SetStatus(ctx, payment, paymentSucceeded, "paid")
+		}
+	case eventPaymentSucceeded:
+		if payment.Status == paymentSucceeded {
+			return nil
+		}
+		if !event.matches(payment) {
+			return errPaymentMismatch
+		}
+		return store.SetStatus(ctx, payment, paymentSucceeded, "paid")
+	case eventPaymentFailed:
+		if payment.Status == paymentSucceeded {
+			return nil
+		}
+		return store.SetStatus(ctx, payment, paymentFailed, "")
+	}
+	return nil
+}
+
+// matches reports whether the event is for the payment's amount and currency
+func (e *PaymentEvent) matches(p *Payment) bool {
+	return e.Amount == p.Amount && strings.EqualFold(e.Currency, p.Currency)
+}
+
+const paymentColumns = `id, order_id, provider, provider_ref, amount, currency, status, client_secret, created_at, updated_at`
+
+func scanPayment(row rowScanner) (*Payment, error) {
+	var p Payment
+	err := row.Scan(&p.ID, &p.OrderID, &p.Provider, &p.ProviderRef, &p.Amount, &p.Currency, &p.Status, &p.ClientSecret, &p.CreatedAt, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// pgPaymentStore keeps payments in the payments table
+type pgPaymentStore struct{}
+
+func (pgPaymentStore) LockOrder(ctx context.Context, orderID string) (func(), error) {
+	if _, err := uuid.Parse(orderID); err != nil {
+		return func() {}, nil
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	// NO KEY UPDATE still lets the payment insert check its foreign key
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM orders WHERE id = $1 FOR NO KEY UPDATE`, orderID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return func() { tx.Rollback() }, nil
+}
+
+func (pgPaymentStore) Order(ctx context.Context, orderID string) (*Order, error) {
+	if _, err := uuid.Parse(orderID); err != nil {
+		return nil, nil
+	}
+	var o Order
+	err := db.QueryRowContext(ctx, `SELECT id, order_number, customer_email, total, currency, status FROM orders WHERE id = $1 AND deleted_at IS NULL`, orderID).
+		Scan(&o.ID, &o.OrderNumber, &o.Customer.Email, &o.Total, &o.Currency, &o.Status)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+func (pgPaymentStore) ForOrder(ctx context.Context, orderID string) (*Payment, error) {
+	return scanPayment(db.QueryRowContext(ctx, `SELECT `+paymentColumns+` FROM payments WHERE order_id = $1 ORDER BY created_at DESC LIMIT 1`, orderID))
+}
+
+func (pgPaymentStore) ByRef(ctx context.Context, provider, ref string) (*Payment, error) {
+	return scanPayment(db.QueryRowContext(ctx, `SELECT `+paymentColumns+` FROM payments WHERE provider = $1 AND provider_ref = $2`, provider, ref))
+}
+
+func (pgPaymentStore) Create(ctx context.Context, p *Payment) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO payments (`+paymentColumns+`) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
+		p.ID, p.OrderID, p.Provider, p.ProviderRef, p.Amount, p.Currency, p.Status, p.ClientSecret, p.CreatedAt, p.UpdatedAt)
+	return err
+}
+
+func (pgPaymentStore) SetStatus(ctx context.Context, p *Payment, status, orderStatus string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now()
+	if _, err := tx.ExecContext(ctx, `UPDATE payments SET status = $2, updated_at = $3 WHERE id = $1`, p.ID, status, now); err != nil {
+		return err
+	}
+	if orderStatus != "" {
+		if _, err := tx.ExecContext(ctx, `UPDATE orders SET status = $2, updated_at = $3 WHERE id = $1 AND status = 'pending'`, p.OrderID, orderStatus, now); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	p.Status, p.UpdatedAt = status, now
+	return nil
+}
+
+func (pgPaymentStore) EventSeen(ctx context.Context, provider, eventID string) (bool, error) {
+	var seen bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM webhook_events WHERE provider = $1 AND event_id = $2)`, provider, eventID).Scan(&seen)
+	return seen, err
+}
+
+func (pgPaymentStore) RecordEvent(ctx context.Context, provider, eventID string) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO webhook_events (provider, event_id, received_at) VALUES ($1,$2,$3) ON CONFLICT DO NOTHING`, provider, eventID, time.Now())
+	return err
+}
+
+// memoryPaymentStore keeps payments in memory when running on mock data
+type memoryPaymentStore struct {
+	startMu  sync.Mutex
+	mu       sync.Mutex
+	payments []*Payment
+	events   map[string]bool
+}
+
+var memoryPayments = &memoryPaymentStore{events: make(map[string]bool)}
+
+// LockOrder serializes all payment starts; mock data has little traffic
+func (s *memoryPaymentStore) LockOrder(context.Context, string) (func(), error) {
+	s.startMu.Lock()
+	return s.startMu.Unlock, nil
+}
+
+func (s *memoryPaymentStore) Order(_ context.Context, orderID string) (*Order, error) {
+	ordersMu.Lock()
+	defer ordersMu.Unlock()
+	if o, ok := orders[orderID]; ok && o.DeletedAt == nil {
+		return &o, nil
+	}
+	return nil, nil
+}
+
+func (s *memoryPaymentStore) ForOrder(_ context.Context, orderID string) (*Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.payments) - 1; i >= 0; i-- {
+		if s.payments[i].OrderID == orderID {
+			p := *s.payments[i]
+			return &p, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryPaymentStore) ByRef(_ context.Context, provider, ref string) (*Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.payments {
+		if p.Provider == provider && p.ProviderRef == ref {
+			cp := *p
+			return &cp, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryPaymentStore) Create(_ context.Context, p *Payment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := *p
+	s.payments = append(s.payments, &cp)
+	return nil
+}
+
+func (s *memoryPaymentStore) SetStatus(_ context.Context, p *Payment, status, orderStatus string) error {
+	now := time.Now()
+	s.mu.Lock()
+	for _, stored := range s.payments {
+		if stored.ID == p.ID {
+			stored.Status, stored.UpdatedAt = status, now
+		}
+	}
+	s.mu.Unlock()
+
+	if orderStatus != "" {
+		ordersMu.Lock()
+		if o, ok := orders[p.OrderID]; ok && o.Status == "pending" {
+			o.Status, o.UpdatedAt = orderStatus, now
+			orders[p.OrderID] = o
+		}
+		ordersMu.Unlock()
+	}
+	p.Status, p.UpdatedAt = status, now
+	return nil
+}
+
+func (s *memoryPaymentStore) EventSeen(_ context.Context, provider, eventID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events[provider+"/"+eventID], nil
+}
+
+func (s *memoryPaymentStore) RecordEvent(_ context.Context, provider, eventID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events[provider+"/"+eventID] = true
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// fakeSignatureHeader carries the webhook signature for the fake provider
+const fakeSignatureHeader = "Fake-Signature"
+
+// fakeProvider is an in-process gateway for local development. Every payment
+// is authorized immediately; webhooks are JSON events of the form
+// {"id": "evt_1", "type": "payment.succeeded", "ref": "fake_pi_...", "amount": 10.00, "currency": "USD"}
+// signed like Stripe's with the configured secret. The currency defaults to
+// the payment's.
+type fakeProvider struct {
+	secret string
+
+	mu       sync.Mutex
+	payments map[string]*fakePayment
+}
+
+type fakePayment struct {
+	amount   Money
+	currency string
+	captured Money
+	refunded Money
+}
+
+func newFakeProvider(secret string) *fakeProvider {
+	return &fakeProvider{secret: secret, payments: make(map[string]*fakePayment)}
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) Authorize(_ context.Context, req PaymentRequest) (*PaymentResult, error) {
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("fake: amount must be positive")
+	}
+	ref := "fake_pi_" + randomHex(12)
+	p.mu.Lock()
+	p.payments[ref] = &fakePayment{amount: req.Amount, currency: req.Currency}
+	p.mu.Unlock()
+	return &PaymentResult{Ref: ref, Status: paymentPending, ClientSecret: ref + "_secret_" + randomHex(8)}, nil
+}
+
+func (p *fakeProvider) Capture(_ context.Context, ref string, amount Money, _ string) (*PaymentResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fp, ok := p.payments[ref]
+	if !ok {
+		return nil, fmt.Errorf("fake: no payment %s", ref)
+	}
+	if amount > fp.amount {
+		return nil, fmt.Errorf("fake: cannot capture %s of %s", amount, fp.amount)
+	}
+	fp.captured = amount
+	return &PaymentResult{Ref: ref, Status: paymentSucceeded}, nil
+}
+
+func (p *fakeProvider) Refund(_ context.Context, ref string, amount Money, _, _ string) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fp, ok := p.payments[ref]
+	if !ok {
+		// Payments from before a restart are gone; accept the refund anyway
+		return "fake_re_" + randomHex(12), nil
+	}
+	if fp.refunded+amount > fp.captured {
+		return "", fmt.Errorf("fake: refund of %s exceeds captured %s", amount, fp.captured-fp.refunded)
+	}
+	fp.refunded += amount
+	return "fake_re_" + randomHex(12), nil
+}
+
+func (p *fakeProvider) VerifyWebhook(payload []byte, header http.Header) (*PaymentEvent, error) {
+	if err := verifyWebhookSignature(payload, header.Get(fakeSignatureHeader), p.secret); err != nil {
+		return nil, err
+	}
+	var event struct {
+		ID       string `json:"id"`
+		Type     string `json:"type"`
+		Ref      string `json:"ref"`
+		Amount   Money  `json:"amount"`
+		Currency string `json:"currency"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("fake: invalid event: %w", err)
+	}
+	if event.Currency == "" {
+		p.mu.Lock()
+		if fp, ok := p.payments[event.Ref]; ok {
+			event.Currency = fp.currency
+		}
+		p.mu.Unlock()
+	}
+	return &PaymentEvent{ID: event.ID, Type: event.Type, Ref: event.Ref, Amount: event.Amount, Currency: strings.ToUpper(event.Currency)}, nil
+}
+
+// randomHex returns n random bytes as hex
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
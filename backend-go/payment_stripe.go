@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripeSignatureHeader carries Stripe's webhook signature
+const stripeSignatureHeader = "Stripe-Signature"
+
+// stripeProvider talks to the Stripe API, or anything that speaks it: set
+// STRIPE_API_BASE to a local stand-in to exercise it without Stripe.
+// Payments are PaymentIntents with manual capture.
+type stripeProvider struct {
+	apiKey        string
+	webhookSecret string
+	baseURL       string
+	client        *http.Client
+}
+
+func newStripeProvider(apiKey, webhookSecret, baseURL string) (*stripeProvider, error) {
+	if apiKey == "" || webhookSecret == "" {
+		return nil, errors.New("stripe provider requires STRIPE_SECRET_KEY and STRIPE_WEBHOOK_SECRET")
+	}
+	return &stripeProvider{
+		apiKey:        apiKey,
+		webhookSecret: webhookSecret,
+		baseURL:       strings.TrimRight(baseURL, "/"),
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *stripeProvider) Name() string { return "stripe" }
+
+// stripeZeroDecimal lists the currencies Stripe takes in whole units rather
+// than hundredths
+var stripeZeroDecimal = map[string]bool{
+	"BIF": true, "CLP": true, "DJF": true, "GNF": true, "JPY": true, "KMF": true, "KRW": true, "MGA": true,
+	"PYG": true, "RWF": true, "UGX": true, "VND": true, "VUV": true, "XAF": true, "XOF": true, "XPF": true,
+}
+
+// stripeAmount converts an amount into the currency's minor unit as Stripe
+// counts it, rounding half away from zero for zero-decimal currencies
+func stripeAmount(amount Money, currency string) string {
+	v := int64(amount)
+	if stripeZeroDecimal[strings.ToUpper(currency)] {
+		if v < 0 {
+			v = (v - 50) / 100
+		} else {
+			v = (v + 50) / 100
+		}
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// stripeMoney is the inverse of stripeAmount
+func stripeMoney(amount int64, currency string) Money {
+	if stripeZeroDecimal[strings.ToUpper(currency)] {
+		return Money(amount * 100)
+	}
+	return Money(amount)
+}
+
+// stripeIntent is the subset of a PaymentIntent the shop reads
+type stripeIntent struct {
+	ID               string            `json:"id"`
+	Status           string            `json:"status"`
+	ClientSecret     string            `json:"client_secret"`
+	Currency         string            `json:"currency"`
+	Amount           int64             `json:"amount"`
+	AmountCapturable int64             `json:"amount_capturable"`
+	AmountReceived   int64             `json:"amount_received"`
+	Metadata         map[string]string `json:"metadata"`
+}
+
+// stripeStatus maps a PaymentIntent status onto the payment statuses
+func stripeStatus(status string) string {
+	switch status {
+	case "requires_capture":
+		return paymentAuthorized
+	case "succeeded":
+		return paymentSucceeded
+	case "canceled":
+		return paymentFailed
+	default:
+		return paymentPending
+	}
+}
+
+// post sends a form-encoded request and decodes the JSON response into out
+func (p *stripeProvider) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Type    string `json:"type"`
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("stripe: %s %s: %d %s %s", http.MethodPost, path, resp.StatusCode, apiErr.Error.Type, apiErr.Error.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (p *stripeProvider) Authorize(ctx context.Context, req PaymentRequest) (*PaymentResult, error) {
+	form := url.Values{}
+	form.Set("amount", stripeAmount(req.Amount, req.Currency))
+	form.Set("currency", strings.ToLower(req.Currency))
+	form.Set("capture_method", "manual")
+	form.Set("metadata[order_id]", req.OrderID)
+	form.Set("metadata[payment_id]", req.PaymentID)
+	if req.Email != "" {
+		form.Set("receipt_email", req.Email)
+	}
+	var intent stripeIntent
+	if err := p.post(ctx, "/v1/payment_intents", form, "authorize-"+req.PaymentID, &intent); err != nil {
+		return nil, err
+	}
+	return &PaymentResult{Ref: intent.ID, Status: stripeStatus(intent.Status), ClientSecret: intent.ClientSecret}, nil
+}
+
+func (p *stripeProvider) Capture(ctx context.Context, ref string, amount Money, currency string) (*PaymentResult, error) {
+	form := url.Values{}
+	form.Set("amount_to_capture", stripeAmount(amount, currency))
+	var intent stripeIntent
+	if err := p.post(ctx, "/v1/payment_intents/"+url.PathEscape(ref)+"/capture", form, "capture-"+ref, &intent); err != nil {
+		return nil, err
+	}
+	return &PaymentResult{Ref: intent.ID, Status: stripeStatus(intent.Status)}, nil
+}
+
+func (p *stripeProvider) Refund(ctx context.Context, ref string, amount Money, currency, refundID string) (string, error) {
+	form := url.Values{}
+	form.Set("payment_intent", ref)
+	form.Set("amount", stripeAmount(amount, currency))
+	form.Set("metadata[refund_id]", refundID)
+	var refund struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	if err := p.post(ctx, "/v1/refunds", form, "refund-"+refundID, &refund); err != nil {
+		return "", err
+	}
+	if refund.Status == "failed" || refund.Status == "canceled" {
+		return "", fmt.Errorf("stripe: refund %s %s", refund.ID, refund.Status)
+	}
+	return refund.ID, nil
+}
+
+func (p *stripeProvider) VerifyWebhook(payload []byte, header http.Header) (*PaymentEvent, error) {
+	if err := verifyWebhookSignature(payload, header.Get(stripeSignatureHeader), p.webhookSecret); err != nil {
+		return nil, err
+	}
+	var event struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object stripeIntent `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("stripe: invalid event: %w", err)
+	}
+
+	intent := event.Data.Object
+	result := &PaymentEvent{ID: event.ID, Ref: intent.ID, Currency: strings.ToUpper(intent.Currency)}
+	switch event.Type {
+	case "payment_intent.amount_capturable_updated":
+		result.Type = eventPaymentAuthorized
+		result.Amount = stripeMoney(intent.AmountCapturable, intent.Currency)
+	case "payment_intent.succeeded":
+		result.Type = eventPaymentSucceeded
+		result.Amount = stripeMoney(intent.AmountReceived, intent.Currency)
+	case "payment_intent.payment_failed", "payment_intent.canceled":
+		result.Type = eventPaymentFailed
+		result.Amount = stripeMoney(intent.Amount, intent.Currency)
+	}
+	return result, nil
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/lib/pq"
+)
+
+// maxProductImportSize caps uploaded product import files
+const maxProductImportSize = 10 << 20
+
+// productCSVColumns is the column order of CSV exports. Imports match
+// columns by name in any order; id and sku may be left out.
+var productCSVColumns = []string{"id", "sku", "name", "description", "price", "currency", "category", "image", "stock", "weight"}
+
+// ProductImportRow is what importing one row does, or would do on a dry run
+type ProductImportRow struct {
+	Row    int    `json:"row"`
+	Action string `json:"action"`
+	ID     int    `json:"id,omitempty"`
+	SKU    string `json:"sku,omitempty"`
+	Name   string `json:"name"`
+}
+
+// importRow is a parsed product along with where it came from
+type importRow struct {
+	row     int
+	product Product
+}
+
+// importProducts handles POST /api/admin/products/import. The body is CSV or
+// a JSON array of products, sent raw or as the multipart field "file", and
+// ?format= overrides the type guessed from the content type or file name.
+// Each row updates the product with its id, else the one with its sku, else
+// creates a product. Every row is checked before anything is written and
+// either all rows are applied or none; ?dryRun=true only reports the plan.
+func importProducts(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.Query("dryRun"))
+
+	var src io.Reader = http.MaxBytesReader(c.Writer, c.Request.Body, maxProductImportSize)
+	format := strings.ToLower(c.Query("format"))
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fh, err := c.FormFile("file")
+		if err != nil {
+			respondError(c, ErrValidationFailed, "Missing upload in field \"file\"")
+			return
+		}
+		if fh.Size > maxProductImportSize {
+			respondError(c, ErrValidationFailed, "Import file is too large")
+			return
+		}
+		f, err := fh.Open()
+		if err != nil {
+			respondInternalError(c, "Failed to read upload", err)
+			return
+		}
+		defer f.Close()
+		src = f
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(path.Ext(fh.Filename)), ".")
+		}
+	}
+	if format == "" {
+		format = "json"
+		if strings.Contains(c.ContentType(), "csv") {
+			format = "csv"
+		}
+	}
+
+	var rows []importRow
+	var details []FieldError
+	var err error
+	switch format {
+	case "csv":
+		rows, details, err = parseProductsCSV(src)
+	case "json":
+		rows, details, err = parseProductsJSON(src)
+	default:
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "format", Rule: "oneof", Message: "must be one of: csv, json"})
+		return
+	}
+	if err != nil {
+		respondError(c, ErrValidationFailed, err.Error())
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, "Failed to import products", err)
+		return
+	}
+	defer tx.Rollback()
+
+	plan, planDetails, err := planProductImport(ctx, tx, rows)
+	if err != nil {
+		respondInternalError(c, "Failed to import products", err)
+		return
+	}
+	details = append(details, planDetails...)
+	if len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more rows are invalid", details...)
+		return
+	}
+
+	initial := InventoryMovement{Type: movementRestock, Reason: "Product import"}
+	initial.setActor(c)
+	created, updated := 0, 0
+	for i, r := range rows {
+		if plan[i].Action == "create" {
+			created++
+		} else {
+			updated++
+		}
+		if dryRun {
+			continue
+		}
+		var before json.RawMessage
+		if plan[i].Action == "update" {
+			if before, err = snapshotRow(ctx, tx, "products", "id", plan[i].ID); err != nil {
+				respondInternalError(c, "Failed to import products", err)
+				return
+			}
+		}
+		if err := applyProductImport(ctx, tx, &plan[i], r.product, initial); err != nil {
+			if isUniqueViolation(err) {
+				respondError(c, ErrValidationFailed, "One or more rows are invalid", FieldError{Field: fmt.Sprintf("row[%d].sku", r.row), Rule: "unique", Message: "is already in use"})
+				return
+			}
+			respondInternalError(c, "Failed to import products", err)
+			return
+		}
+		after, err := snapshotRow(ctx, tx, "products", "id", plan[i].ID)
+		if err != nil {
+			respondInternalError(c, "Failed to import products", err)
+			return
+		}
+		if err := recordAudit(c, tx, plan[i].Action, "product", strconv.Itoa(plan[i].ID), before, after); err != nil {
+			respondInternalError(c, "Failed to import products", err)
+			return
+		}
+	}
+	if !dryRun {
+		if err := tx.Commit(); err != nil {
+			respondInternalError(c, "Failed to import products", err)
+			return
+		}
+	}
+
+	message := fmt.Sprintf("Imported %d products (%d created, %d updated)", len(plan), created, updated)
+	if dryRun {
+		message = fmt.Sprintf("Dry run: %d products would be imported (%d created, %d updated)", len(plan), created, updated)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"dryRun":  dryRun,
+		"message": message,
+		"data":    plan,
+	})
+}
+
+// parseProductsCSV reads products from CSV with a header row. Rows are
+// numbered by the line they start on, so the first product is row 2.
+func parseProductsCSV(r io.Reader) ([]importRow, []FieldError, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil, errors.New("CSV contains no products")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(productCSVColumns, name) {
+			return nil, nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+		columns[name] = i
+	}
+	for _, name := range productCSVColumns[2:] {
+		if _, ok := columns[name]; !ok {
+			return nil, nil, fmt.Errorf("CSV is missing the %q column", name)
+		}
+	}
+	reader.FieldsPerRecord = len(header)
+
+	var rows []importRow
+	var details []FieldError
+	for {
+		rec, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(rec[i])
+			}
+			return ""
+		}
+		prefix := fmt.Sprintf("row[%d].", line)
+		var rowDetails []FieldError
+		integer := func(name string) int {
+			s := field(name)
+			if s == "" {
+				return 0
+			}
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				rowDetails = append(rowDetails, FieldError{Field: prefix + name, Rule: "type", Message: "must be a whole number"})
+			}
+			return n
+		}
+		p := Product{
+			ID:          integer("id"),
+			SKU:         field("sku"),
+			Name:        field("name"),
+			Description: field("description"),
+			Currency:    field("currency"),
+			Category:    field("category"),
+			Image:       field("image"),
+			Stock:       integer("stock"),
+			Weight:      integer("weight"),
+		}
+		if price, err := parseMoney(field("price")); err != nil {
+			rowDetails = append(rowDetails, FieldError{Field: prefix + "price", Rule: "type", Message: "must be a decimal amount"})
+		} else {
+			p.Price = price
+		}
+		if len(rowDetails) > 0 {
+			details = append(details, rowDetails...)
+			continue
+		}
+		rows = append(rows, importRow{row: line, product: p})
+	}
+	if len(rows) == 0 && len(details) == 0 {
+		return nil, nil, errors.New("CSV contains no products")
+	}
+	return rows, details, nil
+}
+
+// parseProductsJSON reads a JSON array of products, numbering rows from 1
+func parseProductsJSON(r io.Reader) ([]importRow, []FieldError, error) {
+	var raw []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, nil, errors.New("body must be a JSON array of products")
+	}
+	if len(raw) == 0 {
+		return nil, nil, errors.New("JSON contains no products")
+	}
+
+	var rows []importRow
+	var details []FieldError
+	for i, item := range raw {
+		var p Product
+		if err := json.Unmarshal(item, &p); err != nil {
+			var typeErr *json.UnmarshalTypeError
+			field := fmt.Sprintf("row[%d]", i+1)
+			message := "must be a product object"
+			if errors.As(err, &typeErr) && typeErr.Field != "" {
+				field += "." + typeErr.Field
+				message = fmt.Sprintf("must be a %s", typeErr.Type)
+			}
+			details = append(details, FieldError{Field: field, Rule: "type", Message: message})
+			continue
+		}
+		rows = append(rows, importRow{row: i + 1, product: p})
+	}
+	return rows, details, nil
+}
+
+// planProductImport normalizes and validates every row and works out which
+// product each one creates or updates. It reports all invalid rows at once.
+func planProductImport(ctx context.Context, tx *sql.Tx, rows []importRow) ([]ProductImportRow, []FieldError, error) {
+	categories, err := loadCategories(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	slugs := make(map[string]bool, len(categories))
+	for _, cat := range categories {
+		slugs[cat.Slug] = true
+	}
+
+	var ids []int64
+	var skus []string
+	for i := range rows {
+		p := &rows[i].product
+		p.SKU = normalizeSKU(p.SKU)
+		p.Name = strings.TrimSpace(p.Name)
+		p.Currency = normalizeCurrency(p.Currency)
+		if p.ID != 0 {
+			ids = append(ids, int64(p.ID))
+		}
+		if p.SKU != "" {
+			skus = append(skus, p.SKU)
+		}
+	}
+
+	// Existing products the rows refer to, by ID and by SKU
+	type existing struct {
+		id      int
+		sku     string
+		stock   int
+		deleted bool
+	}
+	byID := make(map[int]existing)
+	bySKU := make(map[string]existing)
+	dbRows, err := tx.QueryContext(ctx, `SELECT id, COALESCE(sku, ''), stock, deleted_at IS NOT NULL FROM products WHERE id = ANY($1) OR sku = ANY($2) FOR UPDATE`,
+		pq.Int64Array(ids), pq.Array(skus))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer dbRows.Close()
+	for dbRows.Next() {
+		var e existing
+		if err := dbRows.Scan(&e.id, &e.sku, &e.stock, &e.deleted); err != nil {
+			return nil, nil, err
+		}
+		byID[e.id] = e
+		if e.sku != "" {
+			bySKU[e.sku] = e
+		}
+	}
+	if err := dbRows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	plan := make([]ProductImportRow, len(rows))
+	var details []FieldError
+	seenIDs := make(map[int]int)
+	seenSKUs := make(map[string]int)
+	for i, r := range rows {
+		p := r.product
+		prefix := fmt.Sprintf("row[%d].", r.row)
+		invalid := func(field, rule, message string) {
+			details = append(details, FieldError{Field: prefix + field, Rule: rule, Message: message})
+		}
+		before := len(details)
+
+		if err := binding.Validator.ValidateStruct(&p); err != nil {
+			var verrs validator.ValidationErrors
+			if !errors.As(err, &verrs) {
+				return nil, nil, err
+			}
+			for _, fe := range verrs {
+				invalid(fieldPath(fe), fe.Tag(), validationMessage(fe))
+			}
+		}
+		if p.Name == "" {
+			invalid("name", "required", "is required")
+		}
+		if p.Price < 0 {
+			invalid("price", "min", "must be at least 0")
+		}
+		if p.Stock < 0 {
+			invalid("stock", "min", "must be at least 0")
+		}
+		if !slugs[p.Category] {
+			invalid("category", "exists", "must be the slug of an existing category")
+		}
+
+		step := ProductImportRow{Row: r.row, Action: "create", SKU: p.SKU, Name: p.Name}
+		if p.ID != 0 {
+			e, ok := byID[p.ID]
+			if !ok || e.deleted {
+				invalid("id", "exists", fmt.Sprintf("product %d does not exist", p.ID))
+			}
+			if other, ok := bySKU[p.SKU]; ok && other.id != p.ID {
+				invalid("sku", "unique", fmt.Sprintf("is already in use by product %d", other.id))
+			}
+			step.Action, step.ID = "update", p.ID
+		} else if e, ok := bySKU[p.SKU]; ok {
+			if e.deleted {
+				invalid("sku", "unique", fmt.Sprintf("belongs to deleted product %d", e.id))
+			}
+			step.Action, step.ID = "update", e.id
+		}
+		if step.ID != 0 {
+			// Stock changes go through the ledger, not an import
+			if e, ok := byID[step.ID]; ok && !e.deleted && p.Stock != e.stock {
+				invalid("stock", "eq", fmt.Sprintf("must match the current stock of %d; use POST /api/admin/products/%d/stock-adjustments to change it", e.stock, step.ID))
+			}
+			if first, ok := seenIDs[step.ID]; ok {
+				invalid("id", "unique", fmt.Sprintf("product %d is already imported by row %d", step.ID, first))
+			} else {
+				seenIDs[step.ID] = r.row
+			}
+		}
+		if p.SKU != "" {
+			if first, ok := seenSKUs[p.SKU]; ok {
+				invalid("sku", "unique", fmt.Sprintf("is already used by row %d", first))
+			} else {
+				seenSKUs[p.SKU] = r.row
+			}
+		}
+
+		if len(details) == before {
+			plan[i] = step
+		}
+	}
+	return plan, details, nil
+}
+
+// applyProductImport writes one planned row. A new product's stock is
+// booked in as an initial movement; like updateProduct, an update leaves
+// stock alone, and planProductImport has checked it is unchanged.
+func applyProductImport(ctx context.Context, tx *sql.Tx, step *ProductImportRow, p Product, initial InventoryMovement) error {
+	if step.Action == "create" {
+		err := tx.QueryRowContext(ctx, `INSERT INTO products (sku, name, description, price, currency, category, image, stock, weight_grams) VALUES (NULLIF($1, ''),$2,$3,$4,$5,$6,$7,0,$8) RETURNING id`,
+			p.SKU, p.Name, p.Description, p.Price, p.Currency, p.Category, p.Image, p.Weight).Scan(&step.ID)
+		if err != nil || p.Stock == 0 {
+			return err
+		}
+		initial.ProductID, initial.Quantity = step.ID, p.Stock
+		return moveStock(ctx, tx, &initial)
+	}
+	_, err := tx.ExecContext(ctx, `UPDATE products SET sku=NULLIF($1, ''), name=$2, description=$3, price=$4, currency=$5, category=$6, image=$7, weight_grams=$8
+		WHERE id=$9`,
+		p.SKU, p.Name, p.Description, p.Price, p.Currency, p.Category, p.Image, p.Weight, step.ID)
+	return err
+}
+
+// exportProducts handles GET /api/admin/products/export?format=csv|json. Rows
+// are written as they are read, so the export never sits in memory; its
+// output can be fed back to importProducts.
+func exportProducts(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+	format := strings.ToLower(c.DefaultQuery("format", "json"))
+	if format != "csv" && format != "json" {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "format", Rule: "oneof", Message: "must be one of: csv, json"})
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT id, COALESCE(sku, ''), name, description, price, currency, category, image, stock, weight_grams
+		FROM products WHERE deleted_at IS NULL ORDER BY id`)
+	if err != nil {
+		respondInternalError(c, "Failed to export products", err)
+		return
+	}
+	defer rows.Close()
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="products.%s"`, format))
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		c.Header("Content-Type", "application/json; charset=utf-8")
+	}
+	c.Status(http.StatusOK)
+
+	// Headers are gone once the first row is written, so later failures can
+	// only cut the download short
+	fail := func(err error) {
+		loggerFrom(c).Error("Product export failed", "error", err, "format", format)
+		_ = c.Error(err)
+	}
+	var csvWriter *csv.Writer
+	if format == "csv" {
+		csvWriter = csv.NewWriter(c.Writer)
+		if err := csvWriter.Write(productCSVColumns); err != nil {
+			fail(err)
+			return
+		}
+	} else if _, err := io.WriteString(c.Writer, "["); err != nil {
+		fail(err)
+		return
+	}
+	encoder := json.NewEncoder(c.Writer)
+	for n := 0; rows.Next(); n++ {
+		var p Product
+		if err := rows.Scan(&p.ID, &p.SKU, &p.Name, &p.Description, &p.Price, &p.Currency, &p.Category, &p.Image, &p.Stock, &p.Weight); err != nil {
+			fail(err)
+			return
+		}
+		if csvWriter != nil {
+			err = csvWriter.Write([]string{strconv.Itoa(p.ID), p.SKU, p.Name, p.Description, p.Price.String(), p.Currency, p.Category, p.Image, strconv.Itoa(p.Stock), strconv.Itoa(p.Weight)})
+		} else {
+			if n > 0 {
+				_, err = io.WriteString(c.Writer, ",")
+			}
+			if err == nil {
+				err = encoder.Encode(p)
+			}
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		fail(err)
+		return
+	}
+	if csvWriter != nil {
+		csvWriter.Flush()
+		err = csvWriter.Error()
+	} else {
+		_, err = io.WriteString(c.Writer, "]\n")
+	}
+	if err != nil {
+		fail(err)
+	}
+}
@@ -0,0 +1,638 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Order statuses set by refunds
+const (
+	orderRefunded          = "refunded"
+	orderPartiallyRefunded = "partially_refunded"
+)
+
+// Refund statuses. A refund is pending from when it is reserved against the
+// order until the payment provider has answered.
+const (
+	refundPending   = "pending"
+	refundSucceeded = "succeeded"
+	refundFailed    = "failed"
+)
+
+var (
+	errRefundOrderNotFound = errors.New("order not found")
+	errOrderNotRefundable  = errors.New("order cannot be refunded")
+)
+
+// refundError explains why a refund request can't be applied to an order
+type refundError struct {
+	reason string
+}
+
+func (e *refundError) Error() string { return e.reason }
+
+// providerRefundError wraps a failure of the payment provider
+type providerRefundError struct {
+	err error
+}
+
+func (e *providerRefundError) Error() string { return "provider refund: " + e.err.Error() }
+func (e *providerRefundError) Unwrap() error { return e.err }
+
+// RefundRequest represents POST /api/admin/orders/:orderId/refunds. Without
+// items everything not yet refunded is refunded, shipping included.
+type RefundRequest struct {
+	Items []RefundLine `json:"items" binding:"omitempty,dive"`
+	// Shipping also refunds the shipping charge on a partial refund
+	Shipping bool `json:"shipping"`
+	// Restock puts the refunded quantities back into stock
+	Restock bool   `json:"restock"`
+	Reason  string `json:"reason" binding:"max=500"`
+}
+
+// RefundLine asks for quantity units of a product to be refunded. VariantID
+// narrows it to the lines of one variant.
+type RefundLine struct {
+	ID        int `json:"id" binding:"required"`
+	VariantID int `json:"variantId,omitempty"`
+	Quantity  int `json:"quantity" binding:"required,min=1"`
+}
+
+// Refund is money returned to the customer for part or all of an order
+type Refund struct {
+	ID       string       `json:"id"`
+	OrderID  string       `json:"orderId"`
+	Amount   Money        `json:"amount"`
+	Currency string       `json:"currency"`
+	Reason   string       `json:"reason,omitempty"`
+	Items    []RefundItem `json:"items"`
+	Shipping Money        `json:"shipping"`
+	Restock  bool         `json:"restock"`
+	// Provider and ProviderRefundID are empty when the order had no captured
+	// payment and the money was returned outside the shop
+	Provider         string    `json:"provider,omitempty"`
+	ProviderRefundID string    `json:"providerRefundId,omitempty"`
+	Status           string    `json:"status"`
+	CreatedAt        time.Time `json:"createdAt"`
+	// orderStatus is the order's status before the refund
+	orderStatus string
+}
+
+// RefundItem is the refunded part of an order line
+type RefundItem struct {
+	ID        int   `json:"id"`
+	VariantID int   `json:"variantId,omitempty"`
+	Quantity  int   `json:"quantity"`
+	Amount    Money `json:"amount"`
+	// line is the index of the order item
+	line int
+}
+
+// lineTotal is what the customer paid for an order line: the price less its
+// discount plus any exclusive tax
+func lineTotal(item OrderItem) Money {
+	total := item.Price.Mul(item.Quantity) - item.Discount
+	if !item.TaxInclusive {
+		total += item.TaxAmount
+	}
+	return total
+}
+
+// lineShare is the part of a line's total covered by its first n units.
+// Refund amounts are differences of shares, so refunding a line in several
+// steps adds up to exactly its total.
+func lineShare(item OrderItem, n int) Money {
+	if n >= item.Quantity {
+		return lineTotal(item)
+	}
+	return Money(int64(lineTotal(item)) * int64(n) / int64(item.Quantity))
+}
+
+// planRefund works out the refund for req and applies it to order: the
+// refunded quantities, the refunded amount and the status. shippingRefunded
+// is how much of the shipping charge earlier refunds returned.
+func planRefund(order *Order, req RefundRequest, shippingRefunded Money) (*Refund, error) {
+	if order.Status == "pending" || order.Status == "cancelled" || order.Status == orderRefunded {
+		return nil, errOrderNotRefundable
+	}
+
+	refund := &Refund{
+		ID:          uuid.New().String(),
+		OrderID:     order.ID,
+		Currency:    order.Currency,
+		Reason:      req.Reason,
+		Items:       []RefundItem{},
+		Restock:     req.Restock,
+		Status:      refundPending,
+		CreatedAt:   time.Now(),
+		orderStatus: order.Status,
+	}
+
+	type lineKey struct{ id, variantID int }
+	wanted := make(map[lineKey]int)
+	var keys []lineKey
+	if len(req.Items) == 0 {
+		for _, item := range order.Items {
+			key := lineKey{item.ID, item.VariantID}
+			if _, ok := wanted[key]; !ok {
+				keys = append(keys, key)
+			}
+			wanted[key] += item.Quantity - item.RefundedQuantity
+		}
+	} else {
+		for _, line := range req.Items {
+			key := lineKey{line.ID, line.VariantID}
+			if _, ok := wanted[key]; !ok {
+				keys = append(keys, key)
+			}
+			wanted[key] += line.Quantity
+		}
+	}
+
+	// A product can appear on several lines; take from them in order
+	for _, key := range keys {
+		id := key.id
+		remaining := wanted[key]
+		found := false
+		for i := range order.Items {
+			item := &order.Items[i]
+			if item.ID != id || (key.variantID != 0 && item.VariantID != key.variantID) {
+				continue
+			}
+			found = true
+			qty := item.Quantity - item.RefundedQuantity
+			if qty > remaining {
+				qty = remaining
+			}
+			if qty == 0 {
+				continue
+			}
+			amount := lineShare(*item, item.RefundedQuantity+qty) - lineShare(*item, item.RefundedQuantity)
+			item.RefundedQuantity += qty
+			refund.Items = append(refund.Items, RefundItem{ID: id, VariantID: item.VariantID, Quantity: qty, Amount: amount, line: i})
+			refund.Amount += amount
+			remaining -= qty
+		}
+		if !found {
+			return nil, &refundError{fmt.Sprintf("Product %d is not part of this order", id)}
+		}
+		if remaining > 0 {
+			return nil, &refundError{fmt.Sprintf("Only %d more of product %d can be refunded", wanted[key]-remaining, id)}
+		}
+	}
+
+	if len(req.Items) == 0 || req.Shipping {
+		refund.Shipping = order.Shipping - shippingRefunded
+		refund.Amount += refund.Shipping
+	}
+	if refund.Amount <= 0 {
+		return nil, &refundError{"Nothing left to refund"}
+	}
+	if refund.Amount > order.Total-order.Refunded {
+		return nil, &refundError{fmt.Sprintf("Refund of %s exceeds the %s left on the order", refund.Amount, order.Total-order.Refunded)}
+	}
+
+	order.Refunded += refund.Amount
+	order.Status = refundStatus(order, shippingRefunded+refund.Shipping)
+	order.UpdatedAt = refund.CreatedAt
+	return refund, nil
+}
+
+// refundStatus is the status of an order with refunds: refunded once every
+// unit and the whole shipping charge went back
+func refundStatus(order *Order, shippingRefunded Money) string {
+	if shippingRefunded < order.Shipping {
+		return orderPartiallyRefunded
+	}
+	for _, item := range order.Items {
+		if item.RefundedQuantity < item.Quantity {
+			return orderPartiallyRefunded
+		}
+	}
+	return orderRefunded
+}
+
+// revertRefund undoes planRefund for a refund the provider rejected.
+// shippingRefunded is what the order's other refunds returned of the
+// shipping charge and originalStatus its status before its first refund.
+func revertRefund(order *Order, refund *Refund, shippingRefunded Money, originalStatus string) {
+	for _, item := range refund.Items {
+		order.Items[item.line].RefundedQuantity -= item.Quantity
+	}
+	order.Refunded -= refund.Amount
+	order.Status = originalStatus
+	if order.Refunded > 0 {
+		order.Status = refundStatus(order, shippingRefunded)
+	}
+	order.UpdatedAt = time.Now()
+}
+
+// refundPayment returns the refund amount through the payment provider when
+// the order was paid through it
+func refundPayment(ctx context.Context, refund *Refund) error {
+	payment, err := currentPaymentStore().ForOrder(ctx, refund.OrderID)
+	if err != nil {
+		return err
+	}
+	if payment == nil || payment.Status != paymentSucceeded {
+		return nil
+	}
+	if payment.Provider != paymentProvider.Name() {
+		return &providerRefundError{fmt.Errorf("payment %s was made with %s but %s is configured", payment.ID, payment.Provider, paymentProvider.Name())}
+	}
+	id, err := paymentProvider.Refund(ctx, payment.ProviderRef, refund.Amount, refund.Currency, refund.ID)
+	if err != nil {
+		return &providerRefundError{err}
+	}
+	refund.Provider, refund.ProviderRefundID = payment.Provider, id
+	return nil
+}
+
+// refundOrder refunds an order, returning the refund and the updated order.
+// The refund is first reserved against the order as pending, so concurrent
+// refunds can't exceed its total, and the payment provider is called only
+// once the order is no longer locked. A rejected refund is then reverted.
+func refundOrder(c *gin.Context, orderID string, req RefundRequest) (*Refund, *Order, error) {
+	if db == nil {
+		return refundMockOrder(c, orderID, req)
+	}
+	ctx := c.Request.Context()
+	if _, err := uuid.Parse(orderID); err != nil {
+		return nil, nil, errRefundOrderNotFound
+	}
+
+	refund, order, lineIDs, err := reserveRefund(ctx, orderID, req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Settle the refund whatever happens to the request from here on
+	ctx = context.WithoutCancel(ctx)
+	c = c.Copy()
+	c.Request = c.Request.WithContext(ctx)
+	if perr := refundPayment(ctx, refund); perr != nil {
+		if err := failRefund(ctx, refund, lineIDs); err != nil {
+			slog.ErrorContext(ctx, "Rejected refund left pending", "error", err, "order_id", order.ID, "refund_id", refund.ID)
+		}
+		return nil, nil, perr
+	}
+	if err := completeRefund(c, refund); err != nil {
+		// The provider has returned the money but the refund is still
+		// pending; log enough for a person to reconcile it
+		slog.ErrorContext(ctx, "Refund issued but not recorded", "error", err, "order_id", order.ID, "refund_id", refund.ID, "provider", refund.Provider, "provider_refund_id", refund.ProviderRefundID, "amount", refund.Amount.String())
+		return nil, nil, err
+	}
+	return refund, order, nil
+}
+
+// lockRefundOrder loads an order and its lines for update, along with the
+// IDs of the lines and the shipping its live refunds returned. Deleted orders
+// are loaded too, so a refund reserved before the order was deleted can still
+// be settled.
+func lockRefundOrder(ctx context.Context, tx *sql.Tx, orderID string) (*Order, []int, Money, error) {
+	var order Order
+	err := tx.QueryRowContext(ctx, `SELECT id, order_number, customer_email, shipping, total, refunded, currency, status, deleted_at FROM orders WHERE id = $1 FOR UPDATE`, orderID).
+		Scan(&order.ID, &order.OrderNumber, &order.Customer.Email, &order.Shipping, &order.Total, &order.Refunded, &order.Currency, &order.Status, &order.DeletedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil, 0, errRefundOrderNotFound
+	}
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, product_id, COALESCE(variant_id, 0), name, price, quantity, discount, tax_amount, tax_inclusive, refunded_quantity FROM order_items WHERE order_id = $1 ORDER BY id`, orderID)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	var lineIDs []int
+	for rows.Next() {
+		var lineID int
+		var item OrderItem
+		if err := rows.Scan(&lineID, &item.ID, &item.VariantID, &item.Name, &item.Price, &item.Quantity, &item.Discount, &item.TaxAmount, &item.TaxInclusive, &item.RefundedQuantity); err != nil {
+			rows.Close()
+			return nil, nil, 0, err
+		}
+		lineIDs = append(lineIDs, lineID)
+		order.Items = append(order.Items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, 0, err
+	}
+
+	var shippingRefunded Money
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(shipping), 0) FROM refunds WHERE order_id = $1 AND status <> $2`, orderID, refundFailed).Scan(&shippingRefunded); err != nil {
+		return nil, nil, 0, err
+	}
+	return &order, lineIDs, shippingRefunded, nil
+}
+
+// reserveRefund plans a refund and records it as pending, applying it to
+// the order's refunded quantities, amount and status
+func reserveRefund(ctx context.Context, orderID string, req RefundRequest) (*Refund, *Order, []int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer tx.Rollback()
+
+	// Locking the order serializes refunds so they can't exceed its total
+	order, lineIDs, shippingRefunded, err := lockRefundOrder(ctx, tx, orderID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if order.DeletedAt != nil {
+		return nil, nil, nil, errRefundOrderNotFound
+	}
+	refund, err := planRefund(order, req, shippingRefunded)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO refunds (id, order_id, amount, currency, reason, shipping, restock, status, order_status, created_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
+		refund.ID, refund.OrderID, refund.Amount, refund.Currency, refund.Reason, refund.Shipping, refund.Restock, refund.Status, refund.orderStatus, refund.CreatedAt); err != nil {
+		return nil, nil, nil, err
+	}
+	for _, item := range refund.Items {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO refund_items (refund_id, product_id, variant_id, quantity, amount) VALUES ($1,$2,$3,$4,$5)`, refund.ID, item.ID, item.VariantID, item.Quantity, item.Amount); err != nil {
+			return nil, nil, nil, err
+		}
+		if _, err := tx.ExecContext(ctx, `UPDATE order_items SET refunded_quantity = refunded_quantity + $1 WHERE id = $2`, item.Quantity, lineIDs[item.line]); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE orders SET refunded = $2, status = $3, updated_at = $4 WHERE id = $1`, order.ID, order.Refunded, order.Status, order.UpdatedAt); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, nil, err
+	}
+	return refund, order, lineIDs, nil
+}
+
+// completeRefund marks a refund the provider accepted as succeeded, puts its
+// quantities back into stock when asked to and records it in the audit log
+func completeRefund(c *gin.Context, refund *Refund) error {
+	ctx := c.Request.Context()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	refund.Status = refundSucceeded
+	if _, err := tx.ExecContext(ctx, `UPDATE refunds SET status = $2, provider = $3, provider_refund_id = $4 WHERE id = $1`,
+		refund.ID, refund.Status, refund.Provider, refund.ProviderRefundID); err != nil {
+		return err
+	}
+	if refund.Restock {
+		for _, item := range refund.Items {
+			movement := InventoryMovement{ProductID: item.ID, VariantID: item.VariantID, Type: movementReturn, Quantity: item.Quantity, Reason: "Refund " + refund.ID, OrderID: refund.OrderID}
+			if err := moveStock(ctx, tx, &movement); err != nil && !errors.Is(err, errStockNotFound) {
+				return err
+			}
+		}
+	}
+	if err := recordAudit(c, tx, "refund", "order", refund.OrderID, nil, refund); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// failRefund marks a refund the provider rejected as failed and takes it
+// back off the order
+func failRefund(ctx context.Context, refund *Refund, lineIDs []int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	order, currentLineIDs, shippingRefunded, err := lockRefundOrder(ctx, tx, refund.OrderID)
+	if err != nil {
+		return err
+	}
+	if len(currentLineIDs) != len(lineIDs) {
+		return fmt.Errorf("order %s lines changed while refunding", refund.OrderID)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE refunds SET status = $2 WHERE id = $1`, refund.ID, refundFailed); err != nil {
+		return err
+	}
+	shippingRefunded -= refund.Shipping
+	var originalStatus string
+	if err := tx.QueryRowContext(ctx, `SELECT order_status FROM refunds WHERE order_id = $1 ORDER BY created_at, id LIMIT 1`, refund.OrderID).Scan(&originalStatus); err != nil {
+		return err
+	}
+
+	revertRefund(order, refund, shippingRefunded, originalStatus)
+	for _, item := range refund.Items {
+		if _, err := tx.ExecContext(ctx, `UPDATE order_items SET refunded_quantity = refunded_quantity - $1 WHERE id = $2`, item.Quantity, lineIDs[item.line]); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE orders SET refunded = $2, status = $3, updated_at = $4 WHERE id = $1`, order.ID, order.Refunded, order.Status, order.UpdatedAt); err != nil {
+		return err
+	}
+	refund.Status = refundFailed
+	return tx.Commit()
+}
+
+// refundMockOrder refunds an order held in memory. Mock products don't track
+// stock, so restocking only marks the refund. As with the database, the
+// refund is reserved first and the provider called without holding the lock.
+func refundMockOrder(c *gin.Context, orderID string, req RefundRequest) (*Refund, *Order, error) {
+	ordersMu.Lock()
+	stored, ok := orders[orderID]
+	if !ok || stored.DeletedAt != nil {
+		ordersMu.Unlock()
+		return nil, nil, errRefundOrderNotFound
+	}
+	order := stored
+	order.Items = append([]OrderItem(nil), stored.Items...)
+	refund, err := planRefund(&order, req, mockShippingRefunded(order.Refunds))
+	if err != nil {
+		ordersMu.Unlock()
+		return nil, nil, err
+	}
+	order.Refunds = append(append([]Refund(nil), stored.Refunds...), *refund)
+	orders[orderID] = order
+	ordersMu.Unlock()
+
+	perr := refundPayment(c.Request.Context(), refund)
+	if perr == nil {
+		refund.Status = refundSucceeded
+	} else {
+		refund.Status = refundFailed
+	}
+
+	ordersMu.Lock()
+	defer ordersMu.Unlock()
+	order = orders[orderID]
+	order.Items = append([]OrderItem(nil), order.Items...)
+	order.Refunds = append([]Refund(nil), order.Refunds...)
+	for i := range order.Refunds {
+		if order.Refunds[i].ID == refund.ID {
+			order.Refunds[i] = *refund
+		}
+	}
+	if perr != nil {
+		revertRefund(&order, refund, mockShippingRefunded(order.Refunds), order.Refunds[0].orderStatus)
+	} else if err := recordAudit(c, nil, "refund", "order", orderID, nil, refund); err != nil {
+		return nil, nil, err
+	}
+	orders[orderID] = order
+	if perr != nil {
+		return nil, nil, perr
+	}
+	return refund, &order, nil
+}
+
+// mockShippingRefunded sums the shipping returned by refunds that didn't fail
+func mockShippingRefunded(refunds []Refund) Money {
+	var total Money
+	for _, r := range refunds {
+		if r.Status != refundFailed {
+			total += r.Shipping
+		}
+	}
+	return total
+}
+
+// createRefund handles POST /api/admin/orders/:orderId/refunds
+func createRefund(c *gin.Context) {
+	var req RefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+
+	refund, order, err := refundOrder(c, c.Param("orderId"), req)
+	var rerr *refundError
+	var perr *providerRefundError
+	switch {
+	case err == nil:
+	case errors.Is(err, errRefundOrderNotFound):
+		respondError(c, ErrOrderNotFound, "")
+		return
+	case errors.Is(err, errOrderNotRefundable):
+		respondError(c, ErrOrderNotRefundable, "Only paid orders with something left to refund can be refunded")
+		return
+	case errors.As(err, &rerr):
+		respondError(c, ErrValidationFailed, rerr.reason)
+		return
+	case errors.As(err, &perr):
+		loggerFrom(c).Error("Refund rejected by payment provider", "error", err, "order_id", c.Param("orderId"), "provider", paymentProvider.Name())
+		respondError(c, ErrRefundFailed, "The payment provider rejected the refund")
+		return
+	default:
+		respondInternalError(c, "Failed to refund order", err)
+		return
+	}
+	recordRefund(*refund)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data": gin.H{
+			"refund": refund,
+			"order": gin.H{
+				"id":          order.ID,
+				"orderNumber": order.OrderNumber,
+				"status":      order.Status,
+				"total":       order.Total,
+				"refunded":    order.Refunded,
+				"currency":    order.Currency,
+			},
+		},
+		"message": "Order refunded successfully",
+	})
+}
+
+// getRefunds handles GET /api/admin/orders/:orderId/refunds
+func getRefunds(c *gin.Context) {
+	ctx := c.Request.Context()
+	orderID := c.Param("orderId")
+
+	if db == nil {
+		ordersMu.Lock()
+		order, ok := orders[orderID]
+		ordersMu.Unlock()
+		if !ok {
+			respondError(c, ErrOrderNotFound, "")
+			return
+		}
+		refunds := order.Refunds
+		if refunds == nil {
+			refunds = []Refund{}
+		}
+		c.JSON(http.StatusOK, gin.H{"success": true, "data": refunds, "total": len(refunds)})
+		return
+	}
+
+	if _, err := uuid.Parse(orderID); err != nil {
+		respondError(c, ErrOrderNotFound, "")
+		return
+	}
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE id = $1)`, orderID).Scan(&exists); err != nil {
+		respondInternalError(c, "Failed to load order", err)
+		return
+	}
+	if !exists {
+		respondError(c, ErrOrderNotFound, "")
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT id, order_id, amount, currency, reason, shipping, restock, provider, provider_refund_id, status, created_at FROM refunds WHERE order_id = $1 ORDER BY created_at`, orderID)
+	if err != nil {
+		respondInternalError(c, "Failed to load refunds", err)
+		return
+	}
+	defer rows.Close()
+	refunds := make([]Refund, 0)
+	index := make(map[string]int)
+	for rows.Next() {
+		r := Refund{Items: []RefundItem{}}
+		if err := rows.Scan(&r.ID, &r.OrderID, &r.Amount, &r.Currency, &r.Reason, &r.Shipping, &r.Restock, &r.Provider, &r.ProviderRefundID, &r.Status, &r.CreatedAt); err != nil {
+			respondInternalError(c, "Failed to load refunds", err)
+			return
+		}
+		index[r.ID] = len(refunds)
+		refunds = append(refunds, r)
+	}
+	if err := rows.Err(); err != nil {
+		respondInternalError(c, "Failed to load refunds", err)
+		return
+	}
+
+	itemRows, err := db.QueryContext(ctx, `SELECT ri.refund_id, ri.product_id, ri.variant_id, ri.quantity, ri.amount FROM refund_items ri JOIN refunds r ON r.id = ri.refund_id WHERE r.order_id = $1 ORDER BY ri.id`, orderID)
+	if err != nil {
+		respondInternalError(c, "Failed to load refunds", err)
+		return
+	}
+	defer itemRows.Close()
+	for itemRows.Next() {
+		var refundID string
+		var item RefundItem
+		if err := itemRows.Scan(&refundID, &item.ID, &item.VariantID, &item.Quantity, &item.Amount); err != nil {
+			respondInternalError(c, "Failed to load refunds", err)
+			return
+		}
+		if i, ok := index[refundID]; ok {
+			refunds[i].Items = append(refunds[i].Items, item)
+		}
+	}
+	if err := itemRows.Err(); err != nil {
+		respondInternalError(c, "Failed to load refunds", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": refunds, "total": len(refunds)})
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+var errShippingUnavailable = errors.New("shipping method unavailable")
+
+// Address is a structured postal address
+type Address struct {
+	Line1      string `json:"line1" binding:"required"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city" binding:"required"`
+	Region     string `json:"region,omitempty"`
+	PostalCode string `json:"postalCode" binding:"required"`
+	Country    string `json:"country" binding:"required,len=2,alpha"`
+}
+
+// String formats the address on one line for customer_address
+func (a Address) String() string {
+	parts := []string{a.Line1, a.Line2, a.City, strings.TrimSpace(a.Region + " " + a.PostalCode), a.Country}
+	nonEmpty := parts[:0]
+	for _, p := range parts {
+		if p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return strings.Join(nonEmpty, ", ")
+}
+
+// ShippingMethod is an admin-defined way to ship an order. Amounts are in
+// baseCurrency and converted to the order currency when quoted. The cost is
+// BaseRate plus PerKgRate for every started kilogram, and drops to zero once
+// the merchandise subtotal reaches FreeShippingThreshold. Empty Country or
+// Region match any destination; a zero MaxWeight means no upper limit.
+type ShippingMethod struct {
+	ID                    int    `json:"id"`
+	Code                  string `json:"code" binding:"required,max=50"`
+	Name                  string `json:"name" binding:"required"`
+	Country               string `json:"country" binding:"omitempty,len=2,alpha"`
+	Region                string `json:"region"`
+	BaseRate              Money  `json:"baseRate" binding:"min=0"`
+	PerKgRate             Money  `json:"perKgRate" binding:"min=0"`
+	MinWeight             int    `json:"minWeight" binding:"min=0"`
+	MaxWeight             int    `json:"maxWeight" binding:"min=0"`
+	FreeShippingThreshold Money  `json:"freeShippingThreshold" binding:"min=0"`
+	Active                bool   `json:"active"`
+}
+
+// ShippingQuote is the cost of one shipping method for a cart
+type ShippingQuote struct {
+	Method   string `json:"method"`
+	Name     string `json:"name"`
+	Cost     Money  `json:"cost"`
+	Currency string `json:"currency"`
+	Free     bool   `json:"free"`
+}
+
+// defaultShippingMethods seeds Postgres and the in-memory store
+var defaultShippingMethods = []ShippingMethod{
+	{Code: "standard", Name: "Standard shipping", BaseRate: 599, FreeShippingThreshold: 7500, Active: true},
+	{Code: "express", Name: "Express shipping", BaseRate: 1499, PerKgRate: 150, Active: true},
+}
+
+// In-memory shipping methods used when running on mock data
+var (
+	mockShippingMu       sync.RWMutex
+	mockShippingMethods  = seedMockShippingMethods()
+	mockShippingMethodID = len(defaultShippingMethods)
+)
+
+func seedMockShippingMethods() []ShippingMethod {
+	methods := make([]ShippingMethod, len(defaultShippingMethods))
+	for i, m := range defaultShippingMethods {
+		m.ID = i + 1
+		methods[i] = m
+	}
+	return methods
+}
+
+// loadShippingMethods returns all shipping methods ordered by ID
+func loadShippingMethods(ctx context.Context) ([]ShippingMethod, error) {
+	if db == nil {
+		mockShippingMu.RLock()
+		defer mockShippingMu.RUnlock()
+		return append([]ShippingMethod(nil), mockShippingMethods...), nil
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT id, code, name, country, region, base_rate, per_kg_rate, min_weight_grams, max_weight_grams, free_shipping_threshold, active FROM shipping_methods ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	methods := make([]ShippingMethod, 0)
+	for rows.Next() {
+		var m ShippingMethod
+		if err := rows.Scan(&m.ID, &m.Code, &m.Name, &m.Country, &m.Region, &m.BaseRate, &m.PerKgRate, &m.MinWeight, &m.MaxWeight, &m.FreeShippingThreshold, &m.Active); err != nil {
+			return nil, err
+		}
+		// CHAR columns come back padded
+		m.Country = strings.TrimSpace(m.Country)
+		methods = append(methods, m)
+	}
+	return methods, rows.Err()
+}
+
+// serves reports whether the method ships to the destination at this weight
+func (m ShippingMethod) serves(country, region string, grams int) bool {
+	if !m.Active {
+		return false
+	}
+	if m.Country != "" && !strings.EqualFold(m.Country, country) {
+		return false
+	}
+	if m.Region != "" && !strings.EqualFold(m.Region, region) {
+		return false
+	}
+	if grams < m.MinWeight || (m.MaxWeight > 0 && grams > m.MaxWeight) {
+		return false
+	}
+	return true
+}
+
+// normalizeAddress copies a structured shipping address onto the legacy
+// address and tax location fields
+func normalizeAddress(customer *Customer) {
+	if a := customer.ShippingAddress; a != nil {
+		a.Country = strings.ToUpper(a.Country)
+		customer.Address = a.String()
+		customer.Country = a.Country
+		customer.Region = a.Region
+	}
+	customer.Country = strings.ToUpper(customer.Country)
+}
+
+// quoteShipping returns every method serving the order's destination,
+// cheapest first. Item prices must already be in the order currency; the
+// free shipping threshold applies to the subtotal after discounts.
+func quoteShipping(ctx context.Context, order *Order, catalog map[int]Product) ([]ShippingQuote, error) {
+	methods, err := loadShippingMethods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rate, err := conversionRate(ctx, baseCurrency, order.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	var subtotal Money
+	grams := 0
+	for _, item := range order.Items {
+		subtotal += item.Price.Mul(item.Quantity) - item.Discount
+		grams += catalog[item.ID].Weight * item.Quantity
+	}
+	kg := (grams + 999) / 1000
+
+	quotes := make([]ShippingQuote, 0)
+	for _, m := range methods {
+		if !m.serves(order.Customer.Country, order.Customer.Region, grams) {
+			continue
+		}
+		q := ShippingQuote{Method: m.Code, Name: m.Name, Currency: order.Currency}
+		threshold := m.FreeShippingThreshold.MulRate(rate)
+		if m.FreeShippingThreshold > 0 && subtotal >= threshold {
+			q.Free = true
+		} else {
+			q.Cost = (m.BaseRate + m.PerKgRate.Mul(kg)).MulRate(rate)
+		}
+		quotes = append(quotes, q)
+	}
+	sort.SliceStable(quotes, func(i, j int) bool { return quotes[i].Cost < quotes[j].Cost })
+	return quotes, nil
+}
+
+// applyShipping sets the order's shipping method and cost. With no method
+// chosen the cheapest available one is used.
+func applyShipping(ctx context.Context, order *Order, catalog map[int]Product) error {
+	quotes, err := quoteShipping(ctx, order, catalog)
+	if err != nil {
+		return err
+	}
+	for _, q := range quotes {
+		if order.ShippingMethod == "" || strings.EqualFold(order.ShippingMethod, q.Method) {
+			order.ShippingMethod = q.Method
+			order.Shipping = q.Cost
+			return nil
+		}
+	}
+	return errShippingUnavailable
+}
+
+// loadCatalog fetches the products with the given IDs
+func loadCatalog(ctx context.Context, ids []int) (map[int]Product, error) {
+	catalog := make(map[int]Product, len(ids))
+	if db == nil {
+		for _, p := range mockProducts {
+			catalog[p.ID] = p
+		}
+		return catalog, nil
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT id, name, description, price, currency, category, image, stock, weight_grams FROM products WHERE id = ANY($1) AND deleted_at IS NULL`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var p Product
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Currency, &p.Category, &p.Image, &p.Stock, &p.Weight); err != nil {
+			return nil, err
+		}
+		catalog[p.ID] = p
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	products := make([]Product, 0, len(catalog))
+	for _, p := range catalog {
+		products = append(products, p)
+	}
+	if err := attachVariants(ctx, products); err != nil {
+		return nil, err
+	}
+	for _, p := range products {
+		catalog[p.ID] = p
+	}
+	return catalog, nil
+}
+
+// parseCartItems parses the "id:quantity,id:quantity" items query parameter
+func parseCartItems(s string) ([]OrderItem, error) {
+	var items []OrderItem
+	for _, part := range strings.Split(s, ",") {
+		idStr, qtyStr, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			qtyStr = "1"
+		}
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid product ID %q", idStr)
+		}
+		qty, err := strconv.Atoi(qtyStr)
+		if err != nil || qty < 1 {
+			return nil, fmt.Errorf("invalid quantity %q", qtyStr)
+		}
+		items = append(items, OrderItem{ID: id, Quantity: qty})
+	}
+	return items, nil
+}
+
+// getShippingQuote handles GET /api/shipping/quote?items=1:2,3:1&country=US
+func getShippingQuote(c *gin.Context) {
+	ctx := c.Request.Context()
+	items, err := parseCartItems(c.Query("items"))
+	if err != nil || len(items) == 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{
+			Field:   "items",
+			Rule:    "format",
+			Message: "must be a list of id:quantity pairs",
+		})
+		return
+	}
+
+	ids := make([]int, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	catalog, err := loadCatalog(ctx, ids)
+	if err != nil {
+		respondInternalError(c, "Failed to load products", err)
+		return
+	}
+	for _, item := range items {
+		if _, ok := catalog[item.ID]; !ok {
+			respondError(c, ErrProductNotFound, fmt.Sprintf("Product with ID %d does not exist", item.ID))
+			return
+		}
+	}
+
+	order := Order{
+		Items:    items,
+		Currency: c.Query("currency"),
+		Customer: Customer{Country: c.Query("country"), Region: c.Query("region")},
+	}
+	normalizeAddress(&order.Customer)
+	if err := priceOrder(ctx, &order, catalog); err != nil {
+		respondCurrencyError(c, order.Currency, err)
+		return
+	}
+	quotes, err := quoteShipping(ctx, &order, catalog)
+	if err != nil {
+		respondCurrencyError(c, order.Currency, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    quotes,
+		"total":   len(quotes),
+	})
+}
+
+// validateShippingMethod normalizes method and reports invalid fields
+func validateShippingMethod(method *ShippingMethod) []FieldError {
+	method.Code = strings.ToLower(strings.TrimSpace(method.Code))
+	method.Country = strings.ToUpper(method.Country)
+	method.Region = strings.TrimSpace(method.Region)
+
+	var details []FieldError
+	if method.MaxWeight > 0 && method.MaxWeight < method.MinWeight {
+		details = append(details, FieldError{Field: "maxWeight", Rule: "gtefield", Message: "must be at least minWeight"})
+	}
+	if method.Region != "" && method.Country == "" {
+		details = append(details, FieldError{Field: "region", Rule: "required_with", Message: "requires a country"})
+	}
+	return details
+}
+
+// getShippingMethods handles GET /api/admin/shipping-methods
+func getShippingMethods(c *gin.Context) {
+	methods, err := loadShippingMethods(c.Request.Context())
+	if err != nil {
+		respondInternalError(c, "Failed to fetch shipping methods", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    methods,
+		"total":   len(methods),
+	})
+}
+
+// createShippingMethod handles POST /api/admin/shipping-methods
+func createShippingMethod(c *gin.Context) {
+	ctx := c.Request.Context()
+	var method ShippingMethod
+	if err := c.ShouldBindJSON(&method); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	if details := validateShippingMethod(&method); len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", details...)
+		return
+	}
+
+	if db != nil {
+		err := db.QueryRowContext(ctx, `INSERT INTO shipping_methods (code, name, country, region, base_rate, per_kg_rate, min_weight_grams, max_weight_grams, free_shipping_threshold, active) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING id`,
+			method.Code, method.Name, method.Country, method.Region, method.BaseRate, method.PerKgRate, method.MinWeight, method.MaxWeight, method.FreeShippingThreshold, method.Active).Scan(&method.ID)
+		if err != nil {
+			if isUniqueViolation(err) {
+				respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "code", Rule: "unique", Message: "is already in use"})
+				return
+			}
+			respondInternalError(c, "Failed to create shipping method", err)
+			return
+		}
+	} else {
+		mockShippingMu.Lock()
+		for _, m := range mockShippingMethods {
+			if m.Code == method.Code {
+				mockShippingMu.Unlock()
+				respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "code", Rule: "unique", Message: "is already in use"})
+				return
+			}
+		}
+		mockShippingMethodID++
+		method.ID = mockShippingMethodID
+		mockShippingMethods = append(mockShippingMethods, method)
+		mockShippingMu.Unlock()
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    method,
+		"message": "Shipping method created successfully",
+	})
+}
+
+// updateShippingMethod handles PUT /api/admin/shipping-methods/:id
+func updateShippingMethod(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid shipping method ID")
+		return
+	}
+	var method ShippingMethod
+	if err := c.ShouldBindJSON(&method); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	if details := validateShippingMethod(&method); len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", details...)
+		return
+	}
+	method.ID = id
+
+	found := false
+	if db != nil {
+		result, err := db.ExecContext(ctx, `UPDATE shipping_methods SET code=$1, name=$2, country=$3, region=$4, base_rate=$5, per_kg_rate=$6, min_weight_grams=$7, max_weight_grams=$8, free_shipping_threshold=$9, active=$10 WHERE id=$11`,
+			method.Code, method.Name, method.Country, method.Region, method.BaseRate, method.PerKgRate, method.MinWeight, method.MaxWeight, method.FreeShippingThreshold, method.Active, id)
+		if err != nil {
+			if isUniqueViolation(err) {
+				respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "code", Rule: "unique", Message: "is already in use"})
+				return
+			}
+			respondInternalError(c, "Failed to update shipping method", err)
+			return
+		}
+		n, _ := result.RowsAffected()
+		found = n > 0
+	} else {
+		mockShippingMu.Lock()
+		for i := range mockShippingMethods {
+			if mockShippingMethods[i].ID == id {
+				mockShippingMethods[i] = method
+				found = true
+			}
+		}
+		mockShippingMu.Unlock()
+	}
+	if !found {
+		respondError(c, ErrShippingMethodNotFound, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    method,
+		"message": "Shipping method updated successfully",
+	})
+}
+
+// deleteShippingMethod handles DELETE /api/admin/shipping-methods/:id
+func deleteShippingMethod(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid shipping method ID")
+		return
+	}
+
+	found := false
+	if db != nil {
+		result, err := db.ExecContext(ctx, `DELETE FROM shipping_methods WHERE id = $1`, id)
+		if err != nil {
+			respondInternalError(c, "Failed to delete shipping method", err)
+			return
+		}
+		n, _ := result.RowsAffected()
+		found = n > 0
+	} else {
+		mockShippingMu.Lock()
+		for i := range mockShippingMethods {
+			if mockShippingMethods[i].ID == id {
+				mockShippingMethods = append(mockShippingMethods[:i], mockShippingMethods[i+1:]...)
+				found = true
+				break
+			}
+		}
+		mockShippingMu.Unlock()
+	}
+	if !found {
+		respondError(c, ErrShippingMethodNotFound, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Shipping method deleted successfully",
+	})
+}
+
+// seedShippingMethodsIfEmpty inserts defaultShippingMethods into an empty
+// shipping_methods table
+func seedShippingMethodsIfEmpty() error {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM shipping_methods`).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	for _, m := range defaultShippingMethods {
+		_, err := db.Exec(`INSERT INTO shipping_methods (code, name, country, region, base_rate, per_kg_rate, min_weight_grams, max_weight_grams, free_shipping_threshold, active) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
+			m.Code, m.Name, m.Country, m.Region, m.BaseRate, m.PerKgRate, m.MinWeight, m.MaxWeight, m.FreeShippingThreshold, m.Active)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
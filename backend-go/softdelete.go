@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// deletedRetention is how long soft-deleted rows are kept before the
+// retention job purges them; zero keeps them forever
+var deletedRetention = getenvDuration("DELETED_RETENTION", 90*24*time.Hour)
+
+// includeDeleted reports whether a listing should include soft-deleted rows:
+// the request asks for it with ?includeDeleted=true and comes from an admin
+// session
+func includeDeleted(c *gin.Context) bool {
+	if ok, _ := strconv.ParseBool(c.Query("includeDeleted")); !ok {
+		return false
+	}
+	user, ok := sessionUser(c)
+	return ok && user.Role == "admin"
+}
+
+// restoreProduct handles POST /api/admin/products/:id/restore
+func restoreProduct(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid product ID")
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, "Failed to restore product", err)
+		return
+	}
+	defer tx.Rollback()
+	before, err := snapshotRow(ctx, tx, "products", "id", id)
+	if err != nil {
+		respondInternalError(c, "Failed to restore product", err)
+		return
+	}
+
+	var product Product
+	err = tx.QueryRowContext(ctx, `UPDATE products SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING id, COALESCE(sku, ''), name, description, price, currency, category, image, stock, reorder_threshold, weight_grams`, id).
+		Scan(&product.ID, &product.SKU, &product.Name, &product.Description, &product.Price, &product.Currency, &product.Category, &product.Image, &product.Stock, &product.ReorderThreshold, &product.Weight)
+	if err != nil {
+		respondRestoreError(c, err, ErrProductNotFound, "product")
+		return
+	}
+	after, err := snapshotRow(ctx, tx, "products", "id", id)
+	if err != nil {
+		respondInternalError(c, "Failed to restore product", err)
+		return
+	}
+	if err := recordAudit(c, tx, "restore", "product", strconv.Itoa(id), before, after); err != nil {
+		respondInternalError(c, "Failed to restore product", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, "Failed to restore product", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    product,
+		"message": "Product restored successfully",
+	})
+}
+
+// restoreUser handles POST /api/admin/users/:id/restore
+func restoreUser(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid user ID")
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, "Failed to restore user", err)
+		return
+	}
+	defer tx.Rollback()
+	before, err := snapshotRow(ctx, tx, "users", "id", id)
+	if err != nil {
+		respondInternalError(c, "Failed to restore user", err)
+		return
+	}
+
+	var user User
+	err = tx.QueryRowContext(ctx, `UPDATE users SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL
+		RETURNING id, username, email, name, role`, id).
+		Scan(&user.ID, &user.Username, &user.Email, &user.Name, &user.Role)
+	if err != nil {
+		respondRestoreError(c, err, ErrUserNotFound, "user")
+		return
+	}
+	after, err := snapshotRow(ctx, tx, "users", "id", id)
+	if err != nil {
+		respondInternalError(c, "Failed to restore user", err)
+		return
+	}
+	if err := recordAudit(c, tx, "restore", "user", strconv.Itoa(id), before, after); err != nil {
+		respondInternalError(c, "Failed to restore user", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, "Failed to restore user", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    user,
+		"message": "User restored successfully",
+	})
+}
+
+// restoreOrder handles POST /api/admin/orders/:orderId/restore
+func restoreOrder(c *gin.Context) {
+	ctx := c.Request.Context()
+	orderID := c.Param("orderId")
+
+	if db != nil {
+		if _, err := uuid.Parse(orderID); err != nil {
+			respondError(c, ErrOrderNotFound, "")
+			return
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			respondInternalError(c, "Failed to restore order", err)
+			return
+		}
+		defer tx.Rollback()
+		before, err := snapshotRow(ctx, tx, "orders", "id", orderID)
+		if err != nil {
+			respondInternalError(c, "Failed to restore order", err)
+			return
+		}
+		var order Order
+		err = tx.QueryRowContext(ctx, `UPDATE orders SET deleted_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL
+			RETURNING id, order_number, status, total, currency`, orderID).
+			Scan(&order.ID, &order.OrderNumber, &order.Status, &order.Total, &order.Currency)
+		if err != nil {
+			respondRestoreError(c, err, ErrOrderNotFound, "order")
+			return
+		}
+		after, err := snapshotRow(ctx, tx, "orders", "id", orderID)
+		if err != nil {
+			respondInternalError(c, "Failed to restore order", err)
+			return
+		}
+		if err := recordAudit(c, tx, "restore", "order", orderID, before, after); err != nil {
+			respondInternalError(c, "Failed to restore order", err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			respondInternalError(c, "Failed to restore order", err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"data":    order,
+			"message": "Order restored successfully",
+		})
+		return
+	}
+
+	ordersMu.Lock()
+	defer ordersMu.Unlock()
+	order, ok := orders[orderID]
+	if !ok || order.DeletedAt == nil {
+		respondError(c, ErrOrderNotFound, "No deleted order with this ID")
+		return
+	}
+	before := order
+	order.DeletedAt = nil
+	if err := recordAudit(c, nil, "restore", "order", orderID, before, order); err != nil {
+		respondInternalError(c, "Failed to record audit entry", err)
+		return
+	}
+	orders[orderID] = order
+	c.JSON(http.StatusOK, OrderDetailResponse{Success: true, Data: order})
+}
+
+// respondRestoreError reports a failed restore; no row means there is no
+// deleted row with that ID
+func respondRestoreError(c *gin.Context, err error, notFound ErrorCode, what string) {
+	if errors.Is(err, sql.ErrNoRows) {
+		respondError(c, notFound, "No deleted "+what+" with this ID")
+		return
+	}
+	respondInternalError(c, "Failed to restore "+what, err)
+}
+
+// purgeDeleted permanently removes rows soft-deleted before cutoff. Products
+// still referenced by an order are kept so order history stays intact.
+func purgeDeleted(ctx context.Context, cutoff time.Time) (map[string]int64, error) {
+	purged := make(map[string]int64)
+	if db == nil {
+		ordersMu.Lock()
+		for id, o := range orders {
+			if o.DeletedAt != nil && o.DeletedAt.Before(cutoff) {
+				delete(orders, id)
+				purged["orders"]++
+			}
+		}
+		ordersMu.Unlock()
+		return purged, nil
+	}
+
+	queries := []struct{ table, query string }{
+		{"orders", `DELETE FROM orders WHERE deleted_at < $1`},
+		{"products", `DELETE FROM products p WHERE deleted_at < $1 AND NOT EXISTS (SELECT 1 FROM order_items oi WHERE oi.product_id = p.id)`},
+		{"users", `DELETE FROM users WHERE deleted_at < $1`},
+	}
+	var imageKeys []string
+	for _, q := range queries {
+		if q.table == "products" {
+			// Image files of purged products go once their rows are gone
+			keys, err := queryImageKeys(ctx, `SELECT pi.storage_key, pi.renditions FROM product_images pi JOIN products p ON p.id = pi.product_id
+				WHERE p.deleted_at < $1 AND NOT EXISTS (SELECT 1 FROM order_items oi WHERE oi.product_id = p.id)`, cutoff)
+			if err != nil {
+				return purged, err
+			}
+			imageKeys = keys
+		}
+		result, err := db.ExecContext(ctx, q.query, cutoff)
+		if err != nil {
+			return purged, err
+		}
+		purged[q.table], _ = result.RowsAffected()
+	}
+	purgeImages(ctx, imageKeys)
+	return purged, nil
+}
+
+// startRetentionPurger purges soft-deleted rows older than deletedRetention
+// periodically until ctx is done
+func startRetentionPurger(ctx context.Context, interval time.Duration) {
+	if deletedRetention <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purged, err := purgeDeleted(ctx, time.Now().Add(-deletedRetention))
+				if err != nil {
+					slog.Error("Soft-delete purge failed", "error", err)
+				} else if purged["orders"]+purged["products"]+purged["users"] > 0 {
+					slog.Info("Purged soft-deleted rows", "orders", purged["orders"], "products", purged["products"], "users", purged["users"])
+				}
+			}
+		}
+	}()
+}
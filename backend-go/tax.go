@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TaxRule is an admin-managed tax rate. Empty Country, Region or Category
+// match anything; when several rules match a line the most specific one wins
+// (country over region over category), ties going to the lowest ID.
+type TaxRule struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name" binding:"required"`
+	Country  string `json:"country" binding:"omitempty,len=2,alpha"`
+	Region   string `json:"region"`
+	Category string `json:"category"`
+	// Rate is a fraction, e.g. "0.08" for 8%
+	Rate json.Number `json:"rate" binding:"required"`
+	// Inclusive rules treat catalog prices as already containing the tax
+	Inclusive bool `json:"inclusive"`
+}
+
+// defaultTaxRules seeds Postgres and the in-memory store
+var defaultTaxRules = []TaxRule{
+	{Name: "Standard rate", Rate: "0.08"},
+	{Name: "Books reduced rate", Category: "books", Rate: "0.04"},
+}
+
+// In-memory tax rules used when running on mock data
+var (
+	mockTaxRulesMu sync.RWMutex
+	mockTaxRules   = seedMockTaxRules()
+	mockTaxRuleID  = len(defaultTaxRules)
+)
+
+func seedMockTaxRules() []TaxRule {
+	rules := make([]TaxRule, len(defaultTaxRules))
+	for i, r := range defaultTaxRules {
+		r.ID = i + 1
+		rules[i] = r
+	}
+	return rules
+}
+
+// parseTaxRate validates a rate and returns it as an exact fraction. Rates are
+// stored as NUMERIC(7,6).
+func parseTaxRate(rate json.Number) (*big.Rat, error) {
+	r, err := parseDecimal(rate.String(), 7, 6)
+	if err != nil || r.Sign() < 0 || r.Cmp(big.NewRat(1, 1)) >= 0 {
+		return nil, fmt.Errorf("rate %q must be a decimal between 0 and 1 with at most 6 decimal places", rate)
+	}
+	return r, nil
+}
+
+// validateTaxRule normalizes rule and reports invalid fields
+func validateTaxRule(rule *TaxRule) []FieldError {
+	rule.Country = strings.ToUpper(rule.Country)
+	rule.Region = strings.TrimSpace(rule.Region)
+	rule.Category = strings.TrimSpace(rule.Category)
+
+	var details []FieldError
+	if _, err := parseTaxRate(rule.Rate); err != nil {
+		details = append(details, FieldError{Field: "rate", Rule: "range", Message: "must be a decimal between 0 and 1 with at most 6 decimal places"})
+	}
+	if rule.Region != "" && rule.Country == "" {
+		details = append(details, FieldError{Field: "region", Rule: "required_with", Message: "requires a country"})
+	}
+	return details
+}
+
+// matches reports whether the rule applies and how specific the match is
+func (r TaxRule) matches(country, region, category string) (int, bool) {
+	score := 0
+	if r.Country != "" {
+		if !strings.EqualFold(r.Country, country) {
+			return 0, false
+		}
+		score += 4
+	}
+	if r.Region != "" {
+		if !strings.EqualFold(r.Region, region) {
+			return 0, false
+		}
+		score += 2
+	}
+	if r.Category != "" {
+		if !strings.EqualFold(r.Category, category) {
+			return 0, false
+		}
+		score++
+	}
+	return score, true
+}
+
+// findTaxRule returns the most specific rule for a line, or nil if none apply
+func findTaxRule(rules []TaxRule, country, region, category string) *TaxRule {
+	var best *TaxRule
+	bestScore := -1
+	for i := range rules {
+		score, ok := rules[i].matches(country, region, category)
+		if !ok {
+			continue
+		}
+		if score > bestScore || (score == bestScore && rules[i].ID < best.ID) {
+			best, bestScore = &rules[i], score
+		}
+	}
+	return best
+}
+
+// loadTaxRules returns all tax rules ordered by ID
+func loadTaxRules(ctx context.Context) ([]TaxRule, error) {
+	if db == nil {
+		mockTaxRulesMu.RLock()
+		defer mockTaxRulesMu.RUnlock()
+		return append([]TaxRule(nil), mockTaxRules...), nil
+	}
+
+	rows, err := db.QueryContext(ctx, `SELECT id, name, country, region, category, rate, inclusive FROM tax_rules ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := make([]TaxRule, 0)
+	for rows.Next() {
+		var r TaxRule
+		var rate string
+		if err := rows.Scan(&r.ID, &r.Name, &r.Country, &r.Region, &r.Category, &rate, &r.Inclusive); err != nil {
+			return nil, err
+		}
+		// CHAR columns come back padded
+		r.Country = strings.TrimSpace(r.Country)
+		r.Rate = json.Number(rate)
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// applyTax sets the per-line tax on every order item from the customer's
+// country and region and each product's category, after line discounts. Inclusive lines carry
+// their tax inside the price; exclusive lines add it on top. Totals must be
+// recomputed afterwards.
+func applyTax(ctx context.Context, order *Order, catalog map[int]Product) error {
+	rules, err := loadTaxRules(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range order.Items {
+		item := &order.Items[i]
+		item.TaxName, item.TaxRate, item.TaxAmount, item.TaxInclusive = "", "0", 0, false
+
+		rule := findTaxRule(rules, order.Customer.Country, order.Customer.Region, catalog[item.ID].Category)
+		if rule == nil {
+			continue
+		}
+		rate, err := parseTaxRate(rule.Rate)
+		if err != nil {
+			return err
+		}
+		line := item.Price.Mul(item.Quantity) - item.Discount
+		if rule.Inclusive {
+			// gross * rate / (1 + rate) is the tax already inside the price
+			rate = new(big.Rat).Quo(rate, new(big.Rat).Add(big.NewRat(1, 1), rate))
+		}
+		item.TaxName = rule.Name
+		item.TaxRate = rule.Rate
+		item.TaxAmount = line.MulRate(rate)
+		item.TaxInclusive = rule.Inclusive
+	}
+	return nil
+}
+
+// getTaxRules handles GET /api/admin/tax-rules
+func getTaxRules(c *gin.Context) {
+	rules, err := loadTaxRules(c.Request.Context())
+	if err != nil {
+		respondInternalError(c, "Failed to fetch tax rules", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    rules,
+		"total":   len(rules),
+	})
+}
+
+// createTaxRule handles POST /api/admin/tax-rules
+func createTaxRule(c *gin.Context) {
+	ctx := c.Request.Context()
+	var rule TaxRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	if details := validateTaxRule(&rule); len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", details...)
+		return
+	}
+
+	if db != nil {
+		err := db.QueryRowContext(ctx, `INSERT INTO tax_rules (name, country, region, category, rate, inclusive) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id`,
+			rule.Name, rule.Country, rule.Region, rule.Category, rule.Rate.String(), rule.Inclusive).Scan(&rule.ID)
+		if err != nil {
+			respondInternalError(c, "Failed to create tax rule", err)
+			return
+		}
+	} else {
+		mockTaxRulesMu.Lock()
+		mockTaxRuleID++
+		rule.ID = mockTaxRuleID
+		mockTaxRules = append(mockTaxRules, rule)
+		mockTaxRulesMu.Unlock()
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    rule,
+		"message": "Tax rule created successfully",
+	})
+}
+
+// updateTaxRule handles PUT /api/admin/tax-rules/:id
+func updateTaxRule(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid tax rule ID")
+		return
+	}
+	var rule TaxRule
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	if details := validateTaxRule(&rule); len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", details...)
+		return
+	}
+	rule.ID = id
+
+	found := false
+	if db != nil {
+		result, err := db.ExecContext(ctx, `UPDATE tax_rules SET name=$1, country=$2, region=$3, category=$4, rate=$5, inclusive=$6 WHERE id=$7`,
+			rule.Name, rule.Country, rule.Region, rule.Category, rule.Rate.String(), rule.Inclusive, id)
+		if err != nil {
+			respondInternalError(c, "Failed to update tax rule", err)
+			return
+		}
+		n, _ := result.RowsAffected()
+		found = n > 0
+	} else {
+		mockTaxRulesMu.Lock()
+		for i := range mockTaxRules {
+			if mockTaxRules[i].ID == id {
+				mockTaxRules[i] = rule
+				found = true
+			}
+		}
+		mockTaxRulesMu.Unlock()
+	}
+	if !found {
+		respondError(c, ErrTaxRuleNotFound, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    rule,
+		"message": "Tax rule updated successfully",
+	})
+}
+
+// deleteTaxRule handles DELETE /api/admin/tax-rules/:id
+func deleteTaxRule(c *gin.Context) {
+	ctx := c.Request.Context()
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid tax rule ID")
+		return
+	}
+
+	found := false
+	if db != nil {
+		result, err := db.ExecContext(ctx, `DELETE FROM tax_rules WHERE id = $1`, id)
+		if err != nil {
+			respondInternalError(c, "Failed to delete tax rule", err)
+			return
+		}
+		n, _ := result.RowsAffected()
+		found = n > 0
+	} else {
+		mockTaxRulesMu.Lock()
+		for i := range mockTaxRules {
+			if mockTaxRules[i].ID == id {
+				mockTaxRules = append(mockTaxRules[:i], mockTaxRules[i+1:]...)
+				found = true
+				break
+			}
+		}
+		mockTaxRulesMu.Unlock()
+	}
+	if !found {
+		respondError(c, ErrTaxRuleNotFound, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Tax rule deleted successfully",
+	})
+}
+
+// seedTaxRulesIfEmpty inserts defaultTaxRules into an empty tax_rules table
+func seedTaxRulesIfEmpty() error {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM tax_rules`).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	for _, r := range defaultTaxRules {
+		_, err := db.Exec(`INSERT INTO tax_rules (name, country, region, category, rate, inclusive) VALUES ($1,$2,$3,$4,$5,$6)`,
+			r.Name, r.Country, r.Region, r.Category, r.Rate.String(), r.Inclusive)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/XSAM/otelsql"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "vue-shop-backend"
+
+// tracer is used for spans that aren't created by the gin or SQL instrumentation
+var tracer = otel.Tracer(tracerName)
+
+// newSpanExporter builds the exporter selected by OTEL_TRACES_EXPORTER:
+// "none" (default), "stdout" or "otlp". The OTLP exporter honors the standard
+// OTEL_EXPORTER_OTLP_* variables, e.g. OTEL_EXPORTER_OTLP_ENDPOINT.
+func newSpanExporter(ctx context.Context, name string) (sdktrace.SpanExporter, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "otlp":
+		return otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q", name)
+	}
+}
+
+// installTracerProvider registers a global tracer provider that batches spans
+// to exp. Passing tracetest.NewInMemoryExporter() lets spans be inspected
+// locally after a ForceFlush.
+func installTracerProvider(exp sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(getenv("OTEL_SERVICE_NAME", tracerName)),
+		semconv.ServiceVersion(version),
+	)
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return tp
+}
+
+// setupTracing configures tracing from the environment and returns a
+// shutdown function that flushes pending spans.
+func setupTracing(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	exp, err := newSpanExporter(ctx, os.Getenv("OTEL_TRACES_EXPORTER"))
+	if err != nil {
+		return nil, err
+	}
+	if exp == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+	tp := installTracerProvider(exp)
+	slog.Info("Tracing enabled", "exporter", os.Getenv("OTEL_TRACES_EXPORTER"))
+	return tp.Shutdown, nil
+}
+
+// TracingMiddleware starts a span for every request, continuing any trace
+// passed in the traceparent header.
+func TracingMiddleware() gin.HandlerFunc {
+	return otelgin.Middleware(tracerName, otelgin.WithFilter(func(r *http.Request) bool {
+		// Probes and scrapes would drown out real traffic
+		switch r.URL.Path {
+		case "/health", "/livez", "/readyz", "/metrics":
+			return false
+		}
+		return true
+	}))
+}
+
+// openTracedDB opens a database handle whose queries, execs and transactions
+// are recorded as spans.
+func openTracedDB(driverName, dsn string) (*sql.DB, error) {
+	return otelsql.Open(driverName, dsn,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	)
+}
+
+// traceAttrs returns the log attributes identifying the current span
+func traceAttrs(ctx context.Context) []any {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return nil
+	}
+	return []any{"trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String()}
+}
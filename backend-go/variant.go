@@ -0,0 +1,482 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// ProductVariant is a purchasable version of a product, such as a size.
+// Options describe it (e.g. {"size": "M"}); Price, when set, replaces the
+// product price. A product with variants is stocked per variant and its own
+// Stock is the sum of theirs.
+type ProductVariant struct {
+	ID        int               `json:"id"`
+	ProductID int               `json:"productId"`
+	SKU       string            `json:"sku" binding:"required,max=64"`
+	Options   map[string]string `json:"options"`
+	Price     *Money            `json:"price,omitempty" binding:"omitempty,min=0"`
+	Stock     int               `json:"stock" binding:"min=0"`
+}
+
+// variantError explains why an order line's variant is not valid
+type variantError struct {
+	index  int
+	reason string
+}
+
+func (e *variantError) Error() string { return e.reason }
+
+// variant returns the product's variant with the given ID
+func (p Product) variant(id int) (ProductVariant, bool) {
+	for _, v := range p.Variants {
+		if v.ID == id {
+			return v, true
+		}
+	}
+	return ProductVariant{}, false
+}
+
+// applyVariants checks that every line of a product with variants names one
+// of them and copies its SKU and options onto the line
+func applyVariants(order *Order, catalog map[int]Product) error {
+	for i := range order.Items {
+		item := &order.Items[i]
+		p, ok := catalog[item.ID]
+		if !ok {
+			continue
+		}
+		if len(p.Variants) == 0 {
+			if item.VariantID != 0 {
+				return &variantError{i, fmt.Sprintf("Product %d has no variants", item.ID)}
+			}
+			item.SKU, item.Options = "", nil
+			continue
+		}
+		if item.VariantID == 0 {
+			skus := make([]string, len(p.Variants))
+			for j, v := range p.Variants {
+				skus[j] = fmt.Sprintf("%d (%s)", v.ID, v.SKU)
+			}
+			return &variantError{i, fmt.Sprintf("Product %d requires a variant: %s", item.ID, strings.Join(skus, ", "))}
+		}
+		v, ok := p.variant(item.VariantID)
+		if !ok {
+			return &variantError{i, fmt.Sprintf("Variant %d does not belong to product %d", item.VariantID, item.ID)}
+		}
+		item.SKU, item.Options = v.SKU, v.Options
+	}
+	return nil
+}
+
+// respondVariantError reports a failure from applyVariants
+func respondVariantError(c *gin.Context, verr *variantError) {
+	respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{
+		Field:   fmt.Sprintf("items[%d].variantId", verr.index),
+		Rule:    "variant",
+		Message: verr.reason,
+	})
+}
+
+// variantIDArg converts a variant ID to a nullable column value
+func variantIDArg(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// optionsJSON encodes variant options for a JSONB column
+func optionsJSON(options map[string]string) string {
+	if len(options) == 0 {
+		return "{}"
+	}
+	b, _ := json.Marshal(options)
+	return string(b)
+}
+
+const variantColumns = `id, product_id, sku, options, price, stock`
+
+func scanVariant(row rowScanner) (ProductVariant, error) {
+	var v ProductVariant
+	var options []byte
+	var price sql.NullString
+	if err := row.Scan(&v.ID, &v.ProductID, &v.SKU, &options, &price, &v.Stock); err != nil {
+		return v, err
+	}
+	if err := json.Unmarshal(options, &v.Options); err != nil {
+		return v, err
+	}
+	if price.Valid {
+		m, err := parseMoney(price.String)
+		if err != nil {
+			return v, err
+		}
+		v.Price = &m
+	}
+	return v, nil
+}
+
+// loadVariants fetches the variants of the given products, keyed by product
+func loadVariants(ctx context.Context, productIDs []int) (map[int][]ProductVariant, error) {
+	variants := make(map[int][]ProductVariant)
+	rows, err := db.QueryContext(ctx, `SELECT `+variantColumns+` FROM product_variants WHERE product_id = ANY($1) ORDER BY id`, pq.Array(productIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		v, err := scanVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+		variants[v.ProductID] = append(variants[v.ProductID], v)
+	}
+	return variants, rows.Err()
+}
+
+// attachVariants loads the variants of products from Postgres
+func attachVariants(ctx context.Context, products []Product) error {
+	if db == nil || len(products) == 0 {
+		return nil
+	}
+	ids := make([]int, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+	variants, err := loadVariants(ctx, ids)
+	if err != nil {
+		return err
+	}
+	for i := range products {
+		products[i].Variants = variants[products[i].ID]
+	}
+	return nil
+}
+
+// execer is satisfied by *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// syncProductStock sets the stock of a product with variants to the sum of
+// theirs. The difference, such as stock the product held before it had
+// variants, is booked as an adjustment so the ledger still adds up.
+func syncProductStock(c *gin.Context, tx *sql.Tx, productID int) error {
+	ctx := c.Request.Context()
+	var stock int
+	if err := tx.QueryRowContext(ctx, `SELECT stock FROM products WHERE id = $1 FOR UPDATE`, productID).Scan(&stock); err != nil {
+		return err
+	}
+	var total, variants int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(SUM(stock), 0), COUNT(*) FROM product_variants WHERE product_id = $1`, productID).Scan(&total, &variants); err != nil {
+		return err
+	}
+	if variants == 0 || total == stock {
+		return nil
+	}
+	movement := InventoryMovement{ProductID: productID, Type: movementAdjustment, Quantity: total - stock, Reason: "Product stock set to the sum of its variants"}
+	movement.setActor(c)
+	return moveStock(ctx, tx, &movement)
+}
+
+// seedProductVariants inserts the variants of a seeded product
+func seedProductVariants(ctx context.Context, productID int, variants []ProductVariant) error {
+	for _, v := range variants {
+		var price interface{}
+		if v.Price != nil {
+			price = *v.Price
+		}
+		if _, err := db.ExecContext(ctx, `INSERT INTO product_variants (product_id, sku, options, price, stock) VALUES ($1,$2,$3,$4,$5) ON CONFLICT (sku) DO NOTHING`,
+			productID, v.SKU, optionsJSON(v.Options), price, v.Stock); err != nil {
+			return err
+		}
+	}
+	if len(variants) == 0 {
+		return nil
+	}
+	// Seeded stock isn't booked in the ledger
+	_, err := db.ExecContext(ctx, `UPDATE products SET stock = (SELECT COALESCE(SUM(stock), 0) FROM product_variants WHERE product_id = $1) WHERE id = $1`, productID)
+	return err
+}
+
+// normalizeSKU trims and upper-cases a product or variant SKU
+func normalizeSKU(sku string) string {
+	return strings.ToUpper(strings.TrimSpace(sku))
+}
+
+// validateVariant normalizes a variant and reports invalid fields
+func validateVariant(v *ProductVariant) []FieldError {
+	v.SKU = normalizeSKU(v.SKU)
+	var details []FieldError
+	if v.SKU == "" {
+		details = append(details, FieldError{Field: "sku", Rule: "required", Message: "is required"})
+	}
+	for k, val := range v.Options {
+		if strings.TrimSpace(k) == "" || strings.TrimSpace(val) == "" {
+			details = append(details, FieldError{Field: "options", Rule: "format", Message: "option names and values must not be empty"})
+			break
+		}
+	}
+	return details
+}
+
+// productVariantIDs parses the :id and, if present, :variantId parameters
+func productVariantIDs(c *gin.Context) (productID, variantID int, ok bool) {
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid product ID")
+		return 0, 0, false
+	}
+	if c.Param("variantId") == "" {
+		return productID, 0, true
+	}
+	variantID, err = strconv.Atoi(c.Param("variantId"))
+	if err != nil {
+		respondError(c, ErrInvalidID, "Invalid variant ID")
+		return 0, 0, false
+	}
+	return productID, variantID, true
+}
+
+// productExists reports whether a product that isn't deleted has the ID
+func productExists(ctx context.Context, id int) (bool, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NULL)`, id).Scan(&exists)
+	return exists, err
+}
+
+// getProductVariants handles GET /api/admin/products/:id/variants
+func getProductVariants(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+	productID, _, ok := productVariantIDs(c)
+	if !ok {
+		return
+	}
+	exists, err := productExists(ctx, productID)
+	if err != nil {
+		respondInternalError(c, "Failed to load product", err)
+		return
+	}
+	if !exists {
+		respondError(c, ErrProductNotFound, "")
+		return
+	}
+
+	variants, err := loadVariants(ctx, []int{productID})
+	if err != nil {
+		respondInternalError(c, "Failed to fetch variants", err)
+		return
+	}
+	list := variants[productID]
+	if list == nil {
+		list = []ProductVariant{}
+	}
+	c.JSON(http.StatusOK, gin.H{"success": true, "data": list, "total": len(list)})
+}
+
+// createProductVariant handles POST /api/admin/products/:id/variants
+func createProductVariant(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+	productID, _, ok := productVariantIDs(c)
+	if !ok {
+		return
+	}
+	var variant ProductVariant
+	if err := c.ShouldBindJSON(&variant); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	if details := validateVariant(&variant); len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", details...)
+		return
+	}
+	variant.ProductID = productID
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, "Failed to create variant", err)
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NULL)`, productID).Scan(&exists); err != nil {
+		respondInternalError(c, "Failed to create variant", err)
+		return
+	}
+	if !exists {
+		respondError(c, ErrProductNotFound, "")
+		return
+	}
+	var price interface{}
+	if variant.Price != nil {
+		price = *variant.Price
+	}
+	err = tx.QueryRowContext(ctx, `INSERT INTO product_variants (product_id, sku, options, price, stock) VALUES ($1,$2,$3,$4,0) RETURNING id`,
+		productID, variant.SKU, optionsJSON(variant.Options), price).Scan(&variant.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "sku", Rule: "unique", Message: "is already in use"})
+			return
+		}
+		respondInternalError(c, "Failed to create variant", err)
+		return
+	}
+	if variant.Stock > 0 {
+		movement := InventoryMovement{ProductID: productID, VariantID: variant.ID, Type: movementRestock, Quantity: variant.Stock, Reason: "Initial stock"}
+		movement.setActor(c)
+		if err := moveStock(ctx, tx, &movement); err != nil {
+			respondInternalError(c, "Failed to create variant", err)
+			return
+		}
+	}
+	if err := syncProductStock(c, tx, productID); err != nil {
+		respondInternalError(c, "Failed to create variant", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, "Failed to create variant", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"data":    variant,
+		"message": "Variant created successfully",
+	})
+}
+
+// updateProductVariant handles PUT /api/admin/products/:id/variants/:variantId
+func updateProductVariant(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+	productID, variantID, ok := productVariantIDs(c)
+	if !ok {
+		return
+	}
+	var variant ProductVariant
+	if err := c.ShouldBindJSON(&variant); err != nil {
+		respondValidationError(c, err)
+		return
+	}
+	if details := validateVariant(&variant); len(details) > 0 {
+		respondError(c, ErrValidationFailed, "One or more fields are invalid", details...)
+		return
+	}
+	variant.ID, variant.ProductID = variantID, productID
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, "Failed to update variant", err)
+		return
+	}
+	defer tx.Rollback()
+
+	var price interface{}
+	if variant.Price != nil {
+		price = *variant.Price
+	}
+	// Like a product's, a variant's stock only changes through inventory movements
+	err = tx.QueryRowContext(ctx, `UPDATE product_variants SET sku = $1, options = $2, price = $3 WHERE id = $4 AND product_id = $5 RETURNING stock`,
+		variant.SKU, optionsJSON(variant.Options), price, variantID, productID).Scan(&variant.Stock)
+	if err == sql.ErrNoRows {
+		respondError(c, ErrVariantNotFound, "")
+		return
+	}
+	if err != nil {
+		if isUniqueViolation(err) {
+			respondError(c, ErrValidationFailed, "One or more fields are invalid", FieldError{Field: "sku", Rule: "unique", Message: "is already in use"})
+			return
+		}
+		respondInternalError(c, "Failed to update variant", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, "Failed to update variant", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    variant,
+		"message": "Variant updated successfully",
+	})
+}
+
+// deleteProductVariant handles DELETE /api/admin/products/:id/variants/:variantId.
+// Order lines keep the variant's SKU and options.
+func deleteProductVariant(c *gin.Context) {
+	ctx := c.Request.Context()
+	if db == nil {
+		respondError(c, ErrDatabaseUnavailable, "")
+		return
+	}
+	productID, variantID, ok := productVariantIDs(c)
+	if !ok {
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		respondInternalError(c, "Failed to delete variant", err)
+		return
+	}
+	defer tx.Rollback()
+
+	var sku string
+	var stock int
+	err = tx.QueryRowContext(ctx, `SELECT sku, stock FROM product_variants WHERE id = $1 AND product_id = $2 FOR UPDATE`, variantID, productID).Scan(&sku, &stock)
+	if err == sql.ErrNoRows {
+		respondError(c, ErrVariantNotFound, "")
+		return
+	}
+	if err != nil {
+		respondInternalError(c, "Failed to delete variant", err)
+		return
+	}
+	// Write off the variant's remaining stock so the ledger accounts for it
+	if stock > 0 {
+		movement := InventoryMovement{ProductID: productID, VariantID: variantID, Type: movementAdjustment, Quantity: -stock, Reason: fmt.Sprintf("Variant %s deleted", sku)}
+		movement.setActor(c)
+		if err := moveStock(ctx, tx, &movement); err != nil {
+			respondInternalError(c, "Failed to delete variant", err)
+			return
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM product_variants WHERE id = $1 AND product_id = $2`, variantID, productID); err != nil {
+		respondInternalError(c, "Failed to delete variant", err)
+		return
+	}
+	if err := syncProductStock(c, tx, productID); err != nil {
+		respondInternalError(c, "Failed to delete variant", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		respondInternalError(c, "Failed to delete variant", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Variant deleted successfully",
+	})
+}
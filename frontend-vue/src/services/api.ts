@@ -1,10 +1,20 @@
-import type { Product, User, Order, ApiResponse } from '@/types'
+import type { Product, ProductImage, Category, User, Order, ApiResponse, DestructivePreview, InventoryMovement } from '@/types'
 import { mockApi } from './mockApi'
 
 // API service for backend communication
 const API_BASE_URL = import.meta.env.VITE_API_BASE_URL || 'http://localhost:5000/api'
 const USE_MOCK_API = import.meta.env.VITE_USE_MOCK_API === 'true'
 
+// Random lowercase hex string of the given byte length
+const randomHex = (bytes: number): string =>
+  Array.from(crypto.getRandomValues(new Uint8Array(bytes)), (b) => b.toString(16).padStart(2, '0')).join('')
+
+// Uploaded images come back as paths on the API host, e.g. /images/products/1/ab.png
+export const assetUrl = (url: string): string => (url ? new URL(url, API_BASE_URL).href : url)
+
+// W3C trace-context header so backend spans join the request's trace
+const traceparent = (): string => `00-${randomHex(16)}-${randomHex(8)}-01`
+
 class ApiService {
   // Get auth token from localStorage
   getAuthToken() {
@@ -16,14 +26,21 @@ class ApiService {
     const url = `${API_BASE_URL}${endpoint}`;
     const token = this.getAuthToken();
     
+    // Spread options first so its headers are merged rather than replacing ours
     const config = {
+      ...options,
       headers: {
         'Content-Type': 'application/json',
+        traceparent: traceparent(),
         ...options.headers
-      },
-      ...options
+      }
     };
 
+    // Let the browser set the multipart boundary for uploads
+    if (options.body instanceof FormData) {
+      delete config.headers['Content-Type'];
+    }
+
     // Add Authorization header if token exists
     if (token) {
       config.headers['Authorization'] = `Bearer ${token}`;
@@ -45,6 +62,8 @@ class ApiService {
       if (!response.ok) {
         const error = new Error(data.message || data.error || `HTTP error! status: ${response.status}`);
         error.status = response.status;
+        error.code = data.code;
+        error.details = data.details;
         throw error;
       }
 
@@ -72,20 +91,74 @@ class ApiService {
     return this.request(`/products/${id}`)
   }
 
-  async getCategories(): Promise<ApiResponse<string[]>> {
+  async getCategories(): Promise<ApiResponse<Category[]>> {
     if (USE_MOCK_API) {
       return mockApi.getCategories()
     }
     return this.request('/products/categories')
   }
 
+  // Admin product image endpoints
+  async uploadProductImages(productId: number, files: File[]): Promise<ApiResponse<ProductImage[]>> {
+    const body = new FormData()
+    files.forEach(file => body.append('images', file))
+    return this.request(`/admin/products/${productId}/images`, { method: 'POST', body })
+  }
+
+  async deleteProductImage(productId: number, imageId: number): Promise<ApiResponse<ProductImage[]>> {
+    return this.request(`/admin/products/${productId}/images/${imageId}`, { method: 'DELETE' })
+  }
+
+  // quantity is added to the current stock; variantId is required for products with variants
+  async adjustStock(productId: number, adjustment: { quantity: number; reason: string; type?: string; variantId?: number }): Promise<ApiResponse<InventoryMovement>> {
+    return this.request(`/admin/products/${productId}/stock-adjustments`, {
+      method: 'POST',
+      body: JSON.stringify(adjustment)
+    })
+  }
+
+  // Admin backup endpoints
+  // The backup is kept byte for byte, since re-encoding its rows would break its checksum
+  async downloadBackup(): Promise<Blob> {
+    const headers: Record<string, string> = { traceparent: traceparent() }
+    const token = this.getAuthToken()
+    if (token) {
+      headers['Authorization'] = `Bearer ${token}`
+    }
+    const response = await fetch(`${API_BASE_URL}/admin/export`, { headers })
+    if (!response.ok) {
+      const data = await response.json().catch(() => ({}))
+      throw new Error(data.message || data.error || `HTTP error! status: ${response.status}`)
+    }
+    return response.blob()
+  }
+
+  // Destructive endpoints answer a request without confirmationToken with a
+  // DestructivePreview; repeating it with that token performs the operation
+  async restoreBackup(file: File, confirmationToken?: string): Promise<ApiResponse<Record<string, number> | DestructivePreview>> {
+    return this.request('/admin/import', {
+      method: 'POST',
+      headers: confirmationToken ? { 'X-Confirmation-Token': confirmationToken } : {},
+      body: file
+    })
+  }
+
+  async clearDatabase(preserveAdmins: boolean, confirmationToken?: string): Promise<ApiResponse<Record<string, number> | DestructivePreview>> {
+    return this.request(`/admin/clear?preserveAdmins=${preserveAdmins}`, {
+      method: 'POST',
+      headers: confirmationToken ? { 'X-Confirmation-Token': confirmationToken } : {}
+    })
+  }
+
   // Order endpoints
-  async createOrder(orderData) {
+  // idempotencyKey lets a retried checkout replay the original order instead of creating a new one
+  async createOrder(orderData, idempotencyKey?: string) {
     if (USE_MOCK_API) {
       return mockApi.createOrder(orderData)
     }
     return this.request('/orders', {
       method: 'POST',
+      headers: idempotencyKey ? { 'Idempotency-Key': idempotencyKey } : {},
       body: JSON.stringify(orderData)
     });
   }
@@ -1,3 +1,5 @@
+import type { Category } from '@/types'
+
 // Mock API service with database data
 export interface Product {
   id: number
@@ -87,7 +89,7 @@ const mockProducts: Product[] = [
     name: "Developer Mug",
     description: "Ceramic coffee mug perfect for coding sessions",
     price: 12.99,
-    category: "clothing",
+    category: "home",
     image: "https://images.unsplash.com/photo-1578662996442-48f60103fc96?w=400&h=300&fit=crop&bg=white",
     stock: 100
   },
@@ -165,9 +167,19 @@ export const mockApi = {
   },
 
   getCategories: () => {
-    return new Promise<{ success: boolean; data: string[] }>((resolve) => {
+    return new Promise<{ success: boolean; data: Category[] }>((resolve) => {
       setTimeout(() => {
-        const categories = [...new Set(mockProducts.map(p => p.category))].sort()
+        const slugs = [...new Set(mockProducts.map(p => p.category))].sort()
+        const categories = slugs.map((slug, i) => ({
+          id: i + 1,
+          slug,
+          name: slug.charAt(0).toUpperCase() + slug.slice(1),
+          description: '',
+          parentId: null,
+          sortOrder: i,
+          image: '',
+          productCount: mockProducts.filter(p => p.category === slug).length
+        }))
         resolve({ success: true, data: categories })
       }, 200)
     })
@@ -16,31 +16,40 @@ export const useCartStore = defineStore('cart', () => {
   })
   
   // Actions - similar to Angular service methods
-  const addToCart = (product) => {
-    const existingItem = items.value.find(item => item.id === product.id)
+  // Each variant of a product (e.g. a size) is its own cart line
+  const addToCart = (product, variant = null) => {
+    const key = variant ? `${product.id}:${variant.id}` : `${product.id}`
+    const existingItem = items.value.find(item => item.key === key)
     
     if (existingItem) {
       existingItem.quantity++
     } else {
       items.value.push({
         ...product,
+        variants: undefined,
+        key,
+        variantId: variant?.id,
+        sku: variant?.sku,
+        options: variant?.options,
+        price: variant?.price ?? product.price,
+        stock: variant ? variant.stock : product.stock,
         quantity: 1
       })
     }
   }
   
-  const removeFromCart = (productId) => {
-    const index = items.value.findIndex(item => item.id === productId)
+  const removeFromCart = (key) => {
+    const index = items.value.findIndex(item => item.key === key)
     if (index > -1) {
       items.value.splice(index, 1)
     }
   }
   
-  const updateQuantity = (productId, quantity) => {
-    const item = items.value.find(item => item.id === productId)
+  const updateQuantity = (key, quantity) => {
+    const item = items.value.find(item => item.key === key)
     if (item) {
       if (quantity <= 0) {
-        removeFromCart(productId)
+        removeFromCart(key)
       } else {
         item.quantity = quantity
       }
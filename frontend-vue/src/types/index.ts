@@ -1,11 +1,66 @@
 export interface Product {
   id: number
+  sku?: string
   name: string
   description: string
   price: number
+  currency?: string
   category: string
   image: string
   stock: number
+  // Stock at or below which the product is reported as low; unset uses the server default
+  reorderThreshold?: number
+  weight?: number
+  variants?: ProductVariant[]
+  images?: ProductImage[]
+  // URLs of the main image's thumbnail, medium and large renditions
+  imageRenditions?: Record<string, string>
+}
+
+// Uploaded product image; url is relative to the API host
+export interface ProductImage {
+  id: number
+  productId: number
+  key: string
+  url: string
+  contentType: string
+  size: number
+  width: number
+  height: number
+  renditions: Record<string, ImageRendition>
+  position: number
+  createdAt: string
+}
+
+// Resized copy of an uploaded image, e.g. the "thumbnail" rendition
+export interface ImageRendition {
+  key: string
+  url: string
+  width: number
+  height: number
+  contentType: string
+}
+
+// Category nodes nest through children; productCount includes subcategories
+export interface Category {
+  id: number
+  slug: string
+  name: string
+  description: string
+  parentId: number | null
+  sortOrder: number
+  image: string
+  productCount: number
+  children?: Category[]
+}
+
+export interface ProductVariant {
+  id: number
+  productId: number
+  sku: string
+  options: Record<string, string>
+  price?: number
+  stock: number
 }
 
 export interface User {
@@ -18,6 +73,27 @@ export interface User {
 
 export interface CartItem extends Product {
   quantity: number
+  key?: string
+  variantId?: number
+  sku?: string
+  options?: Record<string, string>
+}
+
+export interface Address {
+  line1: string
+  line2?: string
+  city: string
+  region?: string
+  postalCode: string
+  country: string
+}
+
+export interface ShippingQuote {
+  method: string
+  name: string
+  cost: number
+  currency: string
+  free: boolean
 }
 
 export interface Order {
@@ -27,12 +103,27 @@ export interface Order {
     name: string
     email: string
     address: string
+    country?: string
+    region?: string
+    shippingAddress?: Address
   }
-  items: CartItem[]
+  items: (CartItem & {
+    taxName?: string
+    taxRate?: number
+    taxAmount?: number
+    taxInclusive?: boolean
+    refundedQuantity?: number
+  })[]
   subtotal: number
+  couponCode?: string
+  discount?: number
+  discounts?: { code: string; description: string; amount: number }[]
   shipping: number
+  shippingMethod?: string
   tax: number
   total: number
+  currency?: string
+  refunded?: number
   status: string
   createdAt: Date
   updatedAt: Date
@@ -41,6 +132,31 @@ export interface Order {
 export interface ApiResponse<T> {
   success: boolean
   data: T
+  dryRun?: boolean
   message?: string
   error?: string
+  code?: string
+}
+
+// One entry of a product's stock ledger
+export interface InventoryMovement {
+  id: number
+  productId: number
+  variantId?: number
+  type: 'sale' | 'restock' | 'adjustment' | 'return'
+  quantity: number
+  stockAfter: number
+  reason: string
+  orderId?: string
+  actorId: number | null
+  actor: string
+  createdAt: string
+}
+
+// Row counts a destructive admin operation would delete, and the token confirming it
+export interface DestructivePreview {
+  action: string
+  affected: Record<string, number>
+  confirmationToken: string
+  expiresAt: string
 }
\ No newline at end of file